@@ -0,0 +1,250 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CodeOK 命令执行成功时记录的结果码
+const CodeOK = "OK"
+
+// Record 一条命令执行的审计记录
+type Record struct {
+	// Time 命令开始执行的时间
+	Time time.Time `json:"time"`
+	// Session 命令所属的会话编号, 未通过 ServeConn 处理时为0
+	Session uint64 `json:"session,omitempty"`
+	// User 会话中已认证的用户
+	User string `json:"user,omitempty"`
+	// RemoteAddr 客户端地址
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	// Command 命令名称
+	Command string `json:"command"`
+	// PayloadDigest 客户端发送的数据按命令的敏感字段脱敏之后的 SHA-256 摘要, 十六进制编码, 不记录数据原文
+	PayloadDigest string `json:"payloadDigest,omitempty"`
+	// PayloadSize 客户端发送的数据的字节数
+	PayloadSize int64 `json:"payloadSize"`
+	// Code 执行结果, 成功时为 CodeOK, 失败时为异常码的名称
+	Code string `json:"code"`
+	// Message 失败时的异常信息
+	Message string `json:"message,omitempty"`
+	// Duration 执行耗时, JSON 中为纳秒
+	Duration time.Duration `json:"duration"`
+}
+
+// Sink 审计记录的存储
+type Sink interface {
+	Write(record *Record) error
+}
+
+// SinkFunc 函数形式的 Sink
+type SinkFunc func(record *Record) error
+
+// Write 调用函数本身
+func (f SinkFunc) Write(record *Record) error {
+	return f(record)
+}
+
+const (
+	// DefaultMaxSize 默认的单个审计文件的最大字节数
+	DefaultMaxSize int64 = 100 << 20
+	// backupTimeFormat 轮转文件名中的时间格式
+	backupTimeFormat = "20060102T150405.000000000"
+)
+
+// FileSinkOption 审计文件选项
+type FileSinkOption struct {
+	// MaxSize 单个文件的最大字节数, 超出时轮转, 为0时使用 DefaultMaxSize, 小于0时不轮转
+	MaxSize int64
+	// MaxBackups 保留的轮转文件数量, 为0时全部保留
+	MaxBackups int
+}
+
+// FileSink 以 JSON Lines 格式追加写入文件的 Sink, 文件超出大小时轮转为带时间后缀的文件,
+// 文件仅当前用户可读写, 可被多个流并发使用
+type FileSink struct {
+	path   string
+	option FileSinkOption
+
+	lock   sync.Mutex
+	f      *os.File
+	size   int64
+	closed bool
+}
+
+// rename 重命名文件, 测试中替换以模拟轮转失败
+var rename = os.Rename
+
+// NewFileSink 创建写入 path 的 FileSink, option 可以为nil, 文件已存在时追加写入
+func NewFileSink(path string, option *FileSinkOption) (*FileSink, error) {
+	s := &FileSink{path: path}
+	if option != nil {
+		s.option = *option
+	}
+	if s.option.MaxSize == 0 {
+		s.option.MaxSize = DefaultMaxSize
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("打开审计文件失败: %s", err.Error())
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("获取审计文件信息失败: %s", err.Error())
+	}
+	s.f = f
+	s.size = info.Size()
+	return nil
+}
+
+// Write 写入一条记录, 每条记录直接写入文件, 不做缓存.
+// 轮转失败时记录仍写入原文件, 并返回轮转的异常; 文件未能重新打开时将在下次写入时重试
+func (s *FileSink) Write(record *Record) error {
+	marshal, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("序列化审计记录失败: %s", err.Error())
+	}
+	marshal = append(marshal, '\n')
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("审计文件已关闭")
+	}
+	if s.f == nil {
+		if err = s.open(); err != nil {
+			return err
+		}
+	}
+
+	var rotateErr error
+	if s.option.MaxSize > 0 && s.size > 0 && s.size+int64(len(marshal)) > s.option.MaxSize {
+		if rotateErr = s.rotate(); s.f == nil {
+			return rotateErr
+		}
+	}
+
+	n, err := s.f.Write(marshal)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("写入审计记录失败: %s", err.Error())
+	}
+	return rotateErr
+}
+
+// rotate 将当前文件重命名为带时间后缀的文件并重新打开, 超出保留数量的旧文件将被删除,
+// 重命名失败时重新打开原文件
+func (s *FileSink) rotate() error {
+	closeErr := s.f.Close()
+	s.f = nil
+
+	var rotateErr error
+	if closeErr != nil {
+		rotateErr = fmt.Errorf("关闭审计文件失败: %s", closeErr.Error())
+	} else if err := rename(s.path, s.path+"."+time.Now().Format(backupTimeFormat)); err != nil {
+		rotateErr = fmt.Errorf("轮转审计文件失败: %s", err.Error())
+	}
+
+	if err := s.open(); err != nil {
+		if rotateErr != nil {
+			return fmt.Errorf("%s, %s", rotateErr.Error(), err.Error())
+		}
+		return err
+	}
+	if rotateErr != nil {
+		return rotateErr
+	}
+	return s.removeBackups()
+}
+
+func (s *FileSink) removeBackups() error {
+	if s.option.MaxBackups <= 0 {
+		return nil
+	}
+
+	backups, err := Backups(s.path)
+	if err != nil {
+		return err
+	}
+	for len(backups) > s.option.MaxBackups {
+		if err = os.Remove(backups[0]); err != nil {
+			return fmt.Errorf("删除旧的审计文件失败: %s", err.Error())
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// Close 关闭文件
+func (s *FileSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.closed = true
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// Backups 获取 path 已轮转的审计文件, 按轮转时间从早到晚排序
+func Backups(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, fmt.Errorf("查找轮转的审计文件失败: %s", err.Error())
+	}
+
+	backups := matches[:0]
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(match, path+".")
+		if _, err = time.Parse(backupTimeFormat, suffix); err == nil {
+			backups = append(backups, match)
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// Load 读取审计文件中的所有记录
+func Load(path string) ([]*Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("打开审计文件失败: %s", err.Error())
+	}
+	defer f.Close()
+
+	var records []*Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for scanner.Scan() {
+		var record *Record
+		if err = json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("解析审计记录失败: %s", err.Error())
+		}
+		records = append(records, record)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取审计文件失败: %s", err.Error())
+	}
+	return records, nil
+}
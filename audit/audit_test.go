@@ -0,0 +1,97 @@
+package audit
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileSink(t *testing.T) {
+	a := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFileSink(path, &FileSinkOption{MaxSize: 300, MaxBackups: 2})
+	if !a.NoError(err) {
+		return
+	}
+
+	for i := 0; i < 10; i++ {
+		a.NoError(sink.Write(&Record{
+			Time:     time.Now(),
+			User:     "alice",
+			Command:  "/team/create",
+			Code:     CodeOK,
+			Duration: time.Millisecond,
+		}))
+	}
+	a.NoError(sink.Close())
+	a.Error(sink.Write(&Record{Command: "/closed"}))
+
+	backups, err := Backups(path)
+	a.NoError(err)
+	a.Len(backups, 2)
+
+	records, err := Load(path)
+	a.NoError(err)
+	a.NotEmpty(records)
+	for _, backup := range backups {
+		rotated, err := Load(backup)
+		a.NoError(err)
+		a.NotEmpty(rotated)
+	}
+	a.Equal("alice", records[0].User)
+	a.Equal("/team/create", records[0].Command)
+	a.Equal(time.Millisecond, records[0].Duration)
+
+	sink, err = NewFileSink(path, nil)
+	if !a.NoError(err) {
+		return
+	}
+	a.NoError(sink.Write(&Record{Command: "/appended", Code: CodeOK}))
+	a.NoError(sink.Close())
+
+	appended, err := Load(path)
+	a.NoError(err)
+	a.Len(appended, len(records)+1)
+	a.Equal("/appended", appended[len(appended)-1].Command)
+}
+
+func TestFileSinkRotateFailure(t *testing.T) {
+	a := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFileSink(path, &FileSinkOption{MaxSize: 100})
+	if !a.NoError(err) {
+		return
+	}
+	defer sink.Close()
+
+	rename = func(oldpath, newpath string) error {
+		return fmt.Errorf("permission denied")
+	}
+	defer func() {
+		rename = os.Rename
+	}()
+
+	record := &Record{Command: "/team/create", Code: CodeOK}
+	a.NoError(sink.Write(record))
+	a.Error(sink.Write(record))
+	a.Error(sink.Write(record))
+
+	rename = os.Rename
+	a.NoError(sink.Write(record))
+
+	records, err := Load(path)
+	a.NoError(err)
+	a.Len(records, 1)
+	backups, err := Backups(path)
+	a.NoError(err)
+	if a.Len(backups, 1) {
+		rotated, err := Load(backups[0])
+		a.NoError(err)
+		a.Len(rotated, 3)
+	}
+}
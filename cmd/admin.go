@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/teamManagement/common/errors"
+	"sort"
+)
+
+const (
+	// AdminSessionsCmd 内置管理命令, 列出 ServeConn 处理中的所有会话, 需要通过 Router.EnableAdmin 开启
+	AdminSessionsCmd Name = "/_admin/sessions"
+	// AdminCloseCmd 内置管理命令, 强制关闭会话对应的QUIC连接, 需要通过 Router.EnableAdmin 开启
+	AdminCloseCmd Name = "/_admin/close"
+)
+
+func init() {
+	reservedCmdMap[AdminSessionsCmd] = adminSessionsHandler
+	reservedCmdMap[AdminCloseCmd] = adminCloseHandler
+}
+
+// AdminAuthorizer 管理命令的授权函数, 返回异常时拒绝执行, name 为要执行的管理命令;
+// 返回的异常不是 *transportstream.ErrInfo 时以 errors.ErrCodePermissionDenied 返回给客户端
+type AdminAuthorizer func(request *Request, name Name) error
+
+// ListSessionsRequest AdminSessionsCmd 的请求数据
+type ListSessionsRequest struct {
+	// User 不为空时仅列出该用户的会话
+	User string `json:"user,omitempty"`
+}
+
+// CloseSessionRequest AdminCloseCmd 的请求数据, Session 与 User 至少指定一个
+type CloseSessionRequest struct {
+	// Session 要关闭的会话编号
+	Session uint64 `json:"session,omitempty"`
+	// User 关闭该用户的所有会话
+	User string `json:"user,omitempty"`
+	// Reason 关闭原因, 随连接的关闭码发送至对端
+	Reason string `json:"reason,omitempty"`
+}
+
+// CloseSessionResult AdminCloseCmd 的返回数据
+type CloseSessionResult struct {
+	// Closed 已关闭的会话编号
+	Closed []uint64 `json:"closed"`
+}
+
+// EnableAdmin 开启管理命令, 每次执行管理命令之前调用 authorizer 鉴权, authorizer 为nil时关闭管理命令.
+// 管理命令属于内置命令不经过中间件, 未开启时视为命令不存在
+func (r *Router) EnableAdmin(authorizer AdminAuthorizer) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.adminAuthorizer = authorizer
+}
+
+// Sessions ServeConn 处理中的所有会话, 按会话编号排序
+func (r *Router) Sessions() []*Session {
+	r.lock.RLock()
+	sessions := make([]*Session, 0, len(r.sessions))
+	for _, session := range r.sessions {
+		sessions = append(sessions, session)
+	}
+	r.lock.RUnlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].id < sessions[j].id
+	})
+	return sessions
+}
+
+// Session 根据编号获取 ServeConn 处理中的会话
+func (r *Router) Session(id uint64) (*Session, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	session, ok := r.sessions[id]
+	return session, ok
+}
+
+func (r *Router) addSession(session *Session) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.sessions[session.id] = session
+}
+
+func (r *Router) removeSession(session *Session) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.sessions, session.id)
+}
+
+// adminRequest 读取客户端的数据并鉴权, 路由表未开启管理命令时视为命令不存在
+func adminRequest(stream *transportstream.Stream, quicStream quic.Stream, name Name) (*Router, *Request, ExchangeData, error) {
+	data, err := stream.ReceiveMsg()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	request := RequestOf(quicStream)
+	router := request.router
+	if router == nil {
+		router = DefaultRouter
+	}
+
+	router.lock.RLock()
+	authorizer := router.adminAuthorizer
+	router.lock.RUnlock()
+	if authorizer == nil {
+		return nil, nil, nil, errors.ErrCodeCommandUndefined.Newf("命令[%s]未被识别", name)
+	}
+	if err = authorizer(request, name); err != nil {
+		if _, ok := err.(*transportstream.ErrInfo); ok {
+			return nil, nil, nil, err
+		}
+		return nil, nil, nil, errors.ErrCodePermissionDenied.Newf("无权执行管理命令[%s]: %s", name, err.Error())
+	}
+	return router, request, data, nil
+}
+
+func adminSessionsHandler(stream *transportstream.Stream, quicStream quic.Stream) (ExchangeData, error) {
+	router, request, data, err := adminRequest(stream, quicStream, AdminSessionsCmd)
+	if err != nil {
+		return nil, err
+	}
+
+	var req ListSessionsRequest
+	if len(data) > 0 {
+		if err = request.Unmarshal(data, &req); err != nil {
+			return nil, errors.ErrCodeValidation.New(err.Error())
+		}
+	}
+
+	infos := make([]*SessionInfo, 0)
+	for _, session := range router.Sessions() {
+		info := session.Info()
+		if req.User == "" || req.User == info.User {
+			infos = append(infos, info)
+		}
+	}
+	return request.Marshal(infos)
+}
+
+func adminCloseHandler(stream *transportstream.Stream, quicStream quic.Stream) (ExchangeData, error) {
+	router, request, data, err := adminRequest(stream, quicStream, AdminCloseCmd)
+	if err != nil {
+		return nil, err
+	}
+
+	var req CloseSessionRequest
+	if len(data) > 0 {
+		if err = request.Unmarshal(data, &req); err != nil {
+			return nil, errors.ErrCodeValidation.New(err.Error())
+		}
+	}
+	if req.Session == 0 && req.User == "" {
+		return nil, errors.ErrCodeValidation.New("未指定要关闭的会话或用户")
+	}
+
+	result := &CloseSessionResult{Closed: []uint64{}}
+	for _, session := range router.Sessions() {
+		if (req.Session != 0 && session.id != req.Session) || (req.User != "" && session.User() != req.User) {
+			continue
+		}
+		result.Closed = append(result.Closed, session.id)
+
+		if session == request.Session {
+			// 关闭自身所在的会话时等待本次交换结束, 以便返回结果
+			go func(session *Session) {
+				<-request.Context().Done()
+				_ = session.Close(req.Reason)
+			}(session)
+			continue
+		}
+		_ = session.Close(req.Reason)
+	}
+
+	if req.Session != 0 && len(result.Closed) == 0 {
+		return nil, errors.ErrCodeValidation.Newf("会话[%d]不存在", req.Session)
+	}
+	return request.Marshal(result)
+}
+
+// ListSessions 获取对端的会话, user 不为空时仅获取该用户的会话
+func ListSessions(user string, stream *transportstream.Stream) ([]*SessionInfo, error) {
+	res, err := AdminSessionsCmd.ExchangeWithData(&ListSessionsRequest{User: user}, stream)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []*SessionInfo
+	if err = res.UnmarshalJson(&infos); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// CloseSessions 强制关闭对端的会话, 返回已关闭的会话编号
+func CloseSessions(req *CloseSessionRequest, stream *transportstream.Stream) ([]uint64, error) {
+	res, err := AdminCloseCmd.ExchangeWithData(req, stream)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *CloseSessionResult
+	if err = res.UnmarshalJson(&result); err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, nil
+	}
+	return result.Closed, nil
+}
@@ -0,0 +1,143 @@
+package cmd_test
+
+import (
+	"context"
+	"fmt"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/teamManagement/common/cmd"
+	"github.com/teamManagement/common/errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAdminSessions(t *testing.T) {
+	a := assert.New(t)
+
+	router := cmd.NewRouter()
+	router.Handle(cmd.Login, loginHandler)
+	release := make(chan struct{})
+	defer close(release)
+	router.Handle("/team/slow", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		if _, err := stream.ReceiveMsg(); err != nil {
+			return nil, err
+		}
+		<-release
+		return nil, nil
+	})
+	addr := startQuicServer(t, router)
+
+	login := func(conn quic.Connection, user string) {
+		a.NoError(exchangeOn(conn, func(stream *transportstream.Stream) error {
+			_, err := cmd.Login.ExchangeWithData(user, stream)
+			return err
+		}))
+	}
+	listSessions := func(conn quic.Connection, user string) (infos []*cmd.SessionInfo, err error) {
+		err = exchangeOn(conn, func(stream *transportstream.Stream) error {
+			infos, err = cmd.ListSessions(user, stream)
+			return err
+		})
+		return
+	}
+	closeSessions := func(conn quic.Connection, req *cmd.CloseSessionRequest) (closed []uint64, err error) {
+		err = exchangeOn(conn, func(stream *transportstream.Stream) error {
+			closed, err = cmd.CloseSessions(req, stream)
+			return err
+		})
+		return
+	}
+
+	adminConn := dialConn(t, addr)
+	login(adminConn, "admin")
+	bobConn := dialConn(t, addr)
+	login(bobConn, "bob")
+	go func() {
+		_ = exchangeOn(bobConn, func(stream *transportstream.Stream) error {
+			_, err := cmd.Name("/team/slow").Exchange(stream)
+			return err
+		})
+	}()
+
+	// 未开启时视为命令不存在
+	_, err := listSessions(adminConn, "")
+	errInfo, ok := transportstream.ErrConvert(err)
+	if a.True(ok) {
+		a.Equal(errors.ErrCodeCommandUndefined, errInfo.Code)
+	}
+
+	router.EnableAdmin(func(request *cmd.Request, name cmd.Name) error {
+		if request.Session == nil || request.Session.User() != "admin" {
+			return fmt.Errorf("用户[%s]不是管理员", request.Session.User())
+		}
+		return nil
+	})
+	defer router.EnableAdmin(nil)
+
+	_, err = listSessions(bobConn, "")
+	errInfo, ok = transportstream.ErrConvert(err)
+	if a.True(ok) {
+		a.Equal(errors.ErrCodePermissionDenied, errInfo.Code)
+	}
+	_, err = closeSessions(bobConn, &cmd.CloseSessionRequest{User: "admin"})
+	a.Error(err)
+
+	var bob *cmd.SessionInfo
+	a.Eventually(func() bool {
+		infos, err := listSessions(adminConn, "bob")
+		if err != nil || len(infos) != 1 {
+			return false
+		}
+		bob = infos[0]
+		return len(bob.Inflight) == 1
+	}, time.Second, 10*time.Millisecond)
+	if bob != nil {
+		a.Equal("/team/slow", string(bob.Inflight[0].Name))
+		a.Equal(bobConn.LocalAddr().(*net.UDPAddr).Port, mustPort(t, bob.RemoteAddr))
+		a.False(bob.ConnectedAt.IsZero())
+	}
+
+	infos, err := listSessions(adminConn, "")
+	a.NoError(err)
+	if a.Len(infos, 2) {
+		a.Equal("admin", infos[0].User)
+		a.Equal("/_admin/sessions", string(infos[0].Inflight[0].Name))
+	}
+
+	_, err = closeSessions(adminConn, &cmd.CloseSessionRequest{})
+	a.Error(err)
+	_, err = closeSessions(adminConn, &cmd.CloseSessionRequest{Session: 1 << 40})
+	a.Error(err)
+
+	closed, err := closeSessions(adminConn, &cmd.CloseSessionRequest{User: "bob", Reason: "滥用"})
+	a.NoError(err)
+	if a.Len(closed, 1) && bob != nil {
+		a.Equal(bob.ID, closed[0])
+	}
+
+	select {
+	case <-bobConn.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("会话未被关闭")
+	}
+	_, err = bobConn.AcceptStream(context.Background())
+	if appErr, ok := err.(*quic.ApplicationError); a.True(ok, "%v", err) {
+		a.Equal(quic.ApplicationErrorCode(errors.ErrCodeSessionClosed), appErr.ErrorCode)
+		a.Equal("滥用", appErr.ErrorMessage)
+	}
+	a.Eventually(func() bool {
+		return len(router.Sessions()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// 关闭自身所在的会话时仍能收到结果
+	closed, err = closeSessions(adminConn, &cmd.CloseSessionRequest{User: "admin"})
+	a.NoError(err)
+	a.Len(closed, 1)
+	select {
+	case <-adminConn.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("会话未被关闭")
+	}
+}
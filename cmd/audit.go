@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/teamManagement/common/audit"
+	"github.com/teamManagement/common/errors"
+	"hash"
+	"io"
+	"sync"
+	"time"
+)
+
+// SetAuditSink 设置审计记录的存储, 设置之后每条命令执行结束时写入一条审计记录, 为nil时关闭审计
+func (r *Router) SetAuditSink(sink audit.Sink) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.auditSink = sink
+}
+
+// AuditErrorHook 审计记录写入失败时的回调, 用于记录日志或告警
+type AuditErrorHook func(record *audit.Record, err error)
+
+// OnAuditError 设置审计记录写入失败时的回调, 未设置时忽略写入失败
+func (r *Router) OnAuditError(hook AuditErrorHook) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.auditErrorHook = hook
+}
+
+func (r *Router) currentAuditSink() (audit.Sink, AuditErrorHook) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.auditSink, r.auditErrorHook
+}
+
+// frameDigestReader 按帧读取数据, 开始统计之后计算客户端发送的数据消息脱敏之后的摘要
+type frameDigestReader struct {
+	r       io.Reader
+	pending bytes.Buffer
+
+	lock   sync.Mutex
+	hash   hash.Hash
+	size   int64
+	redact func(data []byte) []byte
+}
+
+// start 开始统计, 之后读取到的数据消息经过 redact 脱敏之后计入摘要
+func (f *frameDigestReader) start(redact func(data []byte) []byte) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.hash = sha256.New()
+	f.size = 0
+	f.redact = redact
+}
+
+// sum 获取已统计的数据消息的摘要及字节数, 未读取到数据时摘要为空
+func (f *frameDigestReader) sum() (string, int64) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.hash == nil || f.size == 0 {
+		return "", f.size
+	}
+	return hex.EncodeToString(f.hash.Sum(nil)), f.size
+}
+
+func (f *frameDigestReader) Read(p []byte) (int, error) {
+	if f.pending.Len() > 0 {
+		return f.pending.Read(p)
+	}
+
+	frame, err := readFrame(f.r)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(frame) > 1 && isPayloadFlag(frame[0]) {
+		f.lock.Lock()
+		if f.hash != nil {
+			f.hash.Write(f.redact(frame[1:]))
+			f.size += int64(len(frame) - 1)
+		}
+		f.lock.Unlock()
+	}
+
+	writeFrame(&f.pending, frame)
+	return f.pending.Read(p)
+}
+
+// auditEntry 一条命令的审计信息, 为nil时表示未开启审计
+type auditEntry struct {
+	sink      audit.Sink
+	onError   AuditErrorHook
+	session   *Session
+	record    audit.Record
+	transport *streamTransport
+	outcome   *transportstream.ErrInfo
+}
+
+// startAudit 未设置审计存储时返回nil
+func (r *Router) startAudit(request *Request, transport *streamTransport) *auditEntry {
+	sink, onError := r.currentAuditSink()
+	if sink == nil {
+		return nil
+	}
+
+	entry := &auditEntry{
+		sink:      sink,
+		onError:   onError,
+		session:   request.Session,
+		transport: transport,
+		record: audit.Record{
+			Time:    time.Now(),
+			Command: string(request.Name),
+		},
+	}
+	if request.Session != nil {
+		entry.record.Session = request.Session.ID()
+		if request.Session.Conn() != nil {
+			entry.record.RemoteAddr = request.Session.RemoteAddr().String()
+		}
+	}
+	if transport != nil {
+		transport.digest.start(request.Redact)
+	}
+	return entry
+}
+
+// fail 记录命令的执行结果, 仅记录第一个异常
+func (a *auditEntry) fail(errInfo *transportstream.ErrInfo) {
+	if a != nil && a.outcome == nil {
+		a.outcome = errInfo
+	}
+}
+
+// finish 写入审计记录, 用户为执行结束时会话中的用户, 以便记录登录命令认证的用户; 写入失败时调用 AuditErrorHook
+func (a *auditEntry) finish() {
+	if a == nil {
+		return
+	}
+
+	a.record.Duration = time.Since(a.record.Time)
+	a.record.User = sessionUser(a.session)
+	if a.transport != nil {
+		a.record.PayloadDigest, a.record.PayloadSize = a.transport.digest.sum()
+	}
+	a.record.Code = audit.CodeOK
+	if a.outcome != nil {
+		a.record.Code = errors.CodeName(a.outcome.Code)
+		a.record.Message = a.outcome.Msg
+	}
+	if err := a.sink.Write(&a.record); err != nil && a.onError != nil {
+		a.onError(&a.record, err)
+	}
+}
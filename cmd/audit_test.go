@@ -0,0 +1,122 @@
+package cmd_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/teamManagement/common/audit"
+	"github.com/teamManagement/common/cmd"
+	"github.com/teamManagement/common/cmdtest"
+	"github.com/teamManagement/common/errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAudit(t *testing.T) {
+	a := assert.New(t)
+
+	router := cmd.NewRouter()
+	router.Handle("/team/create", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		return stream.ReceiveMsg()
+	})
+	router.Handle("/team/delete", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		return nil, errors.ErrCodeValidation.New("团队不存在")
+	})
+	router.Handle("/team/panic", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		panic("boom")
+	})
+
+	var (
+		lock    sync.Mutex
+		records []*audit.Record
+	)
+	router.SetAuditSink(audit.SinkFunc(func(record *audit.Record) error {
+		lock.Lock()
+		defer lock.Unlock()
+		records = append(records, record)
+		return nil
+	}))
+
+	_, err := cmdtest.Call(t, router, "/team/create", map[string]string{"name": "core"})
+	a.NoError(err)
+	_, err = cmdtest.CallWithOption(t, router, "/team/create", &cmd.ExchangeOption{
+		Data:     map[string]string{"name": "core"},
+		Compress: true,
+	})
+	a.NoError(err)
+	_, err = cmdtest.Call(t, router, "/team/delete", nil)
+	a.Error(err)
+	_, err = cmdtest.Call(t, router, "/team/panic", nil)
+	a.Error(err)
+	_, err = cmdtest.Call(t, router, "/team/unknown", nil)
+	a.Error(err)
+
+	lock.Lock()
+	defer lock.Unlock()
+	if !a.Len(records, 5) {
+		return
+	}
+
+	payload := []byte(`{"name":"core"}`)
+	digest := sha256.Sum256(payload)
+	for _, record := range records[:2] {
+		a.Equal("/team/create", record.Command)
+		a.Equal(audit.CodeOK, record.Code)
+		a.Equal(hex.EncodeToString(digest[:]), record.PayloadDigest)
+		a.EqualValues(len(payload), record.PayloadSize)
+		a.False(record.Time.IsZero())
+		a.True(record.Duration > 0)
+	}
+
+	a.Equal("Validation", records[2].Code)
+	a.Equal("团队不存在", records[2].Message)
+	a.Empty(records[2].PayloadDigest)
+	a.Equal("Unknown", records[3].Code)
+	a.Contains(records[3].Message, "boom")
+	a.Equal("/team/unknown", records[4].Command)
+	a.Equal("CommandUndefined", records[4].Code)
+}
+
+func TestAuditSessionUser(t *testing.T) {
+	a := assert.New(t)
+
+	router := cmd.NewRouter()
+	router.Handle(cmd.Login, loginHandler)
+	records := make(chan *audit.Record, 2)
+	router.SetAuditSink(audit.SinkFunc(func(record *audit.Record) error {
+		records <- record
+		if record.Command == string(cmd.Login) {
+			return fmt.Errorf("disk full")
+		}
+		return nil
+	}))
+	failures := make(chan error, 1)
+	router.OnAuditError(func(record *audit.Record, err error) {
+		a.Equal(string(cmd.Login), record.Command)
+		failures <- err
+	})
+
+	conn := dialConn(t, startQuicServer(t, router))
+	a.NoError(exchangeOn(conn, func(stream *transportstream.Stream) error {
+		_, err := cmd.Login.ExchangeWithData("alice", stream)
+		return err
+	}))
+
+	select {
+	case record := <-records:
+		a.Equal(string(cmd.Login), record.Command)
+		a.Equal("alice", record.User)
+	case <-time.After(5 * time.Second):
+		t.Fatal("等待审计记录超时")
+	}
+	select {
+	case err := <-failures:
+		a.EqualError(err, "disk full")
+	case <-time.After(5 * time.Second):
+		t.Fatal("等待审计写入失败回调超时")
+	}
+}
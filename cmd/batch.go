@@ -0,0 +1,288 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/teamManagement/common/codec"
+	"github.com/teamManagement/common/errors"
+	"github.com/teamManagement/common/internal/memstream"
+	"sync"
+)
+
+// Batch 内置的批量执行命令, 在一次交换中执行多个命令
+const Batch Name = "/_batch"
+
+const (
+	// DefaultMaxBatchSize 默认的单次批量执行的最大命令数
+	DefaultMaxBatchSize = 64
+	// DefaultMaxBatchParallel 默认的并行批量执行时的最大并发数
+	DefaultMaxBatchParallel = 8
+)
+
+func init() {
+	reservedCmdMap[Batch] = batchHandler
+}
+
+// SetMaxBatchSize 设置 DefaultRouter 单次批量执行的最大命令数
+func SetMaxBatchSize(size int) {
+	DefaultRouter.SetMaxBatchSize(size)
+}
+
+// SetMaxBatchSize 设置单次批量执行的最大命令数, 小于等于0时不限制
+func (r *Router) SetMaxBatchSize(size int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.maxBatchSize = size
+}
+
+// SetMaxBatchParallel 设置 DefaultRouter 并行批量执行时的最大并发数
+func SetMaxBatchParallel(n int) {
+	DefaultRouter.SetMaxBatchParallel(n)
+}
+
+// SetMaxBatchParallel 设置并行批量执行时的最大并发数, 小于等于0时不限制
+func (r *Router) SetMaxBatchParallel(n int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.maxBatchParallel = n
+}
+
+func (r *Router) batchLimits() (size, parallel int) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.maxBatchSize, r.maxBatchParallel
+}
+
+// BatchItem 批量执行中的单个命令
+type BatchItem struct {
+	// Name 命令名称
+	Name Name `json:"name"`
+	// Codec 数据的编解码器, 为空时使用JSON
+	Codec string `json:"codec,omitempty"`
+	// Data 已编码的数据
+	Data ExchangeData `json:"data,omitempty"`
+}
+
+// BatchRequest 批量执行请求
+type BatchRequest struct {
+	// Items 要执行的命令
+	Items []*BatchItem `json:"items"`
+	// Parallel 是否并行执行, 为false时按顺序执行
+	Parallel bool `json:"parallel,omitempty"`
+	// StopOnError 顺序执行时遇到失败是否跳过剩余的命令, 被跳过的命令返回 errors.ErrCodeBatchSkipped
+	StopOnError bool `json:"stopOnError,omitempty"`
+}
+
+// NewBatchRequest 创建批量执行请求
+func NewBatchRequest() *BatchRequest {
+	return &BatchRequest{}
+}
+
+// Add 添加一个使用JSON编码数据的命令
+func (b *BatchRequest) Add(name Name, data any) error {
+	return b.AddWithCodec(name, codec.JSON, data)
+}
+
+// AddWithCodec 添加一个使用指定编解码器编码数据的命令
+func (b *BatchRequest) AddWithCodec(name Name, codecName string, data any) error {
+	item := &BatchItem{
+		Name:  name,
+		Codec: codecName,
+	}
+
+	if data != nil {
+		var err error
+		if item.Data, err = NewExchangeDataByCodec(codecName, data); err != nil {
+			return fmt.Errorf("序列化命令[%s]的数据失败: %s", name, err.Error())
+		}
+	}
+	b.Items = append(b.Items, item)
+	return nil
+}
+
+// Exec 将批量执行请求发送至对端, 返回的结果与 Items 一一对应
+func (b *BatchRequest) Exec(stream *transportstream.Stream) ([]*BatchResult, error) {
+	res, err := Batch.ExchangeWithData(b, stream)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*BatchResult
+	if err = res.UnmarshalJson(&results); err != nil {
+		return nil, err
+	}
+	if len(results) != len(b.Items) {
+		return nil, fmt.Errorf("批量执行结果数量[%d]与命令数量[%d]不一致", len(results), len(b.Items))
+	}
+	return results, nil
+}
+
+// BatchResult 批量执行中单个命令的结果
+type BatchResult struct {
+	// Name 命令名称
+	Name Name `json:"name"`
+	// Codec 数据的编解码器
+	Codec string `json:"codec,omitempty"`
+	// Data 命令返回的数据
+	Data ExchangeData `json:"data,omitempty"`
+	// Err 命令执行失败时的异常
+	Err *transportstream.ErrInfo `json:"err,omitempty"`
+}
+
+// Unmarshal 反序列化命令返回的数据, 命令执行失败时返回对应的异常
+func (r *BatchResult) Unmarshal(i any) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	return r.Data.UnmarshalCodec(r.Codec, i)
+}
+
+func batchHandler(stream *transportstream.Stream, quicStream quic.Stream) (ExchangeData, error) {
+	data, err := stream.ReceiveMsg()
+	if err != nil {
+		return nil, err
+	}
+
+	var batch *BatchRequest
+	if err = ExchangeData(data).UnmarshalJson(&batch); err != nil {
+		return nil, errors.ErrCodeValidation.New(err.Error())
+	}
+	if batch == nil || len(batch.Items) == 0 {
+		return nil, errors.ErrCodeValidation.New("批量执行的命令不能为空")
+	}
+
+	request := RequestOf(quicStream)
+	router := request.router
+	if router == nil {
+		router = DefaultRouter
+	}
+
+	size, parallel := router.batchLimits()
+	if size > 0 && len(batch.Items) > size {
+		return nil, errors.ErrCodeValidation.Newf("批量执行的命令数量超出限制: %d", size)
+	}
+	for i, item := range batch.Items {
+		if item == nil {
+			return nil, errors.ErrCodeValidation.Newf("批量执行的第 %d 个命令为空", i+1)
+		}
+	}
+
+	ctx := request.Context()
+	results := make([]*BatchResult, len(batch.Items))
+	if batch.Parallel {
+		if parallel <= 0 {
+			parallel = len(batch.Items)
+		}
+
+		sem := make(chan struct{}, parallel)
+		wg := sync.WaitGroup{}
+		for i, item := range batch.Items {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, item *BatchItem) {
+				defer func() {
+					// 单个命令的异常不能影响整个进程, 转换为该命令的执行结果
+					if e := recover(); e != nil {
+						results[i] = &BatchResult{
+							Name: item.Name,
+							Err:  errors.ErrCodeUnknown.Newf("批量执行中命令[%s]的处理异常: %v", item.Name, e),
+						}
+					}
+					<-sem
+					wg.Done()
+				}()
+				results[i] = router.execBatchItem(ctx, item, request.Session)
+			}(i, item)
+		}
+		wg.Wait()
+	} else {
+		failed := false
+		for i, item := range batch.Items {
+			if failed && batch.StopOnError {
+				results[i] = &BatchResult{
+					Name: item.Name,
+					Err:  errors.ErrCodeBatchSkipped.New("前序命令执行失败, 已跳过"),
+				}
+				continue
+			}
+			results[i] = router.execBatchItem(ctx, item, request.Session)
+			failed = failed || results[i].Err != nil
+		}
+	}
+
+	if err = ctx.Err(); err != nil {
+		return nil, errors.ErrCodeCancelled.Newf("批量执行已被客户端取消: %s", err.Error())
+	}
+	return NewExchangeDataByJson(results)
+}
+
+// execBatchItem 在内存流中执行单个命令, 与独立调用时经过相同的路由、中间件及限制,
+// 命令的上下文继承自外层的 Batch 命令, 外层已被取消时不再执行
+func (r *Router) execBatchItem(ctx context.Context, item *BatchItem, session *Session) *BatchResult {
+	result := &BatchResult{
+		Name:  item.Name,
+		Codec: item.Codec,
+	}
+
+	if _, reserved := reservedCmdMap[item.Name]; reserved {
+		result.Err = errors.ErrCodeValidation.Newf("批量执行中不支持内置命令[%s]", item.Name)
+		return result
+	}
+	if err := ctx.Err(); err != nil {
+		result.Err = errors.ErrCodeCancelled.Newf("批量执行已被取消: %s", err.Error())
+		return result
+	}
+
+	client, server := memstream.Pipe()
+	// 批量执行内部的流不单独记录, 回放 Batch 命令时将重新执行其中的命令
+	transport := newStreamTransport(server, nil)
+	transport.nested = true
+	transport.ctx = ctx
+	go func() {
+		_ = r.serveTransport(server, session, transport)
+	}()
+	defer client.Close()
+
+	res, err := exchangeBatchItem(client.TransportStream(), item)
+	if err != nil {
+		result.Err = errors.ErrorByErr(err)
+		return result
+	}
+	result.Data = res
+	return result
+}
+
+// exchangeBatchItem 在内存流中直接与路由交换单个命令, 不经过客户端的 ExchangeWithOption,
+// 因此不会触发客户端的废弃警告回调, 也不受客户端数据大小限制的影响
+func exchangeBatchItem(stream *transportstream.Stream, item *BatchItem) (ExchangeData, error) {
+	defer stream.WriteEndMsg()
+
+	header := Header{}
+	if item.Codec != "" && item.Codec != codec.JSON {
+		header.Set(HeaderContentType, item.Codec)
+	}
+	if _, err := item.Name.SendCommandWithHeader(stream, header); err != nil {
+		return nil, err
+	}
+	if err := stream.WriteMsg(item.Data, transportstream.MsgFlagSuccess); err != nil {
+		return nil, err
+	}
+
+	msg, err := stream.ReceiveMsg()
+	switch {
+	case err == transportstream.StreamIsEnd:
+		return msg, nil
+	case err != nil:
+		for {
+			if _, e := stream.ReceiveMsg(); isStreamFinished(e) {
+				break
+			}
+		}
+		return nil, err
+	default:
+		// 批量执行中的命令不支持多轮交换, 与 ExchangeWithOption 未设置 StreamHandle 时的行为一致
+		return nil, stream.WriteEndMsg()
+	}
+}
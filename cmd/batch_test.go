@@ -0,0 +1,169 @@
+package cmd_test
+
+import (
+	"context"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/teamManagement/common/cmd"
+	"github.com/teamManagement/common/cmdtest"
+	"github.com/teamManagement/common/errors"
+	"testing"
+	"time"
+)
+
+func TestBatch(t *testing.T) {
+	a := assert.New(t)
+
+	router := cmd.NewRouter()
+	router.Handle("/team/:teamId/member/list", patternHandler)
+	router.Handle("/fail", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		return nil, errors.ErrCodeValidation.New("执行失败")
+	})
+
+	for _, parallel := range []bool{false, true} {
+		batch := cmd.NewBatchRequest()
+		batch.Parallel = parallel
+		batch.StopOnError = true
+		a.NoError(batch.Add("/team/1/member/list", nil))
+		a.NoError(batch.Add("/fail", map[string]string{"a": "b"}))
+		a.NoError(batch.Add("/team/2/member/list", nil))
+
+		client, server := cmdtest.Pipe()
+		go func() {
+			_ = router.ServeStream(server)
+		}()
+
+		results, err := batch.Exec(client.TransportStream())
+		if !a.NoError(err) {
+			return
+		}
+
+		var res struct {
+			Params map[string]string `json:"params"`
+		}
+		a.NoError(results[0].Unmarshal(&res))
+		a.Equal("1", res.Params["teamId"])
+
+		a.True(errors.ErrCodeValidation.Equal(results[1].Unmarshal(&res)))
+
+		if parallel {
+			a.NoError(results[2].Unmarshal(&res))
+			a.Equal("2", res.Params["teamId"])
+		} else {
+			a.True(errors.ErrCodeBatchSkipped.Equal(results[2].Err))
+		}
+	}
+}
+
+func TestBatchNested(t *testing.T) {
+	a := assert.New(t)
+
+	router := cmd.NewRouter()
+	router.Handle("/team/:teamId/member/list", patternHandler)
+	router.Deprecate("/team/:teamId/member/list", &cmd.Deprecation{})
+	// 批量执行中的命令不再占用额外的连接名额
+	router.SetConnConcurrencyLimit(&cmd.ConcurrencyLimit{MaxConcurrent: 1})
+	addr := startQuicServer(t, router)
+
+	var warned []cmd.Name
+	cmd.SetDeprecationWarningHandler(func(name cmd.Name, deprecation *cmd.Deprecation) {
+		warned = append(warned, name)
+	})
+	defer cmd.SetDeprecationWarningHandler(nil)
+
+	batch := cmd.NewBatchRequest()
+	a.NoError(batch.Add("/team/1/member/list", nil))
+	a.NoError(batch.Add("/team/2/member/list", nil))
+
+	var results []*cmd.BatchResult
+	a.NoError(exchangeOn(dialConn(t, addr), func(stream *transportstream.Stream) (err error) {
+		results, err = batch.Exec(stream)
+		return
+	}))
+	for _, result := range results {
+		a.Nil(result.Err)
+	}
+	a.Empty(warned)
+}
+
+func TestBatchInvalidItems(t *testing.T) {
+	a := assert.New(t)
+
+	router := cmd.NewRouter()
+	router.Handle("/team/:teamId/member/list", patternHandler)
+	router.Handle("/panic", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		panic("boom")
+	})
+
+	for _, parallel := range []bool{false, true} {
+		_, err := cmdtest.Call(t, router, cmd.Batch, map[string]any{"items": []any{nil}, "parallel": parallel})
+		a.True(errors.ErrCodeValidation.Equal(err), err)
+
+		batch := cmd.NewBatchRequest()
+		batch.Parallel = parallel
+		a.NoError(batch.Add("/panic", nil))
+		a.NoError(batch.Add("/team/1/member/list", nil))
+		var results []*cmd.BatchResult
+		cmdtest.MustCall(t, router, cmd.Batch, batch, &results)
+		if a.Len(results, 2) {
+			a.True(errors.ErrCodeUnknown.Equal(results[0].Err))
+			a.Nil(results[1].Err)
+		}
+	}
+
+	router.SetMaxBatchSize(1)
+	batch := cmd.NewBatchRequest()
+	a.NoError(batch.Add("/team/1/member/list", nil))
+	a.NoError(batch.Add("/team/2/member/list", nil))
+	_, err := cmdtest.Call(t, router, cmd.Batch, batch)
+	a.True(errors.ErrCodeValidation.Equal(err), err)
+	// 批量执行的限制仅对所属的路由表生效
+	var results []*cmd.BatchResult
+	cmdtest.MustCall(t, cmd.NewRouter(), cmd.Batch, batch, &results)
+	a.Len(results, 2)
+}
+
+func TestBatchCancel(t *testing.T) {
+	a := assert.New(t)
+
+	started, stopped := make(chan struct{}, 2), make(chan struct{}, 2)
+	router := cmd.NewRouter()
+	router.Handle("/wait", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		started <- struct{}{}
+		<-cmd.RequestOf(quicStream).Context().Done()
+		stopped <- struct{}{}
+		return nil, errors.ErrCodeCancelled.New("cancelled")
+	})
+
+	for _, parallel := range []bool{false, true} {
+		batch := cmd.NewBatchRequest()
+		batch.Parallel = parallel
+		a.NoError(batch.Add("/wait", nil))
+		a.NoError(batch.Add("/wait", nil))
+
+		// 外层取消之后, 执行中的命令随之取消, 顺序执行时剩余的命令不再执行
+		expected := 1
+		if parallel {
+			expected = 2
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			for i := 0; i < expected; i++ {
+				<-started
+			}
+			cancel()
+		}()
+		_, err := cmdtest.CallWithOption(t, router, cmd.Batch, &cmd.ExchangeOption{Data: batch, Context: ctx})
+		a.True(errors.ErrCodeCancelled.Equal(err), err)
+
+		for i := 0; i < expected; i++ {
+			select {
+			case <-stopped:
+			case <-time.After(5 * time.Second):
+				t.Fatal("等待批量执行中的命令取消超时")
+			}
+		}
+		a.Empty(started)
+	}
+}
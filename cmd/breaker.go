@@ -0,0 +1,443 @@
+package cmd
+
+import (
+	"fmt"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/teamManagement/common/errors"
+	"sync"
+	"time"
+)
+
+// BreakerState 熔断器状态
+type BreakerState int32
+
+const (
+	// BreakerClosed 关闭, 请求正常通过, 同时统计错误率及慢调用比例
+	BreakerClosed BreakerState = iota
+	// BreakerOpen 打开, 请求在本地直接失败, 不再发送至服务端
+	BreakerOpen
+	// BreakerHalfOpen 半开, 仅允许少量试探请求通过以判断服务端是否恢复
+	BreakerHalfOpen
+)
+
+var breakerStateNames = map[BreakerState]string{
+	BreakerClosed:   "closed",
+	BreakerOpen:     "open",
+	BreakerHalfOpen: "half-open",
+}
+
+func (s BreakerState) String() string {
+	if name, ok := breakerStateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("BreakerState(%d)", int32(s))
+}
+
+const (
+	// DefaultBreakerWindow 默认的统计窗口
+	DefaultBreakerWindow = 10 * time.Second
+	// DefaultBreakerMinRequests 默认的统计窗口内触发熔断的最少请求数
+	DefaultBreakerMinRequests = 10
+	// DefaultBreakerErrorRate 默认的错误率阈值
+	DefaultBreakerErrorRate = 0.5
+	// DefaultBreakerOpenTimeout 默认的熔断持续时间
+	DefaultBreakerOpenTimeout = 30 * time.Second
+	// breakerBuckets 统计窗口划分的桶数量
+	breakerBuckets = 10
+)
+
+// BreakerEvent 熔断器状态变化事件
+type BreakerEvent struct {
+	// Server 服务端标识
+	Server string
+	// Command 命令名称, 匹配 BreakerOption.Patterns 时为匹配到的命令模式, 为空时为服务端级别的熔断器
+	Command Name
+	// From 变化之前的状态
+	From BreakerState
+	// To 变化之后的状态
+	To BreakerState
+	// At 变化的时间
+	At time.Time
+}
+
+// BreakerOption 熔断器选项, 零值字段使用默认值
+type BreakerOption struct {
+	// Window 统计错误率及慢调用比例的滑动窗口, 为0时使用 DefaultBreakerWindow
+	Window time.Duration
+	// MinRequests 窗口内的请求数达到该值之后才会触发熔断, 为0时使用 DefaultBreakerMinRequests
+	MinRequests int
+	// ErrorRate 触发熔断的错误率, 取值 (0, 1], 为0时使用 DefaultBreakerErrorRate
+	ErrorRate float64
+	// SlowCallDuration 耗时达到该值的调用视为慢调用, 为0时不统计慢调用
+	SlowCallDuration time.Duration
+	// SlowCallRate 触发熔断的慢调用比例, 取值 (0, 1], 为0时与 ErrorRate 相同
+	SlowCallRate float64
+	// OpenTimeout 熔断持续时间, 结束之后进入半开状态, 为0时使用 DefaultBreakerOpenTimeout
+	OpenTimeout time.Duration
+	// HalfOpenRequests 半开状态下允许通过的试探请求数, 全部成功之后关闭熔断器, 为0时为1
+	HalfOpenRequests int
+	// IsFailure 判断交换结果是否计为失败, 为nil时使用 IsBreakerFailure
+	IsFailure func(err error) bool
+	// OnStateChange 熔断器状态变化时的回调, 在状态变化的 goroutine 中同步调用
+	OnStateChange func(event BreakerEvent)
+	// Patterns 服务端注册的参数化命令, 格式与 Router.Handle 相同, 例如 /team/:teamId/member/list.
+	// 匹配其中某个模式的命令共用该模式的熔断器, 未列出的参数化命令将按完整的命令名称分别创建熔断器且不会回收
+	Patterns []Name
+}
+
+// IsBreakerFailure 默认的失败判断, 连接异常等非服务端返回的异常, 以及服务端的未知、内部、下线、繁忙及限流异常计为失败,
+// 数据校验等业务异常及客户端的取消不计为失败
+func IsBreakerFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	errInfo, ok := transportstream.ErrConvert(err)
+	if !ok {
+		return true
+	}
+	switch errInfo.Code {
+	case errors.ErrCodeUnknown, errors.ErrServerInside, errors.ErrCodeServerDraining,
+		errors.ErrCodeServerBusy, errors.ErrCodeTooManyRequests:
+		return true
+	default:
+		return false
+	}
+}
+
+// CircuitBreaker 客户端熔断器, 对每个服务端及服务端上的每个命令分别统计并熔断,
+// 参数化命令按 BreakerOption.Patterns 中匹配到的模式统计
+type CircuitBreaker struct {
+	option   BreakerOption
+	patterns *routeMatcher
+
+	lock     sync.Mutex
+	breakers map[breakerKey]*breaker
+}
+
+type breakerKey struct {
+	server  string
+	command Name
+}
+
+// NewCircuitBreaker 创建熔断器, option 为nil时使用默认选项
+func NewCircuitBreaker(option *BreakerOption) *CircuitBreaker {
+	c := &CircuitBreaker{breakers: map[breakerKey]*breaker{}, patterns: newRouteMatcher()}
+	if option != nil {
+		c.option = *option
+		c.option.Patterns = append([]Name(nil), option.Patterns...)
+	}
+	for _, pattern := range c.option.Patterns {
+		c.patterns.add(&routeEntry{pattern: pattern})
+	}
+	if c.option.Window <= 0 {
+		c.option.Window = DefaultBreakerWindow
+	}
+	if c.option.MinRequests <= 0 {
+		c.option.MinRequests = DefaultBreakerMinRequests
+	}
+	if c.option.ErrorRate <= 0 {
+		c.option.ErrorRate = DefaultBreakerErrorRate
+	}
+	if c.option.SlowCallRate <= 0 {
+		c.option.SlowCallRate = c.option.ErrorRate
+	}
+	if c.option.OpenTimeout <= 0 {
+		c.option.OpenTimeout = DefaultBreakerOpenTimeout
+	}
+	if c.option.HalfOpenRequests <= 0 {
+		c.option.HalfOpenRequests = 1
+	}
+	if c.option.IsFailure == nil {
+		c.option.IsFailure = IsBreakerFailure
+	}
+	return c
+}
+
+// commandKey 命令对应的熔断器名称, 匹配到参数化命令时为注册的模式
+func (c *CircuitBreaker) commandKey(name Name) Name {
+	if name == "" {
+		return name
+	}
+	if entry, _, ok := c.patterns.match(name); ok {
+		return entry.pattern
+	}
+	return name
+}
+
+func (c *CircuitBreaker) get(server string, command Name) *breaker {
+	command = c.commandKey(command)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := breakerKey{server: server, command: command}
+	b, ok := c.breakers[key]
+	if !ok {
+		b = &breaker{server: server, command: command, option: &c.option}
+		b.window.bucketSize = c.option.Window / breakerBuckets
+		c.breakers[key] = b
+	}
+	return b
+}
+
+// State 获取熔断器的当前状态, name 为空时获取服务端级别的熔断器状态, 熔断持续时间结束的熔断器将进入半开状态
+func (c *CircuitBreaker) State(server string, name Name) BreakerState {
+	state, event := c.get(server, name).currentState(time.Now())
+	c.notify(event)
+	return state
+}
+
+// Allow 判断请求是否可以发送, 服务端级别及命令级别的熔断器均允许时才可发送,
+// 不允许时返回 errors.ErrCodeCircuitOpen 异常, 允许时必须在交换结束之后使用交换结果调用一次 done
+func (c *CircuitBreaker) Allow(server string, name Name) (done func(err error), err error) {
+	serverBreaker := c.get(server, "")
+	serverGen, err := c.allow(serverBreaker)
+	if err != nil {
+		return nil, err
+	}
+
+	commandBreaker := c.get(server, name)
+	commandGen, err := c.allow(commandBreaker)
+	if err != nil {
+		serverBreaker.release(serverGen)
+		return nil, err
+	}
+
+	start := time.Now()
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() {
+			now := time.Now()
+			failure := c.option.IsFailure(err)
+			slow := c.option.SlowCallDuration > 0 && now.Sub(start) >= c.option.SlowCallDuration
+			c.notify(serverBreaker.record(serverGen, now, failure, slow))
+			c.notify(commandBreaker.record(commandGen, now, failure, slow))
+		})
+	}, nil
+}
+
+func (c *CircuitBreaker) allow(b *breaker) (uint64, error) {
+	generation, retryAfter, event, ok := b.allow(time.Now())
+	c.notify(event)
+	if ok {
+		return generation, nil
+	}
+
+	msg := fmt.Sprintf("服务端[%s]的熔断器已打开", b.server)
+	if b.command != "" {
+		msg = fmt.Sprintf("服务端[%s]上命令[%s]的熔断器已打开", b.server, b.command)
+	}
+	errInfo, err := errors.ErrCodeCircuitOpen.NewWithData(msg, &errors.RetryInfo{RetryAfter: retryAfter.Milliseconds()})
+	if err != nil {
+		return 0, errors.ErrCodeCircuitOpen.New(msg)
+	}
+	return 0, errInfo
+}
+
+func (c *CircuitBreaker) notify(event *BreakerEvent) {
+	if event != nil && c.option.OnStateChange != nil {
+		c.option.OnStateChange(*event)
+	}
+}
+
+// Exchange 熔断器允许时使用 open 建立流并执行一次命令交换, 不允许时不会建立流, option 可以为nil;
+// 通过 Client 交换时使用 ClientOption.Breaker 设置熔断器
+func (c *CircuitBreaker) Exchange(server string, name Name, open func() (*transportstream.Stream, error), option *ExchangeOption) (ExchangeData, error) {
+	done, err := c.Allow(server, name)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := open()
+	if err != nil {
+		done(err)
+		return nil, err
+	}
+	if option == nil {
+		option = &ExchangeOption{}
+	}
+
+	data, err := name.ExchangeWithOption(stream, option)
+	done(err)
+	return data, err
+}
+
+// breaker 单个服务端或命令的熔断器
+type breaker struct {
+	server  string
+	command Name
+	option  *BreakerOption
+
+	lock sync.Mutex
+	// generation 每次状态变化时递增, 忽略状态变化之前放行的请求的结果
+	generation        uint64
+	state             BreakerState
+	openedAt          time.Time
+	window            breakerWindow
+	halfOpenAdmitted  int
+	halfOpenSucceeded int
+}
+
+// allow 判断请求是否可以通过, 通过时返回请求所属的代数, 不通过时返回建议的重试等待时间
+func (b *breaker) allow(now time.Time) (generation uint64, retryAfter time.Duration, event *BreakerEvent, ok bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.state == BreakerOpen {
+		if remaining := b.option.OpenTimeout - now.Sub(b.openedAt); remaining > 0 {
+			return 0, remaining, nil, false
+		}
+		event = b.setState(BreakerHalfOpen, now)
+	}
+
+	if b.state == BreakerHalfOpen {
+		if b.halfOpenAdmitted >= b.option.HalfOpenRequests {
+			return 0, 0, event, false
+		}
+		b.halfOpenAdmitted++
+	}
+	return b.generation, 0, event, true
+}
+
+// currentState 获取当前状态, 熔断持续时间已结束时进入半开状态并返回变化事件
+func (b *breaker) currentState(now time.Time) (BreakerState, *BreakerEvent) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var event *BreakerEvent
+	if b.state == BreakerOpen && now.Sub(b.openedAt) >= b.option.OpenTimeout {
+		event = b.setState(BreakerHalfOpen, now)
+	}
+	return b.state, event
+}
+
+// release 撤销放行的请求, 用于命令级别的熔断器拒绝时归还服务端级别熔断器的试探名额
+func (b *breaker) release(generation uint64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if generation == b.generation && b.state == BreakerHalfOpen && b.halfOpenAdmitted > 0 {
+		b.halfOpenAdmitted--
+	}
+}
+
+// record 记录请求结果, 状态发生变化时返回变化事件
+func (b *breaker) record(generation uint64, now time.Time, failure, slow bool) *BreakerEvent {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if generation != b.generation {
+		return nil
+	}
+
+	switch b.state {
+	case BreakerClosed:
+		b.window.add(now, failure, slow)
+		if b.shouldTrip(now) {
+			return b.setState(BreakerOpen, now)
+		}
+	case BreakerHalfOpen:
+		if failure || slow {
+			return b.setState(BreakerOpen, now)
+		}
+		b.halfOpenSucceeded++
+		if b.halfOpenSucceeded >= b.option.HalfOpenRequests {
+			return b.setState(BreakerClosed, now)
+		}
+	}
+	return nil
+}
+
+func (b *breaker) shouldTrip(now time.Time) bool {
+	counts := b.window.total(now)
+	if counts.requests < b.option.MinRequests {
+		return false
+	}
+
+	requests := float64(counts.requests)
+	if float64(counts.failures)/requests >= b.option.ErrorRate {
+		return true
+	}
+	return b.option.SlowCallDuration > 0 && float64(counts.slow)/requests >= b.option.SlowCallRate
+}
+
+func (b *breaker) setState(state BreakerState, now time.Time) *BreakerEvent {
+	event := &BreakerEvent{
+		Server:  b.server,
+		Command: b.command,
+		From:    b.state,
+		To:      state,
+		At:      now,
+	}
+
+	b.state = state
+	b.generation++
+	b.halfOpenAdmitted = 0
+	b.halfOpenSucceeded = 0
+	switch state {
+	case BreakerOpen:
+		b.openedAt = now
+	case BreakerClosed:
+		b.window.reset()
+	}
+	return event
+}
+
+type breakerCounts struct {
+	requests int
+	failures int
+	slow     int
+}
+
+// breakerWindow 按时间分桶的滑动窗口
+type breakerWindow struct {
+	bucketSize time.Duration
+	buckets    [breakerBuckets]breakerCounts
+	stamps     [breakerBuckets]int64
+}
+
+func (w *breakerWindow) stamp(now time.Time) int64 {
+	size := int64(w.bucketSize)
+	if size <= 0 {
+		size = 1
+	}
+	return now.UnixNano() / size
+}
+
+func (w *breakerWindow) add(now time.Time, failure, slow bool) {
+	stamp := w.stamp(now)
+	index := stamp % breakerBuckets
+	if w.stamps[index] != stamp {
+		w.stamps[index] = stamp
+		w.buckets[index] = breakerCounts{}
+	}
+
+	bucket := &w.buckets[index]
+	bucket.requests++
+	if failure {
+		bucket.failures++
+	}
+	if slow {
+		bucket.slow++
+	}
+}
+
+func (w *breakerWindow) total(now time.Time) breakerCounts {
+	var counts breakerCounts
+	stamp := w.stamp(now)
+	for i, bucket := range w.buckets {
+		if stamp-w.stamps[i] >= breakerBuckets {
+			continue
+		}
+		counts.requests += bucket.requests
+		counts.failures += bucket.failures
+		counts.slow += bucket.slow
+	}
+	return counts
+}
+
+func (w *breakerWindow) reset() {
+	w.buckets = [breakerBuckets]breakerCounts{}
+	w.stamps = [breakerBuckets]int64{}
+}
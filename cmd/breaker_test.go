@@ -0,0 +1,186 @@
+package cmd_test
+
+import (
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/teamManagement/common/cmd"
+	"github.com/teamManagement/common/errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	a := assert.New(t)
+
+	var healthy int32
+	router := cmd.NewRouter()
+	router.Handle("/ok", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		return nil, nil
+	})
+	router.Handle("/flaky", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		if atomic.LoadInt32(&healthy) == 1 {
+			return nil, nil
+		}
+		return nil, errors.ErrServerInside.New("database unavailable")
+	})
+	router.Handle("/invalid", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		return nil, errors.ErrCodeValidation.New("invalid")
+	})
+
+	var opened int32
+	open := func() (*transportstream.Stream, error) {
+		atomic.AddInt32(&opened, 1)
+		return serveOnce(router), nil
+	}
+
+	var (
+		lock   sync.Mutex
+		events []cmd.BreakerEvent
+	)
+	breaker := cmd.NewCircuitBreaker(&cmd.BreakerOption{
+		MinRequests: 2,
+		OpenTimeout: 50 * time.Millisecond,
+		OnStateChange: func(event cmd.BreakerEvent) {
+			lock.Lock()
+			defer lock.Unlock()
+			events = append(events, event)
+		},
+	})
+
+	for i := 0; i < 3; i++ {
+		_, err := breaker.Exchange("primary", "/ok", open, nil)
+		a.NoError(err)
+	}
+	for i := 0; i < 2; i++ {
+		_, err := breaker.Exchange("primary", "/invalid", open, nil)
+		a.Error(err)
+	}
+	a.Equal(cmd.BreakerClosed, breaker.State("primary", "/invalid"))
+
+	for i := 0; i < 2; i++ {
+		_, err := breaker.Exchange("primary", "/flaky", open, nil)
+		a.Error(err)
+	}
+	a.Equal(cmd.BreakerOpen, breaker.State("primary", "/flaky"))
+	a.Equal(cmd.BreakerClosed, breaker.State("primary", ""))
+
+	before := atomic.LoadInt32(&opened)
+	_, err := breaker.Exchange("primary", "/flaky", open, nil)
+	errInfo, ok := transportstream.ErrConvert(err)
+	if a.True(ok) {
+		a.Equal(errors.ErrCodeCircuitOpen, errInfo.Code)
+	}
+	retryAfter, ok := errors.RetryAfter(err)
+	a.True(ok)
+	a.True(retryAfter > 0 && retryAfter <= 50*time.Millisecond)
+	a.Equal(before, atomic.LoadInt32(&opened))
+
+	_, err = breaker.Exchange("primary", "/ok", open, nil)
+	a.NoError(err)
+	_, err = breaker.Exchange("secondary", "/flaky", open, nil)
+	a.Error(err)
+	a.Equal(cmd.BreakerClosed, breaker.State("secondary", "/flaky"))
+
+	time.Sleep(60 * time.Millisecond)
+	atomic.StoreInt32(&healthy, 1)
+	_, err = breaker.Exchange("primary", "/flaky", open, nil)
+	a.NoError(err)
+	a.Equal(cmd.BreakerClosed, breaker.State("primary", "/flaky"))
+
+	lock.Lock()
+	defer lock.Unlock()
+	var transitions []string
+	for _, event := range events {
+		a.Equal("primary", event.Server)
+		a.Equal(cmd.Name("/flaky"), event.Command)
+		transitions = append(transitions, event.From.String()+"->"+event.To.String())
+	}
+	a.Equal([]string{"closed->open", "open->half-open", "half-open->closed"}, transitions)
+}
+
+func TestCircuitBreakerHalfOpenFailure(t *testing.T) {
+	a := assert.New(t)
+
+	router := cmd.NewRouter()
+	router.Handle("/slow", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		time.Sleep(20 * time.Millisecond)
+		return nil, nil
+	})
+	open := func() (*transportstream.Stream, error) {
+		return serveOnce(router), nil
+	}
+
+	breaker := cmd.NewCircuitBreaker(&cmd.BreakerOption{
+		MinRequests:      1,
+		SlowCallDuration: 10 * time.Millisecond,
+		OpenTimeout:      30 * time.Millisecond,
+	})
+
+	_, err := breaker.Exchange("primary", "/slow", open, nil)
+	a.NoError(err)
+	a.Equal(cmd.BreakerOpen, breaker.State("primary", "/slow"))
+	a.Equal(cmd.BreakerOpen, breaker.State("primary", ""))
+
+	time.Sleep(40 * time.Millisecond)
+	done, err := breaker.Allow("primary", "/slow")
+	a.NoError(err)
+	a.Equal(cmd.BreakerHalfOpen, breaker.State("primary", "/slow"))
+
+	_, err = breaker.Allow("primary", "/slow")
+	errInfo, ok := transportstream.ErrConvert(err)
+	if a.True(ok) {
+		a.Equal(errors.ErrCodeCircuitOpen, errInfo.Code)
+	}
+
+	done(errors.ErrCodeServerBusy.New("busy"))
+	a.Equal(cmd.BreakerOpen, breaker.State("primary", "/slow"))
+	a.Equal(cmd.BreakerOpen, breaker.State("primary", ""))
+}
+
+func TestCircuitBreakerPatterns(t *testing.T) {
+	a := assert.New(t)
+
+	router := cmd.NewRouter()
+	router.Handle("/team/:teamId/member/list", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		return nil, errors.ErrServerInside.New("database unavailable")
+	})
+	open := func() (*transportstream.Stream, error) {
+		return serveOnce(router), nil
+	}
+
+	var events []cmd.BreakerEvent
+	breaker := cmd.NewCircuitBreaker(&cmd.BreakerOption{
+		MinRequests: 2,
+		OpenTimeout: 30 * time.Millisecond,
+		Patterns:    []cmd.Name{"/team/:teamId/member/list"},
+		OnStateChange: func(event cmd.BreakerEvent) {
+			if event.Command != "" {
+				events = append(events, event)
+			}
+		},
+	})
+
+	_, err := breaker.Exchange("primary", "/team/1/member/list", open, nil)
+	a.Error(err)
+	_, err = breaker.Exchange("primary", "/team/2/member/list", open, nil)
+	a.Error(err)
+	a.Equal(cmd.BreakerOpen, breaker.State("primary", "/team/3/member/list"))
+	a.Equal(cmd.BreakerOpen, breaker.State("primary", "/team/:teamId/member/list"))
+
+	_, err = breaker.Exchange("primary", "/team/4/member/list", open, nil)
+	errInfo, ok := transportstream.ErrConvert(err)
+	if a.True(ok) {
+		a.Equal(errors.ErrCodeCircuitOpen, errInfo.Code)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	a.Equal(cmd.BreakerHalfOpen, breaker.State("primary", "/team/1/member/list"))
+	if a.Len(events, 2) {
+		a.Equal(cmd.Name("/team/:teamId/member/list"), events[0].Command)
+		a.Equal(cmd.BreakerOpen, events[0].To)
+		a.Equal(cmd.BreakerHalfOpen, events[1].To)
+	}
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/teamManagement/common/errors"
+	"sync/atomic"
+	"time"
+)
+
+// ConcurrencyLimit 并发执行限制
+type ConcurrencyLimit struct {
+	// MaxConcurrent 最大并发执行数, 小于等于0时不限制
+	MaxConcurrent int
+	// MaxQueue 超出并发数之后允许排队等待的最大数量, 为0时不排队直接拒绝
+	MaxQueue int
+	// QueueTimeout 排队的最长等待时间, 为0时一直等待
+	QueueTimeout time.Duration
+}
+
+// enabled 是否需要限制并发, MaxConcurrent 小于等于0时无法创建有效的信号量, 视为不限制
+func (l *ConcurrencyLimit) enabled() bool {
+	return l != nil && l.MaxConcurrent > 0
+}
+
+// bulkhead 带排队功能的信号量, 用于隔离不同命令及连接之间的资源占用
+type bulkhead struct {
+	limit   *ConcurrencyLimit
+	sem     chan struct{}
+	waiting int32
+}
+
+func newBulkhead(limit *ConcurrencyLimit) *bulkhead {
+	return &bulkhead{
+		limit: limit,
+		sem:   make(chan struct{}, limit.MaxConcurrent),
+	}
+}
+
+// acquire 获取执行名额, 超出排队数量或排队超时返回false
+func (b *bulkhead) acquire() bool {
+	select {
+	case b.sem <- struct{}{}:
+		return true
+	default:
+	}
+
+	if atomic.AddInt32(&b.waiting, 1) > int32(b.limit.MaxQueue) {
+		atomic.AddInt32(&b.waiting, -1)
+		return false
+	}
+	defer atomic.AddInt32(&b.waiting, -1)
+
+	if b.limit.QueueTimeout <= 0 {
+		b.sem <- struct{}{}
+		return true
+	}
+
+	timer := time.NewTimer(b.limit.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case b.sem <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (b *bulkhead) release() {
+	<-b.sem
+}
+
+// SetConcurrencyLimit 设置 DefaultRouter 中命令的并发执行限制, limit 为nil或 MaxConcurrent 小于等于0时取消限制
+func SetConcurrencyLimit(name Name, limit *ConcurrencyLimit) {
+	DefaultRouter.SetConcurrencyLimit(name, limit)
+}
+
+// SetConcurrencyLimit 设置命令的并发执行限制, 参数化命令使用注册时的名称, limit 为nil或 MaxConcurrent 小于等于0时取消限制
+func (r *Router) SetConcurrencyLimit(name Name, limit *ConcurrencyLimit) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !limit.enabled() {
+		delete(r.bulkheads, name)
+		return
+	}
+	r.bulkheads[name] = newBulkhead(limit)
+}
+
+// SetConnConcurrencyLimit 设置 DefaultRouter 中单条连接上所有命令的并发执行限制, limit 为nil或 MaxConcurrent 小于等于0时取消限制
+func SetConnConcurrencyLimit(limit *ConcurrencyLimit) {
+	DefaultRouter.SetConnConcurrencyLimit(limit)
+}
+
+// SetConnConcurrencyLimit 设置单条连接上所有命令的并发执行限制, 仅对之后建立的会话生效, limit 为nil或 MaxConcurrent 小于等于0时取消限制
+func (r *Router) SetConnConcurrencyLimit(limit *ConcurrencyLimit) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !limit.enabled() {
+		r.connConcurrency = nil
+		return
+	}
+	r.connConcurrency = limit
+}
+
+func (r *Router) newConnBulkhead() *bulkhead {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.connConcurrency == nil {
+		return nil
+	}
+	return newBulkhead(r.connConcurrency)
+}
+
+func emptyRelease() {}
+
+// acquireBulkheads 依次获取连接及命令的执行名额, 成功时返回释放函数
+func (r *Router) acquireBulkheads(request *Request) (func(), *transportstream.ErrInfo) {
+	r.lock.RLock()
+	cmdBulkhead := r.bulkheads[request.limitKey()]
+	r.lock.RUnlock()
+
+	// 批量执行中的命令复用外层 Batch 命令占用的连接名额, 避免重复获取导致死锁
+	var connBulkhead *bulkhead
+	if request.Session != nil && !request.nested {
+		connBulkhead = request.Session.bulkhead
+	}
+
+	if connBulkhead != nil && !connBulkhead.acquire() {
+		return emptyRelease, errors.ErrCodeServerBusy.New("服务器繁忙, 当前连接执行中的命令过多")
+	}
+
+	if cmdBulkhead != nil && !cmdBulkhead.acquire() {
+		if connBulkhead != nil {
+			connBulkhead.release()
+		}
+		return emptyRelease, errors.ErrCodeServerBusy.Newf("服务器繁忙, 命令[%s]执行数量已达上限", request.Name)
+	}
+
+	return func() {
+		if cmdBulkhead != nil {
+			cmdBulkhead.release()
+		}
+		if connBulkhead != nil {
+			connBulkhead.release()
+		}
+	}, nil
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestBulkhead(t *testing.T) {
+	a := assert.New(t)
+
+	b := newBulkhead(&ConcurrencyLimit{MaxConcurrent: 1, MaxQueue: 1, QueueTimeout: 20 * time.Millisecond})
+	a.True(b.acquire())
+
+	// 排队超时
+	a.False(b.acquire())
+
+	acquired := make(chan bool)
+	go func() {
+		acquired <- b.acquire()
+	}()
+	time.Sleep(5 * time.Millisecond)
+
+	// 排队已满
+	a.False(b.acquire())
+
+	b.release()
+	a.True(<-acquired)
+	b.release()
+}
+
+func TestRouterConcurrencyLimit(t *testing.T) {
+	a := assert.New(t)
+
+	limited, unlimited := NewRouter(), NewRouter()
+	limited.SetConcurrencyLimit("/team/list", &ConcurrencyLimit{MaxConcurrent: 1})
+
+	request := &Request{Name: "/team/list", Pattern: "/team/list"}
+	release, errInfo := limited.acquireBulkheads(request)
+	a.Nil(errInfo)
+	_, errInfo = limited.acquireBulkheads(request)
+	a.NotNil(errInfo)
+
+	otherRelease, errInfo := unlimited.acquireBulkheads(request)
+	a.Nil(errInfo)
+	otherRelease()
+
+	release()
+	release, errInfo = limited.acquireBulkheads(request)
+	a.Nil(errInfo)
+	release()
+}
+
+func TestConcurrencyLimitDisabled(t *testing.T) {
+	a := assert.New(t)
+
+	router := NewRouter()
+	router.SetConcurrencyLimit("/team/list", &ConcurrencyLimit{MaxConcurrent: 0, QueueTimeout: time.Millisecond})
+	router.SetConnConcurrencyLimit(&ConcurrencyLimit{MaxConcurrent: -1})
+	a.Nil(router.newConnBulkhead())
+
+	request := &Request{Name: "/team/list", Pattern: "/team/list"}
+	for i := 0; i < 3; i++ {
+		_, errInfo := router.acquireBulkheads(request)
+		a.Nil(errInfo)
+	}
+}
@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/teamManagement/common/errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// HeaderCancellable 客户端声明本次交换可能被取消, 服务端将在 Handler 执行期间监听取消消息;
+	// 通过 Router.Route 处理且未提供 quic.Stream 时忽略该请求头
+	HeaderCancellable = "Cancellable"
+	// maxCancelBufferedBytes 监听取消消息期间, 除下一个待读取的消息之外 Handler 尚未读取的预读数据的最大字节数,
+	// 超出时视为数据大小超出限制并结束交换, 单个消息的大小由命令的数据大小限制约束
+	maxCancelBufferedBytes = 64 << 10
+)
+
+// isCancelFrame 是否为客户端发送的取消消息
+func isCancelFrame(frame []byte) bool {
+	if len(frame) < 2 || transportstream.MsgFlag(frame[0]) != transportstream.MsgFlagErr {
+		return false
+	}
+
+	var errInfo transportstream.ErrInfo
+	if err := json.Unmarshal(frame[1:], &errInfo); err != nil {
+		return false
+	}
+	return errInfo.Code == errors.ErrCodeCancelled
+}
+
+type cancelFrame struct {
+	frame []byte
+	err   error
+}
+
+// frameCancelReader 按帧读取数据, 开始监听之后在后台预读, 读取到取消消息时取消 Handler 的 context,
+// 取消消息本身仍会交给 Handler 或排空逻辑读取. Handler 读取第一个消息(请求数据)之前持续预读, 以便及时发现排在请求数据之后的取消消息,
+// 请求数据之后的预读数据不能超过 maxCancelBufferedBytes; 之后最多预读一个消息, 等待 Handler 读取之后再继续
+type frameCancelReader struct {
+	r       io.Reader
+	limiter *frameLimitReader
+	pending bytes.Buffer
+	err     error
+
+	watching int32
+	lock     sync.Mutex
+	frames   []cancelFrame
+	buffered int64
+	taken    bool
+	ready    chan struct{}
+	drained  chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+func newFrameCancelReader(r io.Reader, limiter *frameLimitReader) *frameCancelReader {
+	return &frameCancelReader{
+		r:       r,
+		limiter: limiter,
+		ready:   make(chan struct{}, 1),
+		drained: make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+	}
+}
+
+// watch 开始在后台预读并监听取消消息, 只能调用一次
+func (f *frameCancelReader) watch(cancel context.CancelFunc) {
+	if !atomic.CompareAndSwapInt32(&f.watching, 0, 1) {
+		return
+	}
+
+	go func() {
+		for {
+			frame, err := readFrame(f.r)
+			if err == nil && isCancelFrame(frame) {
+				cancel()
+			}
+
+			if f.push(cancelFrame{frame: frame, err: err}) {
+				// 同时取消 Handler, 避免仅等待 context 的 Handler 无法结束
+				f.limiter.markExceeded()
+				cancel()
+				f.push(cancelFrame{err: io.EOF})
+				return
+			}
+			if err != nil || !f.waitDrained() {
+				return
+			}
+		}
+	}()
+}
+
+// waitDrained Handler 已读取请求数据时等待预读的消息被读取, 返回false时已停止监听
+func (f *frameCancelReader) waitDrained() bool {
+	for {
+		f.lock.Lock()
+		idle := !f.taken || len(f.frames) == 0
+		f.lock.Unlock()
+		if idle {
+			break
+		}
+
+		select {
+		case <-f.drained:
+		case <-f.stopped:
+			return false
+		}
+	}
+
+	select {
+	case <-f.stopped:
+		return false
+	default:
+		return true
+	}
+}
+
+// push 将预读的消息加入队列, 返回下一个待读取的消息之后的预读数据是否超出 maxCancelBufferedBytes
+func (f *frameCancelReader) push(frame cancelFrame) bool {
+	f.lock.Lock()
+	f.frames = append(f.frames, frame)
+	f.buffered += int64(len(frame.frame))
+	exceeded := f.buffered-int64(len(f.frames[0].frame)) > maxCancelBufferedBytes
+	f.lock.Unlock()
+
+	select {
+	case f.ready <- struct{}{}:
+	default:
+	}
+	return exceeded
+}
+
+// next 取出预读的消息, 队列为空时等待预读或停止监听
+func (f *frameCancelReader) next() ([]byte, error) {
+	for {
+		f.lock.Lock()
+		if len(f.frames) > 0 {
+			next := f.frames[0]
+			f.frames[0] = cancelFrame{}
+			f.frames = f.frames[1:]
+			f.buffered -= int64(len(next.frame))
+			f.taken = true
+			f.lock.Unlock()
+
+			select {
+			case f.drained <- struct{}{}:
+			default:
+			}
+			return next.frame, next.err
+		}
+		f.lock.Unlock()
+
+		select {
+		case <-f.ready:
+		case <-f.stopped:
+			return nil, io.EOF
+		}
+	}
+}
+
+// isWatching 是否已开始监听取消消息
+func (f *frameCancelReader) isWatching() bool {
+	return atomic.LoadInt32(&f.watching) == 1
+}
+
+// stop 停止后台预读
+func (f *frameCancelReader) stop() {
+	f.stopOnce.Do(func() {
+		close(f.stopped)
+	})
+}
+
+func (f *frameCancelReader) Read(p []byte) (int, error) {
+	if f.pending.Len() > 0 {
+		return f.pending.Read(p)
+	}
+	if f.err != nil {
+		return 0, f.err
+	}
+
+	var (
+		frame []byte
+		err   error
+	)
+	if f.isWatching() {
+		frame, err = f.next()
+	} else {
+		frame, err = readFrame(f.r)
+	}
+	if err != nil {
+		f.err = err
+		return 0, err
+	}
+
+	writeFrame(&f.pending, frame)
+	return f.pending.Read(p)
+}
+
+// watchCancel 在 ctx 被取消时向服务端发送取消消息, 返回的函数用于停止监听, 停止之后才可继续写入流
+func watchCancel(ctx context.Context, stream *transportstream.Stream, writeLock *sync.Mutex) func() {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		select {
+		case <-ctx.Done():
+			writeLock.Lock()
+			defer writeLock.Unlock()
+			_ = stream.WriteError(errors.ErrCodeCancelled.New("客户端已取消: " + ctx.Err().Error()))
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+	}
+}
@@ -0,0 +1,173 @@
+package cmd_test
+
+import (
+	"context"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/teamManagement/common/cmd"
+	"github.com/teamManagement/common/cmdtest"
+	"github.com/teamManagement/common/errors"
+	"testing"
+	"time"
+)
+
+func TestExchangeCancel(t *testing.T) {
+	a := assert.New(t)
+
+	started := make(chan struct{})
+	handlerErr := make(chan error, 1)
+	router := cmd.NewRouter()
+	router.Handle("/slow", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		if _, err := stream.ReceiveMsg(); err != nil {
+			return nil, err
+		}
+		close(started)
+
+		ctx := cmd.RequestOf(quicStream).Context()
+		select {
+		case <-ctx.Done():
+			handlerErr <- ctx.Err()
+			return nil, ctx.Err()
+		case <-time.After(cmdtest.DefaultTimeout):
+			handlerErr <- nil
+			return cmd.NewExchangeDataByJson("done")
+		}
+	})
+	router.Handle("/fast", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		if _, err := stream.ReceiveMsg(); err != nil {
+			return nil, err
+		}
+		return cmd.NewExchangeDataByJson("done")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	_, err := cmdtest.CallWithOption(t, router, "/slow", &cmd.ExchangeOption{Context: ctx})
+	errInfo, ok := transportstream.ErrConvert(err)
+	if a.True(ok) {
+		a.Equal(errors.ErrCodeCancelled, errInfo.Code)
+	}
+	a.Equal(context.Canceled, <-handlerErr)
+
+	data, err := cmdtest.CallWithOption(t, router, "/fast", &cmd.ExchangeOption{Context: context.Background(), Compress: true})
+	a.NoError(err)
+	var res string
+	a.NoError(data.UnmarshalJson(&res))
+	a.Equal("done", res)
+
+	_, err = cmdtest.CallWithOption(t, router, "/fast", &cmd.ExchangeOption{Context: ctx})
+	errInfo, ok = transportstream.ErrConvert(err)
+	if a.True(ok) {
+		a.Equal(errors.ErrCodeCancelled, errInfo.Code)
+	}
+}
+
+func TestExchangeCancelWhileReceiving(t *testing.T) {
+	a := assert.New(t)
+
+	router := cmd.NewRouter()
+	router.Handle("/upload", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		for {
+			if _, err := stream.ReceiveMsg(); err != nil {
+				return nil, err
+			}
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := cmdtest.CallWithOption(t, router, "/upload", &cmd.ExchangeOption{Context: ctx})
+	errInfo, ok := transportstream.ErrConvert(err)
+	if a.True(ok) {
+		a.Equal(errors.ErrCodeCancelled, errInfo.Code)
+	}
+}
+
+func TestExchangeCancelBehindUnreadMessages(t *testing.T) {
+	a := assert.New(t)
+
+	router := cmd.NewRouter()
+	router.Handle("/slow", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		ctx := cmd.RequestOf(quicStream).Context()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	client, server := cmdtest.Pipe()
+	go func() {
+		_ = router.ServeStream(server)
+	}()
+	stream := client.TransportStream()
+
+	_, err := cmd.Name("/slow").SendCommandWithHeader(stream, cmd.Header{cmd.HeaderCancellable: "true"})
+	if !a.NoError(err) {
+		return
+	}
+	// Handler 未读取的消息较多时仍能发现之后的取消消息
+	for i := 0; i < 200; i++ {
+		a.NoError(stream.WriteMsg([]byte("data"), transportstream.MsgFlagSuccess))
+	}
+	a.NoError(stream.WriteError(errors.ErrCodeCancelled.New("客户端已取消")))
+
+	_, err = stream.ReceiveMsg()
+	errInfo, ok := transportstream.ErrConvert(err)
+	if a.True(ok) {
+		a.Equal(errors.ErrCodeCancelled, errInfo.Code)
+	}
+	a.NoError(stream.WriteEndMsg())
+}
+
+func TestExchangeCancelReadAheadLimit(t *testing.T) {
+	a := assert.New(t)
+
+	router := cmd.NewRouter()
+	router.Handle("/slow", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		ctx := cmd.RequestOf(quicStream).Context()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	call := func(frames ...[]byte) error {
+		client, server := cmdtest.Pipe()
+		go func() {
+			_ = router.ServeStream(server)
+		}()
+		stream := client.TransportStream()
+
+		if _, err := cmd.Name("/slow").SendCommandWithHeader(stream, cmd.Header{cmd.HeaderCancellable: "true"}); err != nil {
+			return err
+		}
+		written := make(chan struct{})
+		go func() {
+			defer close(written)
+			for _, frame := range frames {
+				if stream.WriteMsg(frame, transportstream.MsgFlagSuccess) != nil {
+					return
+				}
+			}
+			_ = stream.WriteError(errors.ErrCodeCancelled.New("客户端已取消"))
+		}()
+		_, err := stream.ReceiveMsg()
+		<-written
+		_ = stream.WriteEndMsg()
+		return err
+	}
+
+	// 请求数据本身只受命令的数据大小限制约束
+	err := call(make([]byte, 256<<10))
+	a.True(errors.ErrCodeCancelled.Equal(err), err)
+
+	// 请求数据之后 Handler 未读取的数据过多时结束交换
+	frames := make([][]byte, 128)
+	for i := range frames {
+		frames[i] = make([]byte, 1<<10)
+	}
+	err = call(frames...)
+	a.True(errors.ErrCodePayloadTooLarge.Equal(err), err)
+}
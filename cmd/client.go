@@ -0,0 +1,548 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/teamManagement/common/errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	idempotentLock sync.RWMutex
+	idempotents    = map[Name]bool{}
+)
+
+// SetIdempotent 设置命令是否幂等, 幂等命令在交换中途失败时 Client 可以在其他服务端上重试
+func SetIdempotent(name Name, idempotent bool) {
+	idempotentLock.Lock()
+	defer idempotentLock.Unlock()
+
+	if !idempotent {
+		delete(idempotents, name)
+		return
+	}
+	idempotents[name] = true
+}
+
+// IsIdempotent 命令是否幂等
+func IsIdempotent(name Name) bool {
+	idempotentLock.RLock()
+	defer idempotentLock.RUnlock()
+	return idempotents[name]
+}
+
+// Resolver 解析服务端地址列表
+type Resolver interface {
+	Resolve(ctx context.Context) ([]string, error)
+}
+
+// StaticResolver 固定的服务端地址列表
+type StaticResolver []string
+
+// Resolve 返回固定的地址列表
+func (r StaticResolver) Resolve(ctx context.Context) ([]string, error) {
+	return r, nil
+}
+
+// BalancePolicy 负载均衡策略
+type BalancePolicy int
+
+const (
+	// RoundRobin 轮询
+	RoundRobin BalancePolicy = iota
+	// LeastInflight 选择执行中的命令最少的服务端
+	LeastInflight
+)
+
+const (
+	// DefaultDialTimeout 默认的建立连接超时时间
+	DefaultDialTimeout = 10 * time.Second
+	// DefaultEjectFailures 默认的剔除服务端的连续失败次数
+	DefaultEjectFailures = 3
+	// DefaultEjectDuration 默认的服务端剔除时长
+	DefaultEjectDuration = 30 * time.Second
+	// DefaultResolveInterval 默认的重新解析地址的间隔
+	DefaultResolveInterval = 30 * time.Second
+)
+
+// ClientOption 多服务端客户端选项
+type ClientOption struct {
+	// Addrs 服务端地址列表, Resolver 不为nil时忽略
+	Addrs []string
+	// Resolver 服务端地址解析器, 定期重新解析以感知服务端的变化
+	Resolver Resolver
+	// ResolveInterval 重新解析地址的间隔, 为0时使用 DefaultResolveInterval
+	ResolveInterval time.Duration
+	// TLSConfig 建立连接使用的TLS配置
+	TLSConfig *tls.Config
+	// QuicConfig 建立连接使用的QUIC配置, 可以为nil
+	QuicConfig *quic.Config
+	// Dial 建立连接, 为nil时使用 TLSConfig 及 QuicConfig 建立QUIC连接
+	Dial func(ctx context.Context, addr string) (quic.Connection, error)
+	// DialTimeout 建立连接及流的超时时间, 为0时使用 DefaultDialTimeout
+	DialTimeout time.Duration
+	// Policy 负载均衡策略
+	Policy BalancePolicy
+	// EjectFailures 连续失败达到该次数之后剔除服务端, 为0时使用 DefaultEjectFailures
+	EjectFailures int
+	// EjectDuration 服务端被剔除的时长, 到期之后重新参与负载均衡, 为0时使用 DefaultEjectDuration
+	EjectDuration time.Duration
+	// MaxAttempts 单次交换最多尝试的服务端数量, 为0时尝试所有服务端
+	MaxAttempts int
+	// Breaker 不为nil时以服务端地址为标识对每个服务端及命令熔断, 熔断器打开的服务端不建立流,
+	// 视为命令未发送并切换至其他服务端, 所有服务端均熔断时返回 errors.ErrCodeCircuitOpen 异常
+	Breaker *CircuitBreaker
+}
+
+// EndpointStatus 服务端的状态
+type EndpointStatus struct {
+	// Addr 服务端地址
+	Addr string
+	// Healthy 是否参与负载均衡
+	Healthy bool
+	// Inflight 执行中的命令数
+	Inflight int64
+	// Failures 连续失败次数
+	Failures int
+	// EjectedUntil 剔除的截止时间, 未被剔除时为零值
+	EjectedUntil time.Time
+}
+
+// Client 连接多个服务端的客户端, 在健康的服务端之间均衡命令,
+// 剔除连续失败的服务端, 并在服务端未执行命令或命令幂等时透明地切换至其他服务端重试
+type Client struct {
+	option ClientOption
+
+	lock      sync.RWMutex
+	endpoints []*endpoint
+	next      uint64
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// NewClient 创建客户端, 首次解析地址失败或地址列表为空时返回异常
+func NewClient(option *ClientOption) (*Client, error) {
+	c := &Client{closed: make(chan struct{})}
+	if option != nil {
+		c.option = *option
+	}
+	if c.option.Resolver == nil {
+		c.option.Resolver = StaticResolver(c.option.Addrs)
+	}
+	if c.option.ResolveInterval <= 0 {
+		c.option.ResolveInterval = DefaultResolveInterval
+	}
+	if c.option.DialTimeout <= 0 {
+		c.option.DialTimeout = DefaultDialTimeout
+	}
+	if c.option.EjectFailures <= 0 {
+		c.option.EjectFailures = DefaultEjectFailures
+	}
+	if c.option.EjectDuration <= 0 {
+		c.option.EjectDuration = DefaultEjectDuration
+	}
+	if c.option.Dial == nil {
+		c.option.Dial = func(ctx context.Context, addr string) (quic.Connection, error) {
+			return quic.DialAddrContext(ctx, addr, c.option.TLSConfig, c.option.QuicConfig)
+		}
+	}
+
+	if err := c.resolve(); err != nil {
+		return nil, err
+	}
+	if _, ok := c.option.Resolver.(StaticResolver); !ok {
+		go c.watchResolver()
+	}
+	return c, nil
+}
+
+// resolve 重新解析地址, 保留仍然存在的服务端的连接及状态
+func (c *Client) resolve() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.option.DialTimeout)
+	defer cancel()
+
+	addrs, err := c.option.Resolver.Resolve(ctx)
+	if err != nil {
+		return fmt.Errorf("解析服务端地址失败: %s", err.Error())
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("没有可用的服务端地址")
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	existing := make(map[string]*endpoint, len(c.endpoints))
+	for _, ep := range c.endpoints {
+		existing[ep.addr] = ep
+	}
+
+	endpoints := make([]*endpoint, 0, len(addrs))
+	for _, addr := range addrs {
+		if ep, ok := existing[addr]; ok {
+			endpoints = append(endpoints, ep)
+			delete(existing, addr)
+			continue
+		}
+		endpoints = append(endpoints, &endpoint{addr: addr})
+	}
+	for _, ep := range existing {
+		ep.remove()
+	}
+	c.endpoints = endpoints
+	return nil
+}
+
+func (c *Client) watchResolver() {
+	ticker := time.NewTicker(c.option.ResolveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// 解析失败时继续使用之前的地址
+			_ = c.resolve()
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// Endpoints 获取所有服务端的状态
+func (c *Client) Endpoints() []EndpointStatus {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	now := time.Now()
+	res := make([]EndpointStatus, 0, len(c.endpoints))
+	for _, ep := range c.endpoints {
+		res = append(res, ep.status(now))
+	}
+	return res
+}
+
+// pick 按负载均衡策略从未尝试过的服务端中选择一个, 健康的服务端均已尝试时选择被剔除的服务端
+func (c *Client) pick(tried map[*endpoint]bool) *endpoint {
+	c.lock.RLock()
+	endpoints := c.endpoints
+	c.lock.RUnlock()
+
+	now := time.Now()
+	var healthy, ejected []*endpoint
+	for _, ep := range endpoints {
+		if tried[ep] {
+			continue
+		}
+		if ep.isHealthy(now) {
+			healthy = append(healthy, ep)
+		} else {
+			ejected = append(ejected, ep)
+		}
+	}
+
+	candidates := healthy
+	if len(candidates) == 0 {
+		candidates = ejected
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	start := int(atomic.AddUint64(&c.next, 1) % uint64(len(candidates)))
+	if c.option.Policy != LeastInflight {
+		return candidates[start]
+	}
+
+	var selected *endpoint
+	for i := range candidates {
+		ep := candidates[(start+i)%len(candidates)]
+		if selected == nil || atomic.LoadInt64(&ep.inflight) < atomic.LoadInt64(&selected.inflight) {
+			selected = ep
+		}
+	}
+	return selected
+}
+
+func (c *Client) maxAttempts() int {
+	if c.option.MaxAttempts > 0 {
+		return c.option.MaxAttempts
+	}
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return len(c.endpoints)
+}
+
+// Exchange 选择服务端执行一次命令交换, option 可以为nil.
+// 连接失败或服务端未执行命令(下线、繁忙、限流)时切换至其他服务端重试,
+// 交换中途连接异常时仅对通过 SetIdempotent 声明为幂等的命令重试, 重试时 option 将被重复使用
+func (c *Client) Exchange(name Name, option *ExchangeOption) (ExchangeData, error) {
+	if option == nil {
+		option = &ExchangeOption{}
+	}
+
+	var (
+		tried   = map[*endpoint]bool{}
+		lastErr error
+	)
+	for attempt := 0; attempt < c.maxAttempts(); attempt++ {
+		ep := c.pick(tried)
+		if ep == nil {
+			break
+		}
+		tried[ep] = true
+
+		data, sent, err := c.exchange(ep, name, option)
+		if err == nil || !shouldFailover(err, sent, IsIdempotent(name)) {
+			return data, err
+		}
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("没有可用的服务端")
+	}
+	return nil, lastErr
+}
+
+// exchange 在指定的服务端上执行交换, sent 表示命令是否已发送至服务端
+func (c *Client) exchange(ep *endpoint, name Name, option *ExchangeOption) (data ExchangeData, sent bool, err error) {
+	if c.option.Breaker != nil {
+		var done func(err error)
+		if done, err = c.option.Breaker.Allow(ep.addr, name); err != nil {
+			return nil, false, err
+		}
+		defer func() {
+			done(err)
+		}()
+	}
+
+	atomic.AddInt64(&ep.inflight, 1)
+	defer atomic.AddInt64(&ep.inflight, -1)
+
+	ctx := option.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, c.option.DialTimeout)
+	defer cancel()
+
+	quicStream, err := ep.openStream(dialCtx, c.option.Dial)
+	if err != nil {
+		c.report(ep, err, false)
+		return nil, false, err
+	}
+	defer func() {
+		_ = quicStream.Close()
+		quicStream.CancelRead(0)
+	}()
+
+	stream := transportstream.NewStream(bufio.NewReadWriter(bufio.NewReader(quicStream), bufio.NewWriter(quicStream)))
+	data, err = name.ExchangeWithOption(stream, option)
+	c.report(ep, err, true)
+	return data, true, err
+}
+
+// report 根据交换结果更新服务端的健康状态
+func (c *Client) report(ep *endpoint, err error, sent bool) {
+	if err == nil {
+		ep.succeed()
+		return
+	}
+
+	errInfo, isErrInfo := transportstream.ErrConvert(err)
+	switch {
+	case !sent, !isErrInfo:
+		ep.fail(c.option.EjectFailures, c.option.EjectDuration)
+	case errInfo.Code == errors.ErrCodeServerDraining:
+		ep.fail(1, c.option.EjectDuration)
+	default:
+		ep.succeed()
+	}
+}
+
+// shouldFailover 判断交换失败之后是否可以切换至其他服务端重试
+func shouldFailover(err error, sent bool, idempotent bool) bool {
+	if !sent {
+		return true
+	}
+
+	errInfo, isErrInfo := transportstream.ErrConvert(err)
+	if !isErrInfo {
+		return idempotent
+	}
+	switch errInfo.Code {
+	case errors.ErrCodeServerDraining, errors.ErrCodeServerBusy, errors.ErrCodeTooManyRequests:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close 关闭所有连接
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, ep := range c.endpoints {
+		ep.remove()
+	}
+	c.endpoints = nil
+	return nil
+}
+
+// endpoint 单个服务端的连接及健康状态
+type endpoint struct {
+	addr     string
+	inflight int64
+
+	lock    sync.Mutex
+	conn    quic.Connection
+	dialing *endpointDial
+	removed bool
+
+	// healthLock 保护健康状态, 与连接分开加锁, 选择服务端时不受建立连接的影响
+	healthLock   sync.Mutex
+	failures     int
+	ejectedUntil time.Time
+}
+
+// endpointDial 正在进行的连接建立, 同一服务端同时只建立一个连接, 其他请求等待其结果
+type endpointDial struct {
+	done chan struct{}
+	conn quic.Connection
+	err  error
+}
+
+// openStream 在服务端的连接上建立流, 连接不存在或已断开时重新建立连接
+func (e *endpoint) openStream(ctx context.Context, dial func(ctx context.Context, addr string) (quic.Connection, error)) (quic.Stream, error) {
+	conn, err := e.connection(ctx, dial)
+	if err != nil {
+		return nil, err
+	}
+
+	quicStream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		e.dropConnection(conn)
+		return nil, err
+	}
+	return quicStream, nil
+}
+
+// connection 获取服务端的连接, 建立连接时不持有锁, 同时到达的请求共用同一次连接建立的结果
+func (e *endpoint) connection(ctx context.Context, dial func(ctx context.Context, addr string) (quic.Connection, error)) (quic.Connection, error) {
+	e.lock.Lock()
+	if e.removed {
+		e.lock.Unlock()
+		return nil, fmt.Errorf("服务端[%s]已被移除", e.addr)
+	}
+	if e.conn != nil && e.conn.Context().Err() == nil {
+		conn := e.conn
+		e.lock.Unlock()
+		return conn, nil
+	}
+
+	d := e.dialing
+	if d != nil {
+		e.lock.Unlock()
+		select {
+		case <-d.done:
+			return d.conn, d.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	d = &endpointDial{done: make(chan struct{})}
+	e.dialing = d
+	e.lock.Unlock()
+
+	conn, err := dial(ctx, e.addr)
+
+	e.lock.Lock()
+	e.dialing = nil
+	switch {
+	case err != nil:
+		d.err = fmt.Errorf("连接服务端[%s]失败: %s", e.addr, err.Error())
+	case e.removed:
+		_ = conn.CloseWithError(0, "")
+		d.err = fmt.Errorf("服务端[%s]已被移除", e.addr)
+	default:
+		e.conn = conn
+		d.conn = conn
+	}
+	e.lock.Unlock()
+	close(d.done)
+	return d.conn, d.err
+}
+
+func (e *endpoint) dropConnection(conn quic.Connection) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.conn == conn {
+		_ = conn.CloseWithError(0, "")
+		e.conn = nil
+	}
+}
+
+// remove 服务端已不在地址列表中, 关闭其连接
+func (e *endpoint) remove() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.removed = true
+	if e.conn != nil {
+		_ = e.conn.CloseWithError(0, "")
+		e.conn = nil
+	}
+}
+
+func (e *endpoint) succeed() {
+	e.healthLock.Lock()
+	defer e.healthLock.Unlock()
+	e.failures = 0
+	e.ejectedUntil = time.Time{}
+}
+
+// fail 记录一次失败, 连续失败达到 threshold 次时剔除服务端
+func (e *endpoint) fail(threshold int, duration time.Duration) {
+	e.healthLock.Lock()
+	defer e.healthLock.Unlock()
+
+	e.failures++
+	if e.failures >= threshold {
+		e.ejectedUntil = time.Now().Add(duration)
+	}
+}
+
+func (e *endpoint) isHealthy(now time.Time) bool {
+	e.healthLock.Lock()
+	defer e.healthLock.Unlock()
+	return !now.Before(e.ejectedUntil)
+}
+
+func (e *endpoint) status(now time.Time) EndpointStatus {
+	e.healthLock.Lock()
+	defer e.healthLock.Unlock()
+
+	status := EndpointStatus{
+		Addr:     e.addr,
+		Healthy:  !now.Before(e.ejectedUntil),
+		Inflight: atomic.LoadInt64(&e.inflight),
+		Failures: e.failures,
+	}
+	if !status.Healthy {
+		status.EjectedUntil = e.ejectedUntil
+	}
+	return status
+}
@@ -0,0 +1,233 @@
+package cmd_test
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/teamManagement/common/cmd"
+	"github.com/teamManagement/common/errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type replica struct {
+	name    string
+	addr    string
+	hits    int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func startReplica(t *testing.T, name string, broken bool) *replica {
+	r := &replica{name: name, started: make(chan struct{}, 8), release: make(chan struct{})}
+	router := cmd.NewRouter()
+	router.Handle("/whoami", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		return cmd.NewExchangeDataByJson(r.name)
+	})
+	router.Handle("/block", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		r.started <- struct{}{}
+		<-r.release
+		return cmd.NewExchangeDataByJson(r.name)
+	})
+	hit := func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		atomic.AddInt32(&r.hits, 1)
+		if broken {
+			_ = cmd.RequestOf(quicStream).Session.Conn().CloseWithError(0, "")
+			return nil, fmt.Errorf("connection lost")
+		}
+		return cmd.NewExchangeDataByJson(r.name)
+	}
+	router.Handle("/pay", hit)
+	router.Handle("/query", hit)
+	r.addr = startQuicServer(t, router)
+	return r
+}
+
+func newTestClient(t *testing.T, option *cmd.ClientOption) *cmd.Client {
+	option.TLSConfig = &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"teamManagement"}}
+	option.DialTimeout = 2 * time.Second
+	client, err := cmd.NewClient(option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return client
+}
+
+func whoami(t *testing.T, client *cmd.Client) string {
+	data, err := client.Exchange("/whoami", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var name string
+	if err = data.UnmarshalJson(&name); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestClientBalance(t *testing.T) {
+	a := assert.New(t)
+
+	first, second := startReplica(t, "first", false), startReplica(t, "second", false)
+	client := newTestClient(t, &cmd.ClientOption{Addrs: []string{first.addr, second.addr}})
+
+	counts := map[string]int{}
+	for i := 0; i < 4; i++ {
+		counts[whoami(t, client)]++
+	}
+	a.Equal(map[string]int{"first": 2, "second": 2}, counts)
+
+	client = newTestClient(t, &cmd.ClientOption{Addrs: []string{first.addr, second.addr}, Policy: cmd.LeastInflight})
+	blocked := make(chan string, 1)
+	go func() {
+		data, _ := client.Exchange("/block", nil)
+		var name string
+		_ = data.UnmarshalJson(&name)
+		blocked <- name
+	}()
+
+	var busy, idle *replica
+	select {
+	case <-first.started:
+		busy, idle = first, second
+	case <-second.started:
+		busy, idle = second, first
+	case <-time.After(5 * time.Second):
+		t.Fatal("等待命令执行超时")
+	}
+	for i := 0; i < 3; i++ {
+		a.Equal(idle.name, whoami(t, client))
+	}
+	close(busy.release)
+	a.Equal(busy.name, <-blocked)
+}
+
+func TestClientFailover(t *testing.T) {
+	a := assert.New(t)
+
+	healthy := startReplica(t, "healthy", false)
+	client := newTestClient(t, &cmd.ClientOption{
+		Addrs:         []string{"down", healthy.addr},
+		EjectFailures: 1,
+		Dial: func(ctx context.Context, addr string) (quic.Connection, error) {
+			if addr == "down" {
+				return nil, fmt.Errorf("connection refused")
+			}
+			return quic.DialAddrContext(ctx, addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"teamManagement"}}, nil)
+		},
+	})
+
+	for i := 0; i < 3; i++ {
+		a.Equal("healthy", whoami(t, client))
+	}
+	for _, status := range client.Endpoints() {
+		a.Equal(status.Addr != "down", status.Healthy, status.Addr)
+	}
+
+	broken := startReplica(t, "broken", true)
+	client = newTestClient(t, &cmd.ClientOption{Addrs: []string{broken.addr, healthy.addr}})
+
+	var failed int
+	for i := 0; i < 2; i++ {
+		if _, err := client.Exchange("/pay", nil); err != nil {
+			failed++
+		}
+	}
+	a.Equal(1, failed)
+	a.EqualValues(1, atomic.LoadInt32(&broken.hits))
+	a.EqualValues(1, atomic.LoadInt32(&healthy.hits))
+
+	cmd.SetIdempotent("/query", true)
+	defer cmd.SetIdempotent("/query", false)
+	for i := 0; i < 2; i++ {
+		data, err := client.Exchange("/query", nil)
+		a.NoError(err)
+		var name string
+		a.NoError(data.UnmarshalJson(&name))
+		a.Equal("healthy", name)
+	}
+	a.EqualValues(2, atomic.LoadInt32(&broken.hits))
+}
+
+func TestClientDialOnce(t *testing.T) {
+	a := assert.New(t)
+
+	server := startReplica(t, "server", false)
+	var dials int32
+	dialing, release := make(chan struct{}, 1), make(chan struct{})
+	client := newTestClient(t, &cmd.ClientOption{
+		Addrs: []string{server.addr},
+		Dial: func(ctx context.Context, addr string) (quic.Connection, error) {
+			atomic.AddInt32(&dials, 1)
+			dialing <- struct{}{}
+			<-release
+			return quic.DialAddrContext(ctx, addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"teamManagement"}}, nil)
+		},
+	})
+
+	names := make(chan string, 4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			data, err := client.Exchange("/whoami", nil)
+			var name string
+			if err == nil {
+				_ = data.UnmarshalJson(&name)
+			}
+			names <- name
+		}()
+	}
+
+	select {
+	case <-dialing:
+	case <-time.After(5 * time.Second):
+		t.Fatal("等待建立连接超时")
+	}
+	// 建立连接期间查询服务端状态不会被阻塞
+	a.Len(client.Endpoints(), 1)
+	close(release)
+
+	for i := 0; i < 4; i++ {
+		a.Equal("server", <-names)
+	}
+	a.EqualValues(1, atomic.LoadInt32(&dials))
+}
+
+func TestClientBreaker(t *testing.T) {
+	a := assert.New(t)
+
+	var hits int32
+	router := cmd.NewRouter()
+	router.Handle("/whoami", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		atomic.AddInt32(&hits, 1)
+		return nil, errors.ErrServerInside.New("database unavailable")
+	})
+	failing := startQuicServer(t, router)
+	healthy := startReplica(t, "healthy", false)
+
+	breaker := cmd.NewCircuitBreaker(&cmd.BreakerOption{MinRequests: 1})
+	client := newTestClient(t, &cmd.ClientOption{
+		Addrs:   []string{failing, healthy.addr},
+		Breaker: breaker,
+	})
+
+	var failed int
+	for i := 0; i < 4; i++ {
+		if _, err := client.Exchange("/whoami", nil); err != nil {
+			a.True(errors.ErrServerInside.Equal(err), err)
+			failed++
+		}
+	}
+	// 熔断之后不再向该服务端建立流, 直接切换至其他服务端
+	a.Equal(1, failed)
+	a.EqualValues(1, atomic.LoadInt32(&hits))
+	a.Equal(cmd.BreakerOpen, breaker.State(failing, "/whoami"))
+	a.Equal(cmd.BreakerClosed, breaker.State(healthy.addr, "/whoami"))
+	a.Equal("healthy", whoami(t, client))
+}
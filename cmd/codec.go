@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/teamManagement/common/codec"
+	"sync"
+)
+
+var (
+	commandCodecLock sync.RWMutex
+	commandCodecs    = map[Name]string{}
+)
+
+// SetCodec 设置客户端发送命令时默认使用的编解码器, ExchangeOption.Codec 不为空时以其为准
+func SetCodec(name Name, codecName string) {
+	commandCodecLock.Lock()
+	defer commandCodecLock.Unlock()
+
+	if codecName == "" {
+		delete(commandCodecs, name)
+		return
+	}
+	commandCodecs[name] = codecName
+}
+
+func commandCodec(name Name) string {
+	commandCodecLock.RLock()
+	defer commandCodecLock.RUnlock()
+	return commandCodecs[name]
+}
+
+// UnmarshalCodec 使用指定名称的编解码器反序列化数据
+func (e ExchangeData) UnmarshalCodec(codecName string, i any) error {
+	if e == nil {
+		return nil
+	}
+
+	if err := codec.Unmarshal(codecName, e, i); err != nil {
+		return fmt.Errorf("数据尝试从%s反序列化到结构体失败: %s", codecName, err.Error())
+	}
+	return nil
+}
+
+// NewExchangeDataByCodec 使用指定名称的编解码器序列化数据
+func NewExchangeDataByCodec(codecName string, data any) (ExchangeData, error) {
+	return codec.Marshal(codecName, data)
+}
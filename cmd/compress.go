@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/teamManagement/common/compress"
+	"io"
+	"sync/atomic"
+)
+
+const (
+	// HeaderAcceptEncoding 客户端支持的压缩算法, 多个算法之间使用逗号分隔, 服务端按自身的优先顺序从中选择
+	HeaderAcceptEncoding = "Accept-Encoding"
+	// HeaderContentEncoding 服务端选择的压缩算法, 为空时本次交换不压缩
+	HeaderContentEncoding = "Content-Encoding"
+)
+
+// 协商压缩之后, 每条非空的数据消息首字节为压缩标识
+const (
+	payloadRaw byte = iota
+	payloadCompressed
+)
+
+var compressThreshold int64 = compress.DefaultThreshold
+
+// SetCompressThreshold 设置压缩阈值, 协商压缩之后数据大于等于该字节数时才进行压缩
+func SetCompressThreshold(threshold int64) {
+	atomic.StoreInt64(&compressThreshold, threshold)
+}
+
+// negotiateCompressor 按 compress.Preferred 的顺序从客户端支持的压缩算法中选择, 没有共同支持的算法时返回nil
+func negotiateCompressor(acceptEncoding string) compress.Compressor {
+	c, _ := compress.Negotiate(acceptEncoding)
+	return c
+}
+
+// encodePayload 为数据添加压缩标识, 大于阈值并且压缩有收益时进行压缩
+func encodePayload(c compress.Compressor, data []byte) ([]byte, error) {
+	if c == nil || len(data) == 0 {
+		return data, nil
+	}
+
+	if int64(len(data)) >= atomic.LoadInt64(&compressThreshold) {
+		compressed, err := c.Compress(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(data) {
+			return append([]byte{payloadCompressed}, compressed...), nil
+		}
+	}
+	return append([]byte{payloadRaw}, data...), nil
+}
+
+// decodePayload 根据压缩标识还原数据, limit 为解压之后的最大字节数, 等于0时不限制
+func decodePayload(c compress.Compressor, data []byte, limit int64) ([]byte, error) {
+	if c == nil || len(data) == 0 {
+		return data, nil
+	}
+
+	switch data[0] {
+	case payloadRaw:
+		return data[1:], nil
+	case payloadCompressed:
+		return c.Decompress(data[1:], limit)
+	default:
+		return nil, fmt.Errorf("未知的数据压缩标识: %d", data[0])
+	}
+}
+
+// isPayloadFlag 该标识的消息是否为需要压缩的数据消息, 异常消息不做处理
+func isPayloadFlag(flag byte) bool {
+	return transportstream.MsgFlag(flag) != transportstream.MsgFlagErr
+}
+
+// frameCompressReader 协商压缩之后将读取到的帧解压并重新组帧, 使 Handler 无需感知压缩
+type frameCompressReader struct {
+	r          io.Reader
+	limiter    *frameLimitReader
+	compressor atomic.Value
+	pending    bytes.Buffer
+}
+
+func (f *frameCompressReader) enable(c compress.Compressor) {
+	f.compressor.Store(c)
+}
+
+func (f *frameCompressReader) Read(p []byte) (int, error) {
+	if f.pending.Len() > 0 {
+		return f.pending.Read(p)
+	}
+
+	c, _ := f.compressor.Load().(compress.Compressor)
+	if c == nil {
+		return f.r.Read(p)
+	}
+
+	frame, err := readFrame(f.r)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(frame) > 1 && isPayloadFlag(frame[0]) {
+		data, err := decodePayload(c, frame[1:], atomic.LoadInt64(&f.limiter.limit))
+		if err == compress.ErrTooLarge {
+			f.limiter.markExceeded()
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		frame = append(frame[:1], data...)
+	}
+
+	writeFrame(&f.pending, frame)
+	return f.pending.Read(p)
+}
+
+// frameCompressWriter 协商压缩之后将写出的帧压缩并重新组帧
+type frameCompressWriter struct {
+	w          io.Writer
+	compressor atomic.Value
+	buf        bytes.Buffer
+}
+
+func (f *frameCompressWriter) enable(c compress.Compressor) {
+	f.compressor.Store(c)
+}
+
+func (f *frameCompressWriter) Write(p []byte) (int, error) {
+	c, _ := f.compressor.Load().(compress.Compressor)
+	if c == nil {
+		return f.w.Write(p)
+	}
+
+	f.buf.Write(p)
+	for f.buf.Len() >= 8 {
+		frameLen := int64(binary.BigEndian.Uint64(f.buf.Bytes()[:8]))
+		if int64(f.buf.Len()-8) < frameLen {
+			break
+		}
+
+		f.buf.Next(8)
+		frame := append([]byte(nil), f.buf.Next(int(frameLen))...)
+		if len(frame) > 1 && isPayloadFlag(frame[0]) {
+			data, err := encodePayload(c, frame[1:])
+			if err != nil {
+				return 0, err
+			}
+			frame = append(frame[:1], data...)
+		}
+
+		out := &bytes.Buffer{}
+		writeFrame(out, frame)
+		if _, err := f.w.Write(out.Bytes()); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// readFrame 读取一个完整的帧, 返回不包含长度头的帧内容
+func readFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, binary.BigEndian.Uint64(header))
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+func writeFrame(w *bytes.Buffer, frame []byte) {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint64(header, uint64(len(frame)))
+	w.Write(header)
+	w.Write(frame)
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// HeaderDeprecation 命令已废弃时响应头的值为 true
+	HeaderDeprecation = "Deprecation"
+	// HeaderSunset 已废弃命令计划下线的时间, RFC3339 格式
+	HeaderSunset = "Sunset"
+	// HeaderReplacement 已废弃命令的替代命令
+	HeaderReplacement = "Replacement"
+	// HeaderWarning 服务端的警告信息
+	HeaderWarning = "Warning"
+)
+
+// Deprecation 命令的废弃信息
+type Deprecation struct {
+	// Sunset 计划下线的时间, 零值表示未确定
+	Sunset time.Time
+	// Replacement 替代的命令
+	Replacement Name
+	// Message 提示信息
+	Message string
+}
+
+// warning 生成提示给客户端的警告信息
+func (d *Deprecation) warning(name Name) string {
+	if d.Message != "" {
+		return d.Message
+	}
+
+	msg := fmt.Sprintf("命令[%s]已废弃", name)
+	if !d.Sunset.IsZero() {
+		msg += fmt.Sprintf(", 将于%s下线", d.Sunset.Format("2006-01-02"))
+	}
+	if d.Replacement != "" {
+		msg += fmt.Sprintf(", 请使用[%s]代替", d.Replacement)
+	}
+	return msg
+}
+
+// writeHeader 将废弃信息写入响应头
+func (d *Deprecation) writeHeader(name Name, header Header) {
+	header.Set(HeaderDeprecation, "true")
+	header.Set(HeaderWarning, d.warning(name))
+	if !d.Sunset.IsZero() {
+		header.Set(HeaderSunset, d.Sunset.Format(time.RFC3339))
+	}
+	if d.Replacement != "" {
+		header.Set(HeaderReplacement, string(d.Replacement))
+	}
+}
+
+// DeprecationOf 从响应头中解析命令的废弃信息, 命令未废弃时返回false
+func DeprecationOf(header Header) (*Deprecation, bool) {
+	if header.Get(HeaderDeprecation) != "true" {
+		return nil, false
+	}
+
+	d := &Deprecation{
+		Replacement: Name(header.Get(HeaderReplacement)),
+		Message:     header.Get(HeaderWarning),
+	}
+	if sunset := header.Get(HeaderSunset); sunset != "" {
+		d.Sunset, _ = time.Parse(time.RFC3339, sunset)
+	}
+	return d, true
+}
+
+// DeprecatedCallHook 已废弃命令被调用时的回调, 用于记录日志或统计指标
+type DeprecatedCallHook func(request *Request, deprecation *Deprecation)
+
+// Alias 将 alias 注册为 target 的别名, 调用别名时由 target 的处理函数处理,
+// Request.Name 为别名, Request.Pattern 为 target 匹配到的命令
+func (r *Router) Alias(alias, target Name) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.aliases == nil {
+		r.aliases = map[Name]Name{}
+	}
+	r.aliases[alias] = target
+}
+
+// Deprecate 将命令标记为已废弃, 已废弃的命令仍会正常处理, 但会在响应头中添加警告信息,
+// name 可以为别名或注册的命令, deprecation 为nil时取消废弃标记; 保存的是 deprecation 的副本, 之后修改不会生效
+func (r *Router) Deprecate(name Name, deprecation *Deprecation) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if deprecation == nil {
+		delete(r.deprecations, name)
+		return
+	}
+
+	d := *deprecation
+	if d.Replacement == "" {
+		d.Replacement = r.aliases[name]
+	}
+	if r.deprecations == nil {
+		r.deprecations = map[Name]*Deprecation{}
+	}
+	r.deprecations[name] = &d
+}
+
+// OnDeprecatedCall 设置已废弃命令被调用时的回调
+func (r *Router) OnDeprecatedCall(hook DeprecatedCallHook) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.deprecatedHook = hook
+}
+
+// resolveAlias 获取别名对应的命令, 非别名时原样返回
+func (r *Router) resolveAlias(name Name) Name {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if target, ok := r.aliases[name]; ok {
+		return target
+	}
+	return name
+}
+
+// checkDeprecation 命令已废弃时写入响应头并触发回调
+func (r *Router) checkDeprecation(request *Request) {
+	r.lock.RLock()
+	deprecation, ok := r.deprecations[request.Name]
+	if !ok && request.Pattern != "" {
+		deprecation, ok = r.deprecations[request.Pattern]
+	}
+	hook := r.deprecatedHook
+	r.lock.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	deprecation.writeHeader(request.Name, request.ResponseHeader)
+	if hook != nil {
+		hook(request, deprecation)
+	}
+}
+
+var (
+	deprecationWarningLock    sync.RWMutex
+	deprecationWarningHandler func(name Name, deprecation *Deprecation)
+)
+
+// SetDeprecationWarningHandler 设置客户端调用到已废弃命令时的回调
+func SetDeprecationWarningHandler(handler func(name Name, deprecation *Deprecation)) {
+	deprecationWarningLock.Lock()
+	defer deprecationWarningLock.Unlock()
+	deprecationWarningHandler = handler
+}
+
+func notifyDeprecation(name Name, header Header) {
+	deprecation, ok := DeprecationOf(header)
+	if !ok {
+		return
+	}
+
+	deprecationWarningLock.RLock()
+	handler := deprecationWarningHandler
+	deprecationWarningLock.RUnlock()
+	if handler != nil {
+		handler(name, deprecation)
+	}
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// HeaderContentType 数据的编解码器名称, 为空时使用JSON
+	HeaderContentType = "Content-Type"
+)
+
+// headerSeparator 命令消息中命令名称与头信息之间的分隔符
+const headerSeparator = '\n'
+
+// Header 命令交换的头信息, 请求头随命令消息发送, 响应头随服务端的命令确认消息返回
+type Header map[string]string
+
+// Get 获取头信息, 不存在时返回空字符串
+func (h Header) Get(key string) string {
+	if h == nil {
+		return ""
+	}
+	return h[key]
+}
+
+// Set 设置头信息
+func (h Header) Set(key, value string) {
+	h[key] = value
+}
+
+// Del 删除头信息
+func (h Header) Del(key string) {
+	delete(h, key)
+}
+
+// encodeCommand 编码命令消息, 头信息为空时仅包含命令名称, 以兼容不支持头信息的对端
+func encodeCommand(name Name, header Header) ([]byte, error) {
+	if len(header) == 0 {
+		return []byte(name), nil
+	}
+
+	marshal, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("序列化请求头失败: %s", err.Error())
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(name)+len(marshal)+1))
+	buf.WriteString(string(name))
+	buf.WriteByte(headerSeparator)
+	buf.Write(marshal)
+	return buf.Bytes(), nil
+}
+
+// decodeCommand 解码命令消息
+func decodeCommand(data []byte) (Name, Header, error) {
+	index := bytes.IndexByte(data, headerSeparator)
+	if index < 0 {
+		return Name(data), Header{}, nil
+	}
+
+	header := Header{}
+	if err := json.Unmarshal(data[index+1:], &header); err != nil {
+		return "", nil, fmt.Errorf("解析请求头失败: %s", err.Error())
+	}
+	return Name(data[:index]), header, nil
+}
+
+// decodeHeader 解码命令确认消息中的响应头
+func decodeHeader(data []byte) (Header, error) {
+	header := Header{}
+	if len(data) == 0 {
+		return header, nil
+	}
+
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("解析响应头失败: %s", err.Error())
+	}
+	return header, nil
+}
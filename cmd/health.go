@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Ping 内置的连通性探测命令, 原样返回客户端发送的数据
+	Ping Name = "/_ping"
+	// Health 内置的健康检查命令
+	Health Name = "/_health"
+)
+
+// HealthStatus 健康状态
+type HealthStatus uint8
+
+const (
+	// HealthStatusServing 正常提供服务
+	HealthStatusServing HealthStatus = iota
+	// HealthStatusNotServing 无法提供服务
+	HealthStatusNotServing
+	// HealthStatusDegraded 服务降级, 可以提供服务但存在异常
+	HealthStatusDegraded
+)
+
+func (s HealthStatus) String() string {
+	switch s {
+	case HealthStatusServing:
+		return "SERVING"
+	case HealthStatusNotServing:
+		return "NOT_SERVING"
+	case HealthStatusDegraded:
+		return "DEGRADED"
+	default:
+		return fmt.Sprintf("UNKNOWN(%d)", uint8(s))
+	}
+}
+
+// HealthChecker 组件健康检查函数, msg 为状态的附加描述
+type HealthChecker func() (status HealthStatus, msg string)
+
+// ComponentHealth 单个组件的健康状态
+type ComponentHealth struct {
+	Status  HealthStatus `json:"status"`
+	Message string       `json:"message,omitempty"`
+}
+
+// HealthReport 健康检查结果
+type HealthReport struct {
+	// Status 汇总之后的状态
+	Status HealthStatus `json:"status"`
+	// Draining 服务器是否处于排空模式
+	Draining bool `json:"draining,omitempty"`
+	// Components 各组件的状态
+	Components map[string]*ComponentHealth `json:"components,omitempty"`
+}
+
+var (
+	// reservedCmdMap 内置命令, 优先于路由表匹配, 不受排空模式及中间件影响
+	reservedCmdMap = map[Name]Handler{}
+)
+
+func init() {
+	reservedCmdMap[Ping] = pingHandler
+	reservedCmdMap[Health] = healthHandler
+}
+
+// RegisterHealthChecker 在 DefaultRouter 中注册组件的健康检查函数, 同名组件将被覆盖
+func RegisterHealthChecker(component string, checker HealthChecker) {
+	DefaultRouter.RegisterHealthChecker(component, checker)
+}
+
+// RegisterHealthChecker 注册组件的健康检查函数, 同名组件将被覆盖
+func (r *Router) RegisterHealthChecker(component string, checker HealthChecker) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.healthCheckers[component] = checker
+}
+
+// UnregisterHealthChecker 移除 DefaultRouter 中组件的健康检查函数
+func UnregisterHealthChecker(component string) {
+	DefaultRouter.UnregisterHealthChecker(component)
+}
+
+// UnregisterHealthChecker 移除组件的健康检查函数
+func (r *Router) UnregisterHealthChecker(component string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.healthCheckers, component)
+}
+
+// SetDraining 设置 DefaultRouter 的排空模式
+func SetDraining(d bool) {
+	DefaultRouter.SetDraining(d)
+}
+
+// SetDraining 设置路由表的排空模式, 排空模式下健康检查返回 HealthStatusNotServing,
+// 除内置命令外的所有命令将返回 errors.ErrCodeServerDraining
+func (r *Router) SetDraining(d bool) {
+	var v int32
+	if d {
+		v = 1
+	}
+	atomic.StoreInt32(&r.draining, v)
+}
+
+// IsDraining DefaultRouter 是否处于排空模式
+func IsDraining() bool {
+	return DefaultRouter.IsDraining()
+}
+
+// IsDraining 路由表是否处于排空模式
+func (r *Router) IsDraining() bool {
+	return atomic.LoadInt32(&r.draining) == 1
+}
+
+// CheckHealth 使用 DefaultRouter 中注册的健康检查函数执行健康检查
+func CheckHealth(component string) (*HealthReport, error) {
+	return DefaultRouter.CheckHealth(component)
+}
+
+// CheckHealth 执行健康检查, component 不为空时仅检查对应组件
+func (r *Router) CheckHealth(component string) (*HealthReport, error) {
+	r.lock.RLock()
+	names := make([]string, 0, len(r.healthCheckers))
+	for name := range r.healthCheckers {
+		if component == "" || component == name {
+			names = append(names, name)
+		}
+	}
+	checkers := make([]HealthChecker, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		checkers = append(checkers, r.healthCheckers[name])
+	}
+	r.lock.RUnlock()
+
+	if component != "" && len(names) == 0 {
+		return nil, fmt.Errorf("组件[%s]未注册健康检查", component)
+	}
+
+	report := &HealthReport{
+		Status:     HealthStatusServing,
+		Draining:   r.IsDraining(),
+		Components: make(map[string]*ComponentHealth, len(names)),
+	}
+
+	for i, name := range names {
+		status, msg := runHealthChecker(checkers[i])
+		report.Components[name] = &ComponentHealth{
+			Status:  status,
+			Message: msg,
+		}
+		if status == HealthStatusNotServing || (status == HealthStatusDegraded && report.Status == HealthStatusServing) {
+			report.Status = status
+		}
+	}
+
+	if report.Draining {
+		report.Status = HealthStatusNotServing
+	}
+	return report, nil
+}
+
+func runHealthChecker(checker HealthChecker) (status HealthStatus, msg string) {
+	defer func() {
+		if e := recover(); e != nil {
+			status = HealthStatusNotServing
+			msg = fmt.Sprintf("健康检查异常: %v", e)
+		}
+	}()
+	return checker()
+}
+
+func pingHandler(stream *transportstream.Stream, quicStream quic.Stream) (ExchangeData, error) {
+	return stream.ReceiveMsg()
+}
+
+func healthHandler(stream *transportstream.Stream, quicStream quic.Stream) (ExchangeData, error) {
+	data, err := stream.ReceiveMsg()
+	if err != nil {
+		return nil, err
+	}
+
+	var component string
+	if len(data) > 0 {
+		if err = ExchangeData(data).UnmarshalJson(&component); err != nil {
+			return nil, err
+		}
+	}
+
+	router := RequestOf(quicStream).router
+	if router == nil {
+		router = DefaultRouter
+	}
+
+	report, err := router.CheckHealth(component)
+	if err != nil {
+		return nil, err
+	}
+	return NewExchangeDataByJson(report)
+}
+
+// MeasureLatency 向对端发送 Ping 命令并返回往返耗时
+func MeasureLatency(stream *transportstream.Stream) (time.Duration, error) {
+	start := time.Now()
+	if _, err := Ping.ExchangeWithData(start.UnixNano(), stream); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// RemoteHealth 查询对端的健康状态, component 为空时返回所有组件的状态
+func RemoteHealth(component string, stream *transportstream.Stream) (*HealthReport, error) {
+	var data any
+	if component != "" {
+		data = component
+	}
+
+	res, err := Health.ExchangeWithData(data, stream)
+	if err != nil {
+		return nil, err
+	}
+
+	var report *HealthReport
+	if err = res.UnmarshalJson(&report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// MarshalJSON 将状态序列化为可读的字符串
+func (s HealthStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON 从可读的字符串反序列化状态
+func (s *HealthStatus) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	switch str {
+	case "SERVING":
+		*s = HealthStatusServing
+	case "NOT_SERVING":
+		*s = HealthStatusNotServing
+	case "DEGRADED":
+		*s = HealthStatusDegraded
+	default:
+		return fmt.Errorf("未知的健康状态: %s", str)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package cmd_test
+
+import (
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/teamManagement/common/cmd"
+	"github.com/teamManagement/common/cmdtest"
+	"github.com/teamManagement/common/errors"
+	"testing"
+)
+
+func TestPing(t *testing.T) {
+	a := assert.New(t)
+
+	res, err := cmd.Ping.ExchangeWithData("hello", routePipe(t))
+	a.NoError(err)
+	var msg string
+	a.NoError(res.UnmarshalJson(&msg))
+	a.Equal("hello", msg)
+
+	latency, err := cmd.MeasureLatency(routePipe(t))
+	a.NoError(err)
+	a.True(latency > 0)
+}
+
+func TestHealth(t *testing.T) {
+	a := assert.New(t)
+
+	status := map[string]cmd.HealthStatus{"db": cmd.HealthStatusServing, "cache": cmd.HealthStatusServing}
+	for _, name := range []string{"db", "cache"} {
+		name := name
+		cmd.RegisterHealthChecker(name, func() (cmd.HealthStatus, string) {
+			return status[name], name + " status"
+		})
+		defer cmd.UnregisterHealthChecker(name)
+	}
+
+	report, err := cmd.RemoteHealth("", routePipe(t))
+	a.NoError(err)
+	a.Equal(cmd.HealthStatusServing, report.Status)
+	a.False(report.Draining)
+	if a.Len(report.Components, 2) {
+		a.Equal("db status", report.Components["db"].Message)
+	}
+
+	// 降级及不可用按严重程度汇总
+	status["cache"] = cmd.HealthStatusDegraded
+	report, err = cmd.RemoteHealth("", routePipe(t))
+	a.NoError(err)
+	a.Equal(cmd.HealthStatusDegraded, report.Status)
+
+	status["db"] = cmd.HealthStatusNotServing
+	report, err = cmd.RemoteHealth("", routePipe(t))
+	a.NoError(err)
+	a.Equal(cmd.HealthStatusNotServing, report.Status)
+
+	report, err = cmd.RemoteHealth("cache", routePipe(t))
+	a.NoError(err)
+	a.Equal(cmd.HealthStatusDegraded, report.Status)
+	a.Len(report.Components, 1)
+
+	_, err = cmd.RemoteHealth("unknown", routePipe(t))
+	a.Error(err)
+
+	cmd.RegisterHealthChecker("panic", func() (cmd.HealthStatus, string) {
+		panic("boom")
+	})
+	defer cmd.UnregisterHealthChecker("panic")
+	report, err = cmd.CheckHealth("panic")
+	a.NoError(err)
+	a.Equal(cmd.HealthStatusNotServing, report.Status)
+	a.Contains(report.Components["panic"].Message, "boom")
+}
+
+func TestDraining(t *testing.T) {
+	a := assert.New(t)
+
+	cmd.Name("/health/drain").Registry(func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		return stream.ReceiveMsg()
+	})
+
+	cmd.SetDraining(true)
+	defer cmd.SetDraining(false)
+	a.True(cmd.IsDraining())
+
+	_, err := cmd.Name("/health/drain").Exchange(routePipe(t))
+	a.True(errors.ErrCodeServerDraining.Equal(err))
+
+	// 内置命令不受排空模式影响
+	report, err := cmd.RemoteHealth("", routePipe(t))
+	a.NoError(err)
+	a.True(report.Draining)
+	a.Equal(cmd.HealthStatusNotServing, report.Status)
+
+	cmd.SetDraining(false)
+	_, err = cmd.Name("/health/drain").Exchange(routePipe(t))
+	a.NoError(err)
+}
+
+func TestRouterHealth(t *testing.T) {
+	a := assert.New(t)
+
+	router := cmd.NewRouter()
+	router.Handle("/team/list", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		return stream.ReceiveMsg()
+	})
+	router.RegisterHealthChecker("db", func() (cmd.HealthStatus, string) {
+		return cmd.HealthStatusDegraded, "slow"
+	})
+
+	var report *cmd.HealthReport
+	cmdtest.MustCall(t, router, cmd.Health, nil, &report)
+	a.Equal(cmd.HealthStatusDegraded, report.Status)
+	a.Len(report.Components, 1)
+
+	// 健康检查及排空模式仅对所属的路由表生效
+	router.SetDraining(true)
+	a.True(router.IsDraining())
+	a.False(cmd.IsDraining())
+	_, err := cmdtest.Call(t, router, "/team/list", nil)
+	a.True(errors.ErrCodeServerDraining.Equal(err))
+
+	report, err = cmd.CheckHealth("")
+	a.NoError(err)
+	a.False(report.Draining)
+	a.Empty(report.Components)
+
+	router.SetDraining(false)
+	_, err = cmdtest.Call(t, router, "/team/list", nil)
+	a.NoError(err)
+}
@@ -0,0 +1,150 @@
+package cmd_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/teamManagement/common/cmd"
+	"github.com/teamManagement/common/cmdtest"
+	"math/big"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// serveOnce 在内存中建立一条流并交由 router 处理, 返回客户端的传输流
+func serveOnce(router *cmd.Router) *transportstream.Stream {
+	client, server := cmdtest.Pipe()
+	go func() {
+		_ = router.ServeStream(server)
+	}()
+	return client.TransportStream()
+}
+
+// loginHandler 将客户端发送的用户名设置为会话的用户
+func loginHandler(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+	data, err := stream.ReceiveMsg()
+	if err != nil {
+		return nil, err
+	}
+	var user string
+	if err = cmd.ExchangeData(data).UnmarshalJson(&user); err != nil {
+		return nil, err
+	}
+	cmd.RequestOf(quicStream).Session.SetUser(user)
+	return nil, nil
+}
+
+// startQuicServer 在本地随机端口上启动使用自签名证书的QUIC服务并交由 router 处理, 返回监听地址
+func startQuicServer(t *testing.T, router *cmd.Router) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		NextProtos:   []string{"teamManagement"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = router.ServeConn(conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// dialConn 连接到 addr 上的QUIC服务, 测试结束时关闭连接
+func dialConn(t *testing.T, addr string) quic.Connection {
+	conn, err := quic.DialAddr(addr, &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"teamManagement"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.CloseWithError(0, "")
+	})
+	return conn
+}
+
+// mustPort 解析地址中的端口号
+func mustPort(t *testing.T, addr string) int {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// exchangeOn 在连接上打开新的流执行一次命令交换
+func exchangeOn(conn quic.Connection, fn func(stream *transportstream.Stream) error) error {
+	quicStream, err := conn.OpenStreamSync(context.Background())
+	if err != nil {
+		return err
+	}
+	defer quicStream.Close()
+	return fn(transportstream.NewStream(bufio.NewReadWriter(bufio.NewReader(quicStream), bufio.NewWriter(quicStream))))
+}
+
+// routePipe 建立一条本地TCP连接并交由 cmd.Route 处理, 返回客户端的传输流
+func routePipe(t *testing.T) *transportstream.Stream {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	go func() {
+		defer server.Close()
+		_ = cmd.Route(transportstream.NewStream(bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server))), nil)
+	}()
+	return transportstream.NewStream(bufio.NewReadWriter(bufio.NewReader(client), bufio.NewWriter(client)))
+}
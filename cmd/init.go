@@ -1,14 +1,17 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	transportstream "github.com/go-base-lib/transport-stream"
 	"github.com/gogo/protobuf/proto"
 	"github.com/lucas-clemente/quic-go"
+	"github.com/teamManagement/common/codec"
+	"github.com/teamManagement/common/compress"
 	"github.com/teamManagement/common/errors"
-	"io"
 	"strings"
+	"sync"
 )
 
 type ExchangeData []byte
@@ -62,9 +65,22 @@ func NewExchangeDataByProtoMust(data proto.Message) ExchangeData {
 
 type Handler func(stream *transportstream.Stream, quicStream quic.Stream) (ExchangeData, error)
 
-var cmdMap = map[Name]Handler{}
-
+// Route 使用 DefaultRouter 处理流上的一次命令交换
 func Route(stream *transportstream.Stream, quicStream quic.Stream) error {
+	return DefaultRouter.Route(stream, quicStream)
+}
+
+func (r *Router) route(stream *transportstream.Stream, quicStream quic.Stream, session *Session, transport *streamTransport) error {
+	var auditing *auditEntry
+	fail := func(errInfo *transportstream.ErrInfo) {
+		auditing.fail(errInfo)
+		_ = stream.WriteError(errInfo)
+	}
+
+	// 排空之后再写入审计记录, 使摘要包含客户端发送的所有数据
+	defer func() {
+		auditing.finish()
+	}()
 	sendEndOk := false
 	defer func() {
 		if sendEndOk {
@@ -72,7 +88,7 @@ func Route(stream *transportstream.Stream, quicStream quic.Stream) error {
 		}
 		_ = stream.WriteEndMsg()
 		for {
-			if _, err := stream.ReceiveMsg(); err == transportstream.StreamIsEnd || err == io.EOF || strings.Contains(err.Error(), "connection reset by peer") {
+			if _, err := stream.ReceiveMsg(); isStreamFinished(err) {
 				return
 			}
 		}
@@ -87,36 +103,134 @@ func Route(stream *transportstream.Stream, quicStream quic.Stream) error {
 			case error:
 				errMsg = r.Error()
 			}
-			_ = stream.WriteError(errors.ErrCodeUnknown.Newf("未知的指令处理异常: %s", errMsg))
+			fail(errors.ErrCodeUnknown.Newf("未知的指令处理异常: %s", errMsg))
 		}
 	}()
 
 	cmdBytes, err := stream.ReceiveMsg()
 	if err != nil {
+		if transport.isExceeded() {
+			_ = stream.WriteError(errors.ErrCodePayloadTooLarge.Newf("命令码长度超出限制: %d 字节", maxCommandSize))
+			return err
+		}
 		_ = stream.WriteError(errors.ErrCodeReadCommand.New("读取命令码失败: " + err.Error()))
 		return err
 	}
 
-	cmdName := Name(cmdBytes)
-	cmdHandle, ok := cmdMap[cmdName]
+	cmdName, header, err := decodeCommand(cmdBytes)
+	if err != nil {
+		_ = stream.WriteError(errors.ErrCodeReadCommand.New(err.Error()))
+		return nil
+	}
+
+	parent := context.Background()
+	if transport != nil && transport.ctx != nil {
+		parent = transport.ctx
+	}
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	request := &Request{
+		Name:           cmdName,
+		Pattern:        cmdName,
+		Params:         map[string]string{},
+		Session:        session,
+		Header:         header,
+		ResponseHeader: Header{},
+		ctx:            ctx,
+		router:         r,
+		nested:         transport != nil && transport.nested,
+	}
+	auditing = r.startAudit(request, transport)
+	defer session.track(cmdName)()
+
+	cmdHandle, ok := reservedCmdMap[cmdName]
 	if !ok {
-		_ = stream.WriteError(errors.ErrCodeCommandUndefined.Newf("命令[%s]未被识别", cmdName))
+		if r.IsDraining() {
+			fail(errors.ErrCodeServerDraining.Newf("服务器正在下线, 拒绝执行命令[%s]", cmdName))
+			return nil
+		}
+
+		if entry, params, matched := r.match(cmdName); matched {
+			request.Pattern = entry.pattern
+			request.Params = params
+			cmdHandle = r.handler(entry)
+		} else if notFound, ok := r.notFoundHandler(); ok {
+			request.Pattern = ""
+			cmdHandle = r.handler(&routeEntry{handle: notFound})
+		} else {
+			fail(errors.ErrCodeCommandUndefined.Newf("命令[%s]未被识别", cmdName))
+			return nil
+		}
+		r.checkDeprecation(request)
+	}
+
+	contentType := header.Get(HeaderContentType)
+	if request.codec, ok = codec.Get(contentType); !ok {
+		fail(errors.ErrCodeUnsupportedCodec.Newf("不支持的编解码器: %s", contentType))
 		return nil
 	}
+	if contentType != "" {
+		request.ResponseHeader.Set(HeaderContentType, contentType)
+	}
+
+	if errInfo := r.checkRateLimit(request); errInfo != nil {
+		fail(errInfo)
+		return nil
+	}
+
+	payloadSize := r.maxPayloadSize(request.limitKey())
+	transport.setLimit(payloadSize)
+
+	release, errInfo := r.acquireBulkheads(request)
+	if errInfo != nil {
+		fail(errInfo)
+		return nil
+	}
+
+	// 仅 ServeConn 构建的传输层支持透明压缩
+	var compressor compress.Compressor
+	if transport != nil {
+		if compressor = negotiateCompressor(header.Get(HeaderAcceptEncoding)); compressor != nil {
+			request.ResponseHeader.Set(HeaderContentEncoding, compressor.Name())
+			transport.reader.enable(compressor)
+		}
+	}
 
-	if err = stream.WriteMsg(nil, transportstream.MsgFlagSuccess); err != nil {
+	if err = writeAck(stream, request.ResponseHeader); err != nil {
+		release()
+		auditing.fail(errors.ErrCodeUnknown.New("发送命令确认消息失败: " + err.Error()))
 		return err
 	}
 
-	if nextData, err := cmdHandle(stream, quicStream); err != nil {
+	if compressor != nil {
+		transport.writer.enable(compressor)
+	}
+
+	// 仅 ServeConn 构建的传输层支持取消
+	if header.Get(HeaderCancellable) == "true" {
+		transport.watchCancel(cancel)
+	}
+	if header.Get(HeaderAcceptProgress) == "true" {
+		request.progressStream = stream
+	}
+
+	nextData, err := callHandler(cmdHandle, stream, &requestStream{Stream: quicStream, request: request}, release)
+	request.stopProgress()
+	if err != nil {
 		if err == transportstream.StreamIsEnd {
 			return nil
 		}
+		if transport.isExceeded() {
+			err = errors.ErrCodePayloadTooLarge.Newf("命令[%s]的数据大小超出限制: %d 字节", cmdName, payloadSize)
+		} else if _, isErrInfo := err.(*transportstream.ErrInfo); !isErrInfo && request.cancelled() {
+			err = errors.ErrCodeCancelled.Newf("命令[%s]已被客户端取消: %s", cmdName, err.Error())
+		}
 		switch e := err.(type) {
 		case *transportstream.ErrInfo:
-			_ = stream.WriteError(e)
+			fail(e)
 		default:
-			_ = stream.WriteError(errors.ErrCodeUnknown.New(err.Error()))
+			fail(errors.ErrCodeUnknown.New(err.Error()))
 		}
 		return nil
 	} else {
@@ -126,7 +240,7 @@ func Route(stream *transportstream.Stream, quicStream quic.Stream) error {
 		sendEndOk = true
 
 		for {
-			if _, err = stream.ReceiveMsg(); err == transportstream.StreamIsEnd {
+			if _, err = stream.ReceiveMsg(); isStreamFinished(err) {
 				return nil
 			}
 		}
@@ -135,6 +249,29 @@ func Route(stream *transportstream.Stream, quicStream quic.Stream) error {
 
 }
 
+// isStreamFinished 判断读取消息时的异常是否意味着流已结束, 除对端发送的异常消息之外的异常均无法继续读取
+func isStreamFinished(err error) bool {
+	if err == nil {
+		return false
+	}
+	_, isErrInfo := transportstream.ErrConvert(err)
+	return !isErrInfo
+}
+
+// writeAck 发送命令确认消息, 响应头为空时发送空消息, 以兼容不支持头信息的对端
+func writeAck(stream *transportstream.Stream, header Header) error {
+	if len(header) == 0 {
+		return stream.WriteMsg(nil, transportstream.MsgFlagSuccess)
+	}
+	return stream.WriteJsonMsg(header)
+}
+
+// callHandler 执行命令处理函数, 结束之后调用 release 释放执行名额
+func callHandler(handle Handler, stream *transportstream.Stream, quicStream quic.Stream, release func()) (ExchangeData, error) {
+	defer release()
+	return handle(stream, quicStream)
+}
+
 type RWStreamInterface interface {
 	WriteStreamInterface
 	ReadLine() ([]byte, bool, error)
@@ -155,6 +292,20 @@ type ExchangeOption struct {
 	StreamErrHandle func(exchangeData ExchangeData, err error) (breakStream bool, targetErr *transportstream.ErrInfo)
 	// Data 要发送的数据
 	Data any
+	// Codec 发送数据使用的编解码器, 为空时使用 SetCodec 为命令设置的编解码器, 均未设置时使用JSON
+	Codec string
+	// Header 附加的请求头
+	Header Header
+	// ResponseHeader 交换完成后保存服务端返回的响应头
+	ResponseHeader Header
+	// Compress 是否与服务端协商压缩, 协商成功之后大于阈值的数据将自动压缩,
+	// 此时 StreamHandle 中不可直接向流中写入数据
+	Compress bool
+	// Context 不为nil时, 在其被取消后向服务端发送取消消息, 服务端将取消 Handler 的 context 并返回 errors.ErrCodeCancelled 异常,
+	// 此时 StreamHandle 中不可直接向流中写入数据
+	Context context.Context
+	// Progress 不为nil时接收服务端通过 ReportProgress 报告的处理进度, 进度消息不会交给 StreamHandle 及 StreamErrHandle
+	Progress func(progress Progress)
 }
 
 type Name string
@@ -165,14 +316,26 @@ func emptyStreamHandler(exchangeData ExchangeData, stream *transportstream.Strea
 
 // SendCommand 发送一条命令到对端
 func (c Name) SendCommand(stream *transportstream.Stream) error {
-	if err := stream.WriteMsg([]byte(c), transportstream.MsgFlagSuccess); err != nil {
-		return err
+	_, err := c.SendCommandWithHeader(stream, nil)
+	return err
+}
+
+// SendCommandWithHeader 携带请求头发送一条命令到对端, 返回对端的响应头
+func (c Name) SendCommandWithHeader(stream *transportstream.Stream, header Header) (Header, error) {
+	cmdBytes, err := encodeCommand(c, header)
+	if err != nil {
+		return nil, err
 	}
 
-	if _, err := stream.ReceiveMsg(); err != nil {
-		return err
+	if err = stream.WriteMsg(cmdBytes, transportstream.MsgFlagSuccess); err != nil {
+		return nil, err
 	}
-	return nil
+
+	ack, err := stream.ReceiveMsg()
+	if err != nil {
+		return nil, err
+	}
+	return decodeHeader(ack)
 }
 
 // ExchangeWithOption 交换数据到对端，数据为一来一回
@@ -183,12 +346,56 @@ func (c Name) ExchangeWithOption(stream *transportstream.Stream, option *Exchang
 		option.StreamHandle = emptyStreamHandler
 	}
 
-	if err := c.SendCommand(stream); err != nil {
+	codecName := option.Codec
+	if codecName == "" {
+		codecName = commandCodec(c)
+	}
+
+	header := Header{}
+	for k, v := range option.Header {
+		header.Set(k, v)
+	}
+	if codecName != "" && codecName != codec.JSON {
+		header.Set(HeaderContentType, codecName)
+	}
+	if option.Compress {
+		header.Set(HeaderAcceptEncoding, strings.Join(compress.Preferred(), ","))
+	}
+	if option.Context != nil {
+		if err := option.Context.Err(); err != nil {
+			return nil, errors.ErrCodeCancelled.New("客户端已取消: " + err.Error())
+		}
+		header.Set(HeaderCancellable, "true")
+	}
+	if option.Progress != nil {
+		header.Set(HeaderAcceptProgress, "true")
+	}
+
+	responseHeader, err := c.SendCommandWithHeader(stream, header)
+	if err != nil {
 		return nil, err
 	}
+	option.ResponseHeader = responseHeader
+	notifyDeprecation(c, responseHeader)
+
+	var compressor compress.Compressor
+	if contentEncoding := responseHeader.Get(HeaderContentEncoding); contentEncoding != "" {
+		var ok bool
+		if compressor, ok = compress.Get(contentEncoding); !ok {
+			return nil, fmt.Errorf("对端选择了不支持的压缩算法: %s", contentEncoding)
+		}
+	}
+	payloadSize := DefaultRouter.maxPayloadSize(c)
 
 	if option.Data != nil {
-		if err := stream.WriteJsonMsg(option.Data); err != nil {
+		var data ExchangeData
+		if data, err = NewExchangeDataByCodec(codecName, option.Data); err != nil {
+			return nil, fmt.Errorf("序列化%s数据失败: %s", codecName, err.Error())
+		}
+		if data, err = encodePayload(compressor, data); err != nil {
+			return nil, err
+		}
+		if err = stream.WriteMsg(data, transportstream.MsgFlagSuccess); err != nil {
 			return nil, err
 		}
 	} else {
@@ -197,17 +404,39 @@ func (c Name) ExchangeWithOption(stream *transportstream.Stream, option *Exchang
 		}
 	}
 
+	var writeLock sync.Mutex
+	locked := func(fn func() error) error {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		return fn()
+	}
+	if option.Context != nil {
+		defer watchCancel(option.Context, stream, &writeLock)()
+	}
+
 	for {
 		msg, err := stream.ReceiveMsg()
+		if err == nil || err == transportstream.StreamIsEnd {
+			var decodeErr error
+			if msg, decodeErr = decodePayload(compressor, msg, payloadSize); decodeErr != nil {
+				return nil, decodeErr
+			}
+		}
+
 		if err == transportstream.StreamIsEnd {
 			return msg, nil
 		}
 
+		if progress, ok := progressOf(err); ok && option.Progress != nil {
+			option.Progress(progress)
+			continue
+		}
+
 		if err != nil {
 			if err != transportstream.StreamIsEnd && option.StreamErrHandle != nil {
 				breakStream, e := option.StreamErrHandle(msg, err)
 				if e != nil {
-					_ = stream.WriteError(e)
+					_ = locked(func() error { return stream.WriteError(e) })
 				}
 
 				if breakStream {
@@ -216,7 +445,7 @@ func (c Name) ExchangeWithOption(stream *transportstream.Stream, option *Exchang
 				continue
 			}
 			for {
-				if _, e := stream.ReceiveMsg(); e == transportstream.StreamIsEnd || e == io.EOF || strings.Contains(err.Error(), "connection reset by peer") {
+				if _, e := stream.ReceiveMsg(); isStreamFinished(e) {
 					break
 				}
 			}
@@ -224,8 +453,15 @@ func (c Name) ExchangeWithOption(stream *transportstream.Stream, option *Exchang
 		}
 
 		nextData, err := option.StreamHandle(msg, stream)
+		if err == nil || err == transportstream.StreamIsEnd {
+			var encodeErr error
+			if nextData, encodeErr = encodePayload(compressor, nextData); encodeErr != nil {
+				return nil, encodeErr
+			}
+		}
+
 		if err == transportstream.StreamIsEnd {
-			if err = stream.WriteEndMsgWithData(nextData); err != nil {
+			if err = locked(func() error { return stream.WriteEndMsgWithData(nextData) }); err != nil {
 				return nil, fmt.Errorf("接收结束消息失败: %s", err.Error())
 			}
 			return nil, nil
@@ -234,20 +470,20 @@ func (c Name) ExchangeWithOption(stream *transportstream.Stream, option *Exchang
 		if err != nil {
 			switch e := err.(type) {
 			case *transportstream.ErrInfo:
-				if err = stream.WriteError(e); err != nil {
+				if err = locked(func() error { return stream.WriteError(e) }); err != nil {
 					return nil, err
 				}
 			default:
 				_err := errors.ErrCodeUnknown.New(err.Error())
 				_err.RawData = nextData
-				if err = stream.WriteError(_err); err != nil {
+				if err = locked(func() error { return stream.WriteError(_err) }); err != nil {
 					return nil, err
 				}
 			}
 			continue
 		}
 		if nextData != nil {
-			if err = stream.WriteMsg(nextData, transportstream.MsgFlagSuccess); err != nil {
+			if err = locked(func() error { return stream.WriteMsg(nextData, transportstream.MsgFlagSuccess) }); err != nil {
 				return nil, err
 			}
 		}
@@ -266,7 +502,7 @@ func (c Name) Exchange(stream *transportstream.Stream) (ExchangeData, error) {
 }
 
 func (c Name) Registry(handle Handler) {
-	cmdMap[c] = handle
+	DefaultRouter.Handle(c, handle)
 }
 
 const (
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/teamManagement/common/errors"
+	"sort"
+	"time"
+)
+
+// Commands 内置命令, 列出路由表中注册的所有命令, 需要通过 Router.EnableIntrospection 开启
+const Commands Name = "/_commands"
+
+func init() {
+	reservedCmdMap[Commands] = commandsHandler
+}
+
+// CommandInfo 命令的描述信息
+type CommandInfo struct {
+	// Name 命令名称, 参数化命令为注册时的名称
+	Name Name `json:"name"`
+	// Builtin 是否为内置命令
+	Builtin bool `json:"builtin,omitempty"`
+	// AliasOf 别名对应的命令
+	AliasOf Name `json:"aliasOf,omitempty"`
+	// Deprecated 是否已废弃
+	Deprecated bool `json:"deprecated,omitempty"`
+	// Sunset 已废弃命令计划下线的时间
+	Sunset *time.Time `json:"sunset,omitempty"`
+	// Replacement 已废弃命令的替代命令
+	Replacement Name `json:"replacement,omitempty"`
+}
+
+// EnableIntrospection 是否允许客户端通过 Commands 命令获取路由表, 默认关闭.
+// Commands 属于内置命令不经过中间件, 仅应在可信的环境中开启
+func (r *Router) EnableIntrospection(enable bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.introspection = enable
+}
+
+// Commands 路由表中注册的所有命令、别名及内置命令, 按名称排序
+func (r *Router) Commands() []*CommandInfo {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	var commands []*CommandInfo
+	for name := range r.routes.handlers {
+		commands = append(commands, &CommandInfo{Name: name})
+	}
+	r.routes.tree.walk(func(entry *routeEntry) {
+		commands = append(commands, &CommandInfo{Name: entry.pattern})
+	})
+	for alias, target := range r.aliases {
+		commands = append(commands, &CommandInfo{Name: alias, AliasOf: target})
+	}
+	for name := range reservedCmdMap {
+		commands = append(commands, &CommandInfo{Name: name, Builtin: true})
+	}
+
+	for _, info := range commands {
+		deprecation, ok := r.deprecations[info.Name]
+		if !ok {
+			continue
+		}
+		info.Deprecated = true
+		info.Replacement = deprecation.Replacement
+		if !deprecation.Sunset.IsZero() {
+			sunset := deprecation.Sunset
+			info.Sunset = &sunset
+		}
+	}
+
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Name < commands[j].Name
+	})
+	return commands
+}
+
+// walk 遍历路由树中注册的所有命令
+func (n *routeNode) walk(fn func(entry *routeEntry)) {
+	if n.entry != nil {
+		fn(n.entry)
+	}
+	if n.wildcard != nil {
+		fn(n.wildcard)
+	}
+	for _, child := range n.children {
+		child.walk(fn)
+	}
+	if n.param != nil {
+		n.param.walk(fn)
+	}
+}
+
+func commandsHandler(stream *transportstream.Stream, quicStream quic.Stream) (ExchangeData, error) {
+	router, err := introspectionRouter(stream, quicStream, Commands)
+	if err != nil {
+		return nil, err
+	}
+	return NewExchangeDataByJson(router.Commands())
+}
+
+// introspectionRouter 读取客户端的数据并获取处理命令的路由表, 路由表未开启内省时视为命令不存在
+func introspectionRouter(stream *transportstream.Stream, quicStream quic.Stream, name Name) (*Router, error) {
+	if _, err := stream.ReceiveMsg(); err != nil {
+		return nil, err
+	}
+
+	router := RequestOf(quicStream).router
+	if router == nil {
+		router = DefaultRouter
+	}
+
+	router.lock.RLock()
+	enabled := router.introspection
+	router.lock.RUnlock()
+	if !enabled {
+		return nil, errors.ErrCodeCommandUndefined.Newf("命令[%s]未被识别", name)
+	}
+	return router, nil
+}
+
+// ListCommands 获取对端路由表中注册的所有命令
+func ListCommands(stream *transportstream.Stream) ([]*CommandInfo, error) {
+	res, err := Commands.Exchange(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	var commands []*CommandInfo
+	if err = res.UnmarshalJson(&commands); err != nil {
+		return nil, err
+	}
+	return commands, nil
+}
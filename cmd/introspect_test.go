@@ -0,0 +1,47 @@
+package cmd_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/teamManagement/common/cmd"
+	"github.com/teamManagement/common/cmdtest"
+	"github.com/teamManagement/common/errors"
+	"testing"
+)
+
+func TestListCommands(t *testing.T) {
+	a := assert.New(t)
+
+	router := cmd.NewRouter()
+	router.Handle("/team/list", patternHandler)
+	router.Handle("/team/:teamId/member/list", patternHandler)
+	router.Alias("/team/all", "/team/list")
+	router.Deprecate("/team/all", &cmd.Deprecation{})
+
+	_, err := cmd.ListCommands(serveOnce(router))
+	a.True(errors.ErrCodeCommandUndefined.Equal(err))
+
+	router.EnableIntrospection(true)
+	commands, err := cmd.ListCommands(serveOnce(router))
+	if !a.NoError(err) {
+		return
+	}
+
+	infos := map[cmd.Name]*cmd.CommandInfo{}
+	for _, info := range commands {
+		infos[info.Name] = info
+	}
+	a.Contains(infos, cmd.Name("/team/list"))
+	a.Contains(infos, cmd.Name("/team/:teamId/member/list"))
+	if a.Contains(infos, cmd.Name("/team/all")) {
+		a.Equal(cmd.Name("/team/list"), infos["/team/all"].AliasOf)
+		a.True(infos["/team/all"].Deprecated)
+		a.Equal(cmd.Name("/team/list"), infos["/team/all"].Replacement)
+	}
+	if a.Contains(infos, cmd.Ping) {
+		a.True(infos[cmd.Ping].Builtin)
+	}
+
+	var res []*cmd.CommandInfo
+	cmdtest.MustCall(t, router, cmd.Commands, nil, &res)
+	a.Len(res, len(commands))
+}
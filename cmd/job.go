@@ -0,0 +1,597 @@
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/teamManagement/common/errors"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	// JobStatusCmd 内置命令, 查询异步任务的状态
+	JobStatusCmd Name = "/_job/status"
+	// JobProgressCmd 内置命令, 持续推送异步任务的进度直到任务结束
+	JobProgressCmd Name = "/_job/progress"
+	// JobResultCmd 内置命令, 获取异步任务的执行结果
+	JobResultCmd Name = "/_job/result"
+	// JobCancelCmd 内置命令, 取消异步任务
+	JobCancelCmd Name = "/_job/cancel"
+)
+
+func init() {
+	reservedCmdMap[JobStatusCmd] = jobStatusHandler
+	reservedCmdMap[JobProgressCmd] = jobProgressHandler
+	reservedCmdMap[JobResultCmd] = jobResultHandler
+	reservedCmdMap[JobCancelCmd] = jobCancelHandler
+}
+
+// JobStatus 异步任务状态
+type JobStatus uint8
+
+const (
+	// JobStatusPending 排队中
+	JobStatusPending JobStatus = iota
+	// JobStatusRunning 执行中
+	JobStatusRunning
+	// JobStatusSucceeded 执行成功
+	JobStatusSucceeded
+	// JobStatusFailed 执行失败
+	JobStatusFailed
+	// JobStatusCancelled 已取消
+	JobStatusCancelled
+)
+
+var jobStatusNames = []string{"PENDING", "RUNNING", "SUCCEEDED", "FAILED", "CANCELLED"}
+
+func (s JobStatus) String() string {
+	if int(s) < len(jobStatusNames) {
+		return jobStatusNames[s]
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", uint8(s))
+}
+
+// Finished 任务是否已结束
+func (s JobStatus) Finished() bool {
+	return s >= JobStatusSucceeded
+}
+
+// MarshalJSON 将状态序列化为可读的字符串
+func (s JobStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON 从可读的字符串反序列化状态
+func (s *JobStatus) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	for i, name := range jobStatusNames {
+		if name == str {
+			*s = JobStatus(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("未知的任务状态: %s", str)
+}
+
+// JobProgress 异步任务的进度
+type JobProgress struct {
+	// Percent 完成百分比, 0-100
+	Percent float64 `json:"percent"`
+	// Stage 当前阶段
+	Stage string `json:"stage,omitempty"`
+	// Message 进度描述
+	Message string `json:"message,omitempty"`
+}
+
+// JobInfo 异步任务的状态快照
+type JobInfo struct {
+	ID         string                   `json:"id"`
+	Name       Name                     `json:"name"`
+	Status     JobStatus                `json:"status"`
+	Progress   JobProgress              `json:"progress"`
+	CreatedAt  time.Time                `json:"createdAt"`
+	StartedAt  *time.Time               `json:"startedAt,omitempty"`
+	FinishedAt *time.Time               `json:"finishedAt,omitempty"`
+	Err        *transportstream.ErrInfo `json:"err,omitempty"`
+}
+
+// JobFunc 异步任务的执行函数, ctx 在任务被取消时取消
+type JobFunc func(ctx context.Context, job *Job) (ExchangeData, error)
+
+// Job 异步任务
+type Job struct {
+	id     string
+	name   Name
+	user   string
+	fn     JobFunc
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lock       sync.RWMutex
+	status     JobStatus
+	progress   JobProgress
+	createdAt  time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+	result     ExchangeData
+	err        *transportstream.ErrInfo
+	changed    chan struct{}
+}
+
+// ID 任务编号
+func (j *Job) ID() string {
+	return j.id
+}
+
+// SetProgress 更新任务进度, 正在监听进度的客户端将收到推送
+func (j *Job) SetProgress(percent float64, stage, message string) {
+	j.update(func() {
+		j.progress = JobProgress{
+			Percent: percent,
+			Stage:   stage,
+			Message: message,
+		}
+	})
+}
+
+// Info 获取任务的状态快照
+func (j *Job) Info() *JobInfo {
+	info, _ := j.watch()
+	return info
+}
+
+// watch 获取任务的状态快照及下一次状态变化的通知通道
+func (j *Job) watch() (*JobInfo, <-chan struct{}) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	info := &JobInfo{
+		ID:        j.id,
+		Name:      j.name,
+		Status:    j.status,
+		Progress:  j.progress,
+		CreatedAt: j.createdAt,
+		Err:       j.err,
+	}
+	if !j.startedAt.IsZero() {
+		startedAt := j.startedAt
+		info.StartedAt = &startedAt
+	}
+	if !j.finishedAt.IsZero() {
+		finishedAt := j.finishedAt
+		info.FinishedAt = &finishedAt
+	}
+	return info, j.changed
+}
+
+func (j *Job) update(fn func()) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	fn()
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+func (j *Job) finish(status JobStatus, result ExchangeData, err *transportstream.ErrInfo) {
+	j.update(func() {
+		if j.status.Finished() {
+			return
+		}
+		j.status = status
+		j.result = result
+		j.err = err
+		j.finishedAt = time.Now()
+		if status == JobStatusSucceeded {
+			j.progress.Percent = 100
+		}
+	})
+	j.cancel()
+}
+
+func (j *Job) run() {
+	j.update(func() {
+		if j.status == JobStatusPending {
+			j.status = JobStatusRunning
+			j.startedAt = time.Now()
+		}
+	})
+	if j.Info().Status != JobStatusRunning {
+		return
+	}
+
+	result, err := j.call()
+	switch {
+	case j.ctx.Err() != nil:
+		j.finish(JobStatusCancelled, nil, errors.ErrCodeCancelled.New("任务已被取消"))
+	case err != nil:
+		j.finish(JobStatusFailed, nil, errors.ErrorByErr(err))
+	default:
+		j.finish(JobStatusSucceeded, result, nil)
+	}
+}
+
+func (j *Job) call() (result ExchangeData, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("任务执行异常: %v", e)
+		}
+	}()
+	return j.fn(j.ctx, j)
+}
+
+// JobManager 异步任务管理器, 使用固定数量的工作协程执行任务
+type JobManager struct {
+	workers   int
+	queue     chan *Job
+	retention time.Duration
+	startOnce sync.Once
+
+	lock sync.RWMutex
+	jobs map[string]*Job
+}
+
+// DefaultJobManager 默认的任务管理器, 路由表未通过 Router.SetJobManager 设置任务管理器时,
+// SubmitJob 及内置的任务命令均使用该管理器
+var DefaultJobManager = NewJobManager(runtime.NumCPU(), 1024, time.Hour)
+
+// SetJobManager 设置路由表上 SubmitJob 及内置的任务命令使用的任务管理器, manager 为nil时使用 DefaultJobManager
+func (r *Router) SetJobManager(manager *JobManager) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.jobManager = manager
+}
+
+func (r *Router) currentJobManager() *JobManager {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.jobManager == nil {
+		return DefaultJobManager
+	}
+	return r.jobManager
+}
+
+// NewJobManager 创建任务管理器, queueSize 为排队任务的最大数量, retention 为任务结束之后保留的时间
+func NewJobManager(workers, queueSize int, retention time.Duration) *JobManager {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &JobManager{
+		workers:   workers,
+		queue:     make(chan *Job, queueSize),
+		retention: retention,
+		jobs:      map[string]*Job{},
+	}
+}
+
+func (m *JobManager) start() {
+	m.startOnce.Do(func() {
+		for i := 0; i < m.workers; i++ {
+			go func() {
+				for job := range m.queue {
+					job.run()
+				}
+			}()
+		}
+		if m.retention > 0 {
+			go m.sweepLoop()
+		}
+	})
+}
+
+// sweepLoop 定期清理已结束的任务, 任务结束之后最多在 2 倍保留时间内被清理
+func (m *JobManager) sweepLoop() {
+	ticker := time.NewTicker(m.retention)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.sweep()
+	}
+}
+
+// jobIdLength 任务编号的随机字节数
+const jobIdLength = 16
+
+// newJobId 生成随机的任务编号, 避免被其他用户猜测
+func newJobId() (string, error) {
+	id := make([]byte, jobIdLength)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("生成任务编号失败: %s", err.Error())
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// Submit 提交任务, 排队已满时返回 errors.ErrCodeServerBusy, user 为提交任务的用户, 仅该用户可以查询及取消任务,
+// user 为空时任务不属于任何用户, 仅能以空的 user 查询及取消
+func (m *JobManager) Submit(name Name, user string, fn JobFunc) (*Job, error) {
+	id, err := newJobId()
+	if err != nil {
+		return nil, err
+	}
+
+	m.start()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		id:        id,
+		name:      name,
+		user:      user,
+		fn:        fn,
+		ctx:       ctx,
+		cancel:    cancel,
+		status:    JobStatusPending,
+		createdAt: time.Now(),
+		changed:   make(chan struct{}),
+	}
+
+	m.lock.Lock()
+	m.jobs[job.id] = job
+	m.lock.Unlock()
+
+	select {
+	case m.queue <- job:
+		return job, nil
+	default:
+		m.lock.Lock()
+		delete(m.jobs, job.id)
+		m.lock.Unlock()
+		cancel()
+		return nil, errors.ErrCodeServerBusy.New("服务器繁忙, 异步任务排队数量已达上限")
+	}
+}
+
+// Get 获取任务, user 与提交任务的用户不一致或任务已超过保留时间时视为不存在
+func (m *JobManager) Get(id string, user string) (*Job, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	job, ok := m.jobs[id]
+	if !ok || job.user != user || m.expired(job) {
+		return nil, false
+	}
+	return job, true
+}
+
+// Cancel 取消任务, 排队中的任务将不再执行, 执行中的任务将取消其 context
+func (m *JobManager) Cancel(id string, user string) (*JobInfo, error) {
+	job, ok := m.Get(id, user)
+	if !ok {
+		return nil, errors.ErrCodeJobNotFound.Newf("任务[%s]不存在", id)
+	}
+
+	if job.Info().Status == JobStatusPending {
+		job.finish(JobStatusCancelled, nil, errors.ErrCodeCancelled.New("任务已被取消"))
+	} else {
+		job.cancel()
+	}
+	return job.Info(), nil
+}
+
+// sweep 清理结束时间超过保留时间的任务
+func (m *JobManager) sweep() {
+	if m.retention <= 0 {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for id, job := range m.jobs {
+		if m.expired(job) {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+// expired 任务是否已结束且超过保留时间
+func (m *JobManager) expired(job *Job) bool {
+	if m.retention <= 0 {
+		return false
+	}
+	info := job.Info()
+	return info.FinishedAt != nil && time.Since(*info.FinishedAt) > m.retention
+}
+
+// SubmitJob 在 Handler 中将耗时操作提交为异步任务, 返回值可直接作为 Handler 的返回值, 客户端将收到任务信息;
+// 任务属于会话的用户, 会话未认证时返回 errors.ErrCodeValidation, 不经过连接的交换(Route、ServeStream)没有会话,
+// 此时任务不属于任何用户, 同样不经过连接的交换均可查询
+func SubmitJob(quicStream quic.Stream, fn JobFunc) (ExchangeData, error) {
+	request := RequestOf(quicStream)
+	user, ok := jobOwner(request)
+	if !ok {
+		return nil, errors.ErrCodeValidation.Newf("未认证的用户不能提交异步任务[%s]", request.Name)
+	}
+
+	job, err := request.jobManager().Submit(request.Name, user, fn)
+	if err != nil {
+		return nil, err
+	}
+	return NewExchangeDataByJson(job.Info())
+}
+
+// jobOwner 获取请求对应的任务所属用户, 请求有会话但会话未认证时返回 false
+func jobOwner(request *Request) (string, bool) {
+	if request.Session == nil {
+		return "", true
+	}
+	user := sessionUser(request.Session)
+	return user, user != ""
+}
+
+func sessionUser(session *Session) string {
+	if session == nil {
+		return ""
+	}
+	return session.User()
+}
+
+// jobManager 获取请求所属路由表的任务管理器
+func (request *Request) jobManager() *JobManager {
+	router := request.router
+	if router == nil {
+		router = DefaultRouter
+	}
+	return router.currentJobManager()
+}
+
+// findJob 获取请求可以访问的任务
+func findJob(request *Request, id string) (*Job, error) {
+	if user, ok := jobOwner(request); ok {
+		if job, ok := request.jobManager().Get(id, user); ok {
+			return job, nil
+		}
+	}
+	return nil, errors.ErrCodeJobNotFound.Newf("任务[%s]不存在", id)
+}
+
+// receiveJob 读取客户端发送的任务编号并获取任务
+func receiveJob(stream *transportstream.Stream, quicStream quic.Stream) (*Job, error) {
+	data, err := stream.ReceiveMsg()
+	if err != nil {
+		return nil, err
+	}
+
+	var id string
+	if err = ExchangeData(data).UnmarshalJson(&id); err != nil {
+		return nil, errors.ErrCodeValidation.New(err.Error())
+	}
+
+	return findJob(RequestOf(quicStream), id)
+}
+
+func jobStatusHandler(stream *transportstream.Stream, quicStream quic.Stream) (ExchangeData, error) {
+	job, err := receiveJob(stream, quicStream)
+	if err != nil {
+		return nil, err
+	}
+	return NewExchangeDataByJson(job.Info())
+}
+
+func jobProgressHandler(stream *transportstream.Stream, quicStream quic.Stream) (ExchangeData, error) {
+	job, err := receiveJob(stream, quicStream)
+	if err != nil {
+		return nil, err
+	}
+
+	// 客户端断开或取消交换时停止推送, 不再等待任务结束
+	ctx := RequestOf(quicStream).Context()
+	for {
+		info, changed := job.watch()
+		if info.Status.Finished() {
+			return NewExchangeDataByJson(info)
+		}
+
+		if err = stream.WriteJsonMsg(info); err != nil {
+			return nil, err
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-quicStream.Context().Done():
+			return nil, transportstream.StreamIsEnd
+		}
+	}
+}
+
+func jobResultHandler(stream *transportstream.Stream, quicStream quic.Stream) (ExchangeData, error) {
+	job, err := receiveJob(stream, quicStream)
+	if err != nil {
+		return nil, err
+	}
+
+	job.lock.RLock()
+	defer job.lock.RUnlock()
+	switch {
+	case !job.status.Finished():
+		return nil, errors.ErrCodeJobNotFinished.Newf("任务[%s]尚未执行完成", job.id)
+	case job.err != nil:
+		return nil, job.err
+	default:
+		return job.result, nil
+	}
+}
+
+func jobCancelHandler(stream *transportstream.Stream, quicStream quic.Stream) (ExchangeData, error) {
+	data, err := stream.ReceiveMsg()
+	if err != nil {
+		return nil, err
+	}
+
+	var id string
+	if err = ExchangeData(data).UnmarshalJson(&id); err != nil {
+		return nil, errors.ErrCodeValidation.New(err.Error())
+	}
+
+	request := RequestOf(quicStream)
+	job, err := findJob(request, id)
+	if err != nil {
+		return nil, err
+	}
+	info, err := request.jobManager().Cancel(job.id, job.user)
+	if err != nil {
+		return nil, err
+	}
+	return NewExchangeDataByJson(info)
+}
+
+// JobStatusOf 查询异步任务的状态
+func JobStatusOf(id string, stream *transportstream.Stream) (*JobInfo, error) {
+	return exchangeJobInfo(JobStatusCmd, id, stream)
+}
+
+// CancelJob 取消异步任务
+func CancelJob(id string, stream *transportstream.Stream) (*JobInfo, error) {
+	return exchangeJobInfo(JobCancelCmd, id, stream)
+}
+
+// JobResult 获取异步任务的执行结果, 任务未完成时返回 errors.ErrCodeJobNotFinished
+func JobResult(id string, stream *transportstream.Stream) (ExchangeData, error) {
+	return JobResultCmd.ExchangeWithData(id, stream)
+}
+
+// WatchJob 持续接收异步任务的进度直到任务结束, 返回任务结束时的状态
+func WatchJob(id string, stream *transportstream.Stream, fn func(info *JobInfo)) (*JobInfo, error) {
+	res, err := JobProgressCmd.ExchangeWithOption(stream, &ExchangeOption{
+		Data: id,
+		StreamHandle: func(exchangeData ExchangeData, stream *transportstream.Stream) (ExchangeData, error) {
+			var info *JobInfo
+			if err := exchangeData.UnmarshalJson(&info); err != nil {
+				return nil, err
+			}
+			if fn != nil {
+				fn(info)
+			}
+			return nil, nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var info *JobInfo
+	if err = res.UnmarshalJson(&info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func exchangeJobInfo(name Name, id string, stream *transportstream.Stream) (*JobInfo, error) {
+	res, err := name.ExchangeWithData(id, stream)
+	if err != nil {
+		return nil, err
+	}
+
+	var info *JobInfo
+	if err = res.UnmarshalJson(&info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
@@ -0,0 +1,203 @@
+package cmd_test
+
+import (
+	"context"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/teamManagement/common/cmd"
+	"github.com/teamManagement/common/cmdtest"
+	"github.com/teamManagement/common/errors"
+	"testing"
+	"time"
+)
+
+func TestJob(t *testing.T) {
+	a := assert.New(t)
+
+	step := make(chan struct{})
+	router := cmd.NewRouter()
+	router.Handle(cmd.Login, loginHandler)
+	router.Handle("/export", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		if _, err := stream.ReceiveMsg(); err != nil {
+			return nil, err
+		}
+		return cmd.SubmitJob(quicStream, func(ctx context.Context, job *cmd.Job) (cmd.ExchangeData, error) {
+			for i := 1; i <= 2; i++ {
+				<-step
+				job.SetProgress(float64(i*50), "export", "")
+			}
+			return cmd.NewExchangeDataByJson("done")
+		})
+	})
+	addr := startQuicServer(t, router)
+
+	dial := func(user string) quic.Connection {
+		conn := dialConn(t, addr)
+		if user != "" {
+			a.NoError(exchangeOn(conn, func(stream *transportstream.Stream) error {
+				_, err := cmd.Login.ExchangeWithData(user, stream)
+				return err
+			}))
+		}
+		return conn
+	}
+	alice, bob, anonymous := dial("alice"), dial("bob"), dial("")
+
+	// 未认证的会话无法提交任务
+	a.True(errors.ErrCodeValidation.Equal(exchangeOn(anonymous, func(stream *transportstream.Stream) error {
+		_, err := cmd.Name("/export").Exchange(stream)
+		return err
+	})))
+
+	var info *cmd.JobInfo
+	a.NoError(exchangeOn(alice, func(stream *transportstream.Stream) error {
+		data, err := cmd.Name("/export").Exchange(stream)
+		if err != nil {
+			return err
+		}
+		return data.UnmarshalJson(&info)
+	}))
+	if !a.NotNil(info) {
+		return
+	}
+	a.Len(info.ID, 32)
+	a.Equal(cmd.Name("/export"), info.Name)
+
+	// 其他用户及未认证的会话视为任务不存在
+	for _, conn := range []quic.Connection{bob, anonymous} {
+		a.True(errors.ErrCodeJobNotFound.Equal(exchangeOn(conn, func(stream *transportstream.Stream) error {
+			_, err := cmd.JobStatusOf(info.ID, stream)
+			return err
+		})))
+	}
+
+	a.True(errors.ErrCodeJobNotFinished.Equal(exchangeOn(alice, func(stream *transportstream.Stream) error {
+		_, err := cmd.JobResult(info.ID, stream)
+		return err
+	})))
+
+	var progress []float64
+	var finished *cmd.JobInfo
+	a.NoError(exchangeOn(alice, func(stream *transportstream.Stream) (err error) {
+		finished, err = cmd.WatchJob(info.ID, stream, func(info *cmd.JobInfo) {
+			progress = append(progress, info.Progress.Percent)
+			if info.Status == cmd.JobStatusRunning {
+				step <- struct{}{}
+			}
+		})
+		return
+	}))
+	if !a.NotNil(finished) {
+		return
+	}
+	a.Equal(cmd.JobStatusSucceeded, finished.Status)
+	a.NotEmpty(progress)
+
+	a.NoError(exchangeOn(alice, func(stream *transportstream.Stream) error {
+		status, err := cmd.JobStatusOf(info.ID, stream)
+		if err == nil {
+			a.Equal(float64(100), status.Progress.Percent)
+		}
+		return err
+	}))
+
+	a.NoError(exchangeOn(alice, func(stream *transportstream.Stream) error {
+		res, err := cmd.JobResult(info.ID, stream)
+		if err != nil {
+			return err
+		}
+		var str string
+		a.NoError(res.UnmarshalJson(&str))
+		a.Equal("done", str)
+		return nil
+	}))
+
+	a.True(errors.ErrCodeJobNotFound.Equal(exchangeOn(alice, func(stream *transportstream.Stream) error {
+		_, err := cmd.JobStatusOf("unknown", stream)
+		return err
+	})))
+}
+
+func TestJobCancel(t *testing.T) {
+	a := assert.New(t)
+
+	manager := cmd.NewJobManager(1, 1, 0)
+	job, err := manager.Submit("/slow", "alice", func(ctx context.Context, job *cmd.Job) (cmd.ExchangeData, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if !a.NoError(err) {
+		return
+	}
+
+	_, err = manager.Cancel(job.ID(), "other")
+	a.True(errors.ErrCodeJobNotFound.Equal(err))
+
+	_, err = manager.Cancel(job.ID(), "")
+	a.True(errors.ErrCodeJobNotFound.Equal(err))
+
+	_, err = manager.Cancel(job.ID(), "alice")
+	a.NoError(err)
+
+	for {
+		info, _ := manager.Get(job.ID(), "alice")
+		if info.Info().Status.Finished() {
+			a.Equal(cmd.JobStatusCancelled, info.Info().Status)
+			a.True(errors.ErrCodeCancelled.Equal(info.Info().Err))
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestJobWithoutSession(t *testing.T) {
+	a := assert.New(t)
+
+	manager := cmd.NewJobManager(1, 8, 50*time.Millisecond)
+	router := cmd.NewRouter()
+	router.SetJobManager(manager)
+	router.Handle("/export", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		if _, err := stream.ReceiveMsg(); err != nil {
+			return nil, err
+		}
+		return cmd.SubmitJob(quicStream, func(ctx context.Context, job *cmd.Job) (cmd.ExchangeData, error) {
+			return cmd.NewExchangeDataByJson("done")
+		})
+	})
+
+	// 不经过连接的交换没有会话, 任务不属于任何用户
+	var info *cmd.JobInfo
+	cmdtest.MustCall(t, router, "/export", nil, &info)
+	if !a.NotNil(info) {
+		return
+	}
+	job, ok := manager.Get(info.ID, "")
+	if !a.True(ok) {
+		return
+	}
+	_, ok = cmd.DefaultJobManager.Get(info.ID, "")
+	a.False(ok)
+
+	finished, err := cmd.WatchJob(info.ID, serveOnce(router), nil)
+	if a.NoError(err) {
+		a.Equal(cmd.JobStatusSucceeded, finished.Status)
+	}
+	res, err := cmd.JobResult(info.ID, serveOnce(router))
+	if a.NoError(err) {
+		var str string
+		a.NoError(res.UnmarshalJson(&str))
+		a.Equal("done", str)
+	}
+
+	// 超过保留时间的任务视为不存在
+	deadline := time.Now().Add(5 * time.Second)
+	for job.Info().FinishedAt == nil || time.Since(*job.Info().FinishedAt) <= 50*time.Millisecond {
+		if time.Now().After(deadline) {
+			t.Fatal("等待任务结束超时")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	_, err = cmd.JobStatusOf(info.ID, serveOnce(router))
+	a.True(errors.ErrCodeJobNotFound.Equal(err), err)
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"io"
+	"sync/atomic"
+)
+
+const (
+	// DefaultMaxPayloadSize 默认的单个消息最大字节数
+	DefaultMaxPayloadSize int64 = 32 << 20
+	// maxCommandSize 命令消息的最大字节数
+	maxCommandSize int64 = 4 << 10
+)
+
+// SetMaxPayloadSize 设置 DefaultRouter 中命令单个消息的最大字节数, 客户端接收数据时同样使用 DefaultRouter 的设置,
+// size 小于0时使用默认值, 等于0时不限制
+func SetMaxPayloadSize(name Name, size int64) {
+	DefaultRouter.SetMaxPayloadSize(name, size)
+}
+
+// SetMaxPayloadSize 设置命令单个消息的最大字节数, 参数化命令使用注册时的名称, size 小于0时使用默认值, 等于0时不限制
+func (r *Router) SetMaxPayloadSize(name Name, size int64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if size < 0 {
+		delete(r.payloadSizes, name)
+		return
+	}
+	r.payloadSizes[name] = size
+}
+
+// SetDefaultMaxPayloadSize 设置 DefaultRouter 中未单独配置的命令单个消息的最大字节数, 等于0时不限制
+func SetDefaultMaxPayloadSize(size int64) {
+	DefaultRouter.SetDefaultMaxPayloadSize(size)
+}
+
+// SetDefaultMaxPayloadSize 设置未单独配置的命令单个消息的最大字节数, 等于0时不限制
+func (r *Router) SetDefaultMaxPayloadSize(size int64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.defaultPayloadSize = size
+}
+
+func (r *Router) maxPayloadSize(name Name) int64 {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if size, ok := r.payloadSizes[name]; ok {
+		return size
+	}
+	return r.defaultPayloadSize
+}
+
+// frameLimitReader 在 transportstream 分配内存之前检查每一帧的长度头,
+// 超出限制后始终返回 io.EOF, 使流上的读取尽快结束
+type frameLimitReader struct {
+	r io.Reader
+
+	limit    int64
+	exceeded int32
+
+	header     [8]byte
+	headerRead int
+	remaining  int64
+}
+
+func newFrameLimitReader(r io.Reader, limit int64) *frameLimitReader {
+	return &frameLimitReader{
+		r:     r,
+		limit: limit,
+	}
+}
+
+// setLimit 设置单帧数据的最大字节数, 等于0时不限制
+func (f *frameLimitReader) setLimit(limit int64) {
+	if f == nil {
+		return
+	}
+	atomic.StoreInt64(&f.limit, limit)
+}
+
+// markExceeded 标记读取到了超出限制的数据
+func (f *frameLimitReader) markExceeded() {
+	atomic.StoreInt32(&f.exceeded, 1)
+}
+
+// isExceeded 是否读取到了超出限制的帧
+func (f *frameLimitReader) isExceeded() bool {
+	return f != nil && atomic.LoadInt32(&f.exceeded) == 1
+}
+
+func (f *frameLimitReader) Read(p []byte) (int, error) {
+	if f.isExceeded() {
+		return 0, io.EOF
+	}
+
+	if f.remaining > 0 {
+		if int64(len(p)) > f.remaining {
+			p = p[:f.remaining]
+		}
+		n, err := f.r.Read(p)
+		f.remaining -= int64(n)
+		return n, err
+	}
+
+	if len(p) > len(f.header)-f.headerRead {
+		p = p[:len(f.header)-f.headerRead]
+	}
+	n, err := f.r.Read(p)
+	copy(f.header[f.headerRead:], p[:n])
+	f.headerRead += n
+	if f.headerRead < len(f.header) {
+		return n, err
+	}
+
+	f.headerRead = 0
+	// 帧长度中包含1个字节的消息标识
+	frameLen := int64(binary.BigEndian.Uint64(f.header[:]))
+	limit := atomic.LoadInt64(&f.limit)
+	if frameLen < 0 || (limit > 0 && frameLen-1 > limit) {
+		f.markExceeded()
+		return 0, io.EOF
+	}
+	f.remaining = frameLen
+	return n, err
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/teamManagement/common/errors"
+	"github.com/teamManagement/common/internal/memstream"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFrameLimitReader(t *testing.T) {
+	a := assert.New(t)
+
+	buf := &bytes.Buffer{}
+	writer := transportstream.NewStream(bufio.NewReadWriter(bufio.NewReader(buf), bufio.NewWriter(buf)))
+	a.NoError(writer.WriteMsg([]byte(Login), transportstream.MsgFlagSuccess))
+	a.NoError(writer.WriteMsg(bytes.Repeat([]byte{1}, 16), transportstream.MsgFlagSuccess))
+	a.NoError(writer.WriteMsg(bytes.Repeat([]byte{2}, 17), transportstream.MsgFlagSuccess))
+
+	limiter := newFrameLimitReader(buf, maxCommandSize)
+	reader := transportstream.NewStream(bufio.NewReadWriter(bufio.NewReader(limiter), bufio.NewWriter(io.Discard)))
+
+	msg, err := reader.ReceiveMsg()
+	a.NoError(err)
+	a.Equal(Login, Name(msg))
+
+	limiter.setLimit(16)
+	msg, err = reader.ReceiveMsg()
+	a.NoError(err)
+	a.Len(msg, 16)
+	a.False(limiter.isExceeded())
+
+	_, err = reader.ReceiveMsg()
+	a.Equal(io.EOF, err)
+	a.True(limiter.isExceeded())
+}
+
+func TestRouterMaxPayloadSize(t *testing.T) {
+	a := assert.New(t)
+
+	router := NewRouter()
+	a.Equal(DefaultMaxPayloadSize, router.maxPayloadSize("/team/list"))
+
+	router.SetDefaultMaxPayloadSize(64)
+	router.SetMaxPayloadSize("/file/upload", 0)
+	a.EqualValues(64, router.maxPayloadSize("/team/list"))
+	a.EqualValues(0, router.maxPayloadSize("/file/upload"))
+	a.Equal(DefaultMaxPayloadSize, NewRouter().maxPayloadSize("/team/list"))
+
+	router.SetMaxPayloadSize("/file/upload", -1)
+	a.EqualValues(64, router.maxPayloadSize("/file/upload"))
+}
+
+func TestRouteMaxPayloadSize(t *testing.T) {
+	a := assert.New(t)
+
+	router := NewRouter()
+	router.Handle("/upload", func(stream *transportstream.Stream, quicStream quic.Stream) (ExchangeData, error) {
+		return stream.ReceiveMsg()
+	})
+	router.SetMaxPayloadSize("/upload", 16)
+
+	route := func() *transportstream.Stream {
+		client, server := memstream.Pipe()
+		go func() {
+			defer server.Close()
+			_ = router.Route(server.TransportStream(), server)
+		}()
+		return client.TransportStream()
+	}
+
+	res, err := Name("/upload").ExchangeWithData("small", route())
+	a.NoError(err)
+	var data string
+	a.NoError(res.UnmarshalJson(&data))
+	a.Equal("small", data)
+
+	_, err = Name("/upload").ExchangeWithData(strings.Repeat("x", 64), route())
+	a.True(errors.ErrCodePayloadTooLarge.Equal(err), err)
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/teamManagement/common/errors"
+)
+
+const (
+	// HeaderAcceptProgress 客户端声明接收进度消息时请求头的值为 true, 未声明时服务端不发送进度消息
+	HeaderAcceptProgress = "Accept-Progress"
+)
+
+// Progress Handler 通过 ReportProgress 报告的处理进度
+type Progress struct {
+	// Percent 完成百分比, 0-100
+	Percent float64 `json:"percent"`
+	// Stage 当前阶段
+	Stage string `json:"stage,omitempty"`
+	// Message 进度描述
+	Message string `json:"message,omitempty"`
+}
+
+// ReportProgress 在 Handler 中向客户端报告处理进度, 与数据消息相互独立, 不影响最终的返回数据.
+// 客户端未声明接收进度或 Handler 已返回时不发送任何消息. 可以在其他 goroutine 中调用,
+// 此时 Handler 自身向流中写入数据需要通过 Request.WriteLocked 进行, 以免与进度消息交错
+func ReportProgress(quicStream quic.Stream, percent float64, stage, message string) error {
+	request := RequestOf(quicStream)
+	return request.WriteLocked(func() error {
+		if request.progressStream == nil {
+			return nil
+		}
+		return writeProgress(request.progressStream, Progress{
+			Percent: percent,
+			Stage:   stage,
+			Message: message,
+		})
+	})
+}
+
+// writeProgress 以 errors.ErrCodeProgress 异常消息的形式发送进度, 以便与数据消息区分;
+// 仅在客户端通过 HeaderAcceptProgress 声明接收进度时发送, 不识别进度消息的旧客户端不会将其视为失败
+func writeProgress(stream *transportstream.Stream, progress Progress) error {
+	marshal, err := json.Marshal(progress)
+	if err != nil {
+		return fmt.Errorf("序列化进度信息失败: %s", err.Error())
+	}
+
+	errInfo := errors.ErrCodeProgress.New(progress.Stage)
+	errInfo.RawData = marshal
+	return stream.WriteError(errInfo)
+}
+
+// progressOf 解析进度消息, 不是进度消息时返回false
+func progressOf(err error) (Progress, bool) {
+	var progress Progress
+	errInfo, ok := err.(*transportstream.ErrInfo)
+	if !ok || errInfo.Code != errors.ErrCodeProgress {
+		return progress, false
+	}
+
+	if err = json.Unmarshal(errInfo.RawData, &progress); err != nil {
+		return progress, false
+	}
+	return progress, true
+}
@@ -0,0 +1,129 @@
+package cmd_test
+
+import (
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/teamManagement/common/cmd"
+	"github.com/teamManagement/common/cmdtest"
+	"github.com/teamManagement/common/errors"
+	"testing"
+)
+
+func TestReportProgress(t *testing.T) {
+	a := assert.New(t)
+
+	router := cmd.NewRouter()
+	router.Handle("/export", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		if _, err := stream.ReceiveMsg(); err != nil {
+			return nil, err
+		}
+		if err := cmd.ReportProgress(quicStream, 50, "export", "half"); err != nil {
+			return nil, err
+		}
+		if err := stream.WriteJsonMsg("chunk"); err != nil {
+			return nil, err
+		}
+		if _, err := stream.ReceiveMsg(); err != nil {
+			return nil, err
+		}
+		if err := cmd.ReportProgress(quicStream, 100, "export", ""); err != nil {
+			return nil, err
+		}
+		return cmd.NewExchangeDataByJson("done")
+	})
+
+	var (
+		progresses []cmd.Progress
+		chunks     []string
+	)
+	data, err := cmdtest.CallWithOption(t, router, "/export", &cmd.ExchangeOption{
+		Compress: true,
+		Progress: func(progress cmd.Progress) {
+			progresses = append(progresses, progress)
+		},
+		StreamHandle: func(exchangeData cmd.ExchangeData, stream *transportstream.Stream) (cmd.ExchangeData, error) {
+			var chunk string
+			if err := exchangeData.UnmarshalJson(&chunk); err != nil {
+				return nil, err
+			}
+			chunks = append(chunks, chunk)
+			return cmd.NewExchangeDataByJson("next")
+		},
+	})
+	a.NoError(err)
+	var res string
+	a.NoError(data.UnmarshalJson(&res))
+	a.Equal("done", res)
+	a.Equal([]string{"chunk"}, chunks)
+	a.Equal([]cmd.Progress{
+		{Percent: 50, Stage: "export", Message: "half"},
+		{Percent: 100, Stage: "export"},
+	}, progresses)
+
+	// 未声明接收进度时不发送进度消息
+	data, err = cmdtest.CallWithOption(t, router, "/export", &cmd.ExchangeOption{
+		StreamHandle: func(exchangeData cmd.ExchangeData, stream *transportstream.Stream) (cmd.ExchangeData, error) {
+			return cmd.NewExchangeDataByJson("next")
+		},
+	})
+	a.NoError(err)
+	a.NoError(data.UnmarshalJson(&res))
+	a.Equal("done", res)
+
+	// 进度消息使用的异常码位于所有异常码之后, 不改变已有异常码的数值
+	a.Equal("Progress", errors.CodeName(errors.ErrCodeProgress))
+	codes := errors.Codes()
+	a.NotContains(codes, errors.ErrCodeProgress)
+	a.Greater(errors.ErrCodeProgress, codes[len(codes)-1])
+}
+
+func TestReportProgressConcurrent(t *testing.T) {
+	a := assert.New(t)
+
+	router := cmd.NewRouter()
+	router.Handle("/import", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 1; i <= 10; i++ {
+				_ = cmd.ReportProgress(quicStream, float64(i*10), "import", "")
+			}
+		}()
+		request := cmd.RequestOf(quicStream)
+		for i := 0; i < 10; i++ {
+			if err := request.WriteLocked(func() error { return stream.WriteJsonMsg(i) }); err != nil {
+				return nil, err
+			}
+			if _, err := stream.ReceiveMsg(); err != nil {
+				return nil, err
+			}
+		}
+		<-done
+		return cmd.NewExchangeDataByJson("done")
+	})
+
+	var (
+		progresses int
+		chunks     []int
+	)
+	data, err := cmdtest.CallWithOption(t, router, "/import", &cmd.ExchangeOption{
+		Progress: func(progress cmd.Progress) {
+			progresses++
+		},
+		StreamHandle: func(exchangeData cmd.ExchangeData, stream *transportstream.Stream) (cmd.ExchangeData, error) {
+			var chunk int
+			if err := exchangeData.UnmarshalJson(&chunk); err != nil {
+				return nil, err
+			}
+			chunks = append(chunks, chunk)
+			return cmd.NewExchangeDataByJson("next")
+		},
+	})
+	a.NoError(err)
+	var res string
+	a.NoError(data.UnmarshalJson(&res))
+	a.Equal("done", res)
+	a.Equal([]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, chunks)
+	a.Equal(10, progresses)
+}
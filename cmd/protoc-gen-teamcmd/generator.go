@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/protoc-gen-gogo/descriptor"
+	"github.com/gogo/protobuf/protoc-gen-gogo/generator"
+	plugin "github.com/gogo/protobuf/protoc-gen-gogo/plugin"
+	"go/format"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// SourceCodeInfo 中 service 及 method 的路径编号
+const (
+	servicePathNumber = 6
+	methodPathNumber  = 2
+)
+
+// goType 消息对应的Go类型
+type goType struct {
+	importPath string
+	pkg        string
+	name       string
+}
+
+// fileGenerator 生成单个 proto 文件的命令代码
+type fileGenerator struct {
+	file    *descriptor.FileDescriptorProto
+	pkg     string
+	types   map[string]*goType
+	imports map[string]string
+}
+
+// generate 为请求中需要生成的每个包含 service 的文件生成代码
+func generate(req *plugin.CodeGeneratorRequest) *plugin.CodeGeneratorResponse {
+	res := &plugin.CodeGeneratorResponse{}
+	params := parseParams(req.GetParameter())
+
+	files := map[string]*descriptor.FileDescriptorProto{}
+	types := map[string]*goType{}
+	for _, file := range req.GetProtoFile() {
+		files[file.GetName()] = file
+		importPath, pkg := goPackage(file)
+		for _, msg := range file.GetMessageType() {
+			collectTypes(types, "."+file.GetPackage(), nil, msg, importPath, pkg)
+		}
+	}
+
+	for _, name := range req.GetFileToGenerate() {
+		file, ok := files[name]
+		if !ok || len(file.GetService()) == 0 {
+			continue
+		}
+
+		g := &fileGenerator{
+			file:    file,
+			types:   types,
+			imports: map[string]string{},
+		}
+		_, g.pkg = goPackage(file)
+		if pkg := params["package"]; pkg != "" {
+			g.pkg = pkg
+		}
+
+		content, err := g.generate()
+		if err != nil {
+			res.Error = proto.String(fmt.Sprintf("%s: %s", name, err.Error()))
+			return res
+		}
+		res.File = append(res.File, &plugin.CodeGeneratorResponse_File{
+			Name:    proto.String(strings.TrimSuffix(name, ".proto") + ".cmd.go"),
+			Content: proto.String(content),
+		})
+	}
+	return res
+}
+
+func parseParams(parameter string) map[string]string {
+	params := map[string]string{}
+	for _, pair := range strings.Split(parameter, ",") {
+		if k, v, ok := strings.Cut(pair, "="); ok {
+			params[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return params
+}
+
+// goPackage 文件的Go导入路径及包名, 规则与 protoc-gen-gogo 一致
+func goPackage(file *descriptor.FileDescriptorProto) (string, string) {
+	goPkg := file.GetOptions().GetGoPackage()
+	if goPkg != "" {
+		if importPath, pkg, ok := strings.Cut(goPkg, ";"); ok {
+			return importPath, pkg
+		}
+		return goPkg, cleanPackageName(path.Base(goPkg))
+	}
+	if file.GetPackage() != "" {
+		return "", cleanPackageName(file.GetPackage())
+	}
+	return "", cleanPackageName(strings.TrimSuffix(path.Base(file.GetName()), ".proto"))
+}
+
+func cleanPackageName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || r == '/' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// collectTypes 记录消息及其嵌套消息的Go类型, 嵌套消息的类型名称以 _ 连接
+func collectTypes(types map[string]*goType, prefix string, parents []string, msg *descriptor.DescriptorProto, importPath, pkg string) {
+	names := append(append([]string(nil), parents...), msg.GetName())
+	fullName := prefix + "." + msg.GetName()
+	if prefix == "." {
+		fullName = "." + msg.GetName()
+	}
+	types[fullName] = &goType{
+		importPath: importPath,
+		pkg:        pkg,
+		name:       generator.CamelCaseSlice(names),
+	}
+	for _, nested := range msg.GetNestedType() {
+		collectTypes(types, fullName, names, nested, importPath, pkg)
+	}
+}
+
+// typeName 获取消息在生成代码中的类型名称, 其他包的消息自动添加导入
+func (g *fileGenerator) typeName(protoName string) (string, error) {
+	t, ok := g.types[protoName]
+	if !ok {
+		return "", fmt.Errorf("未找到消息类型 %s", protoName)
+	}
+
+	selfImportPath, _ := goPackage(g.file)
+	if t.importPath == selfImportPath && t.pkg == g.pkg {
+		return t.name, nil
+	}
+	if t.importPath == "" {
+		return "", fmt.Errorf("消息类型 %s 位于其他包, 需要设置 go_package", protoName)
+	}
+
+	alias, ok := g.imports[t.importPath]
+	if !ok {
+		alias = t.pkg
+		for _, used := range g.imports {
+			if used == alias {
+				alias = t.pkg + strconv.Itoa(len(g.imports))
+				break
+			}
+		}
+		g.imports[t.importPath] = alias
+	}
+	return alias + "." + t.name, nil
+}
+
+// comment 获取 service 或 method 定义之前的注释
+func (g *fileGenerator) comment(path ...int32) string {
+	for _, location := range g.file.GetSourceCodeInfo().GetLocation() {
+		if len(location.GetPath()) != len(path) {
+			continue
+		}
+		matched := true
+		for i, p := range path {
+			if location.GetPath()[i] != p {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return strings.TrimSpace(location.GetLeadingComments())
+		}
+	}
+	return ""
+}
+
+type methodData struct {
+	Name        string
+	Command     string
+	Input       string
+	Output      string
+	Comment     []string
+	Description string
+}
+
+type serviceData struct {
+	Name    string
+	Comment []string
+	Methods []*methodData
+}
+
+func commentLines(comment string) []string {
+	if comment == "" {
+		return nil
+	}
+	return strings.Split(comment, "\n")
+}
+
+func (g *fileGenerator) generate() (string, error) {
+	var services []*serviceData
+	for i, service := range g.file.GetService() {
+		serviceName := generator.CamelCase(service.GetName())
+		fullName := service.GetName()
+		if g.file.GetPackage() != "" {
+			fullName = g.file.GetPackage() + "." + fullName
+		}
+
+		data := &serviceData{
+			Name:    serviceName,
+			Comment: commentLines(g.comment(servicePathNumber, int32(i))),
+		}
+		for j, method := range service.GetMethod() {
+			if method.GetClientStreaming() || method.GetServerStreaming() {
+				return "", fmt.Errorf("%s.%s: 暂不支持流式方法", service.GetName(), method.GetName())
+			}
+
+			input, err := g.typeName(method.GetInputType())
+			if err != nil {
+				return "", err
+			}
+			output, err := g.typeName(method.GetOutputType())
+			if err != nil {
+				return "", err
+			}
+			comment := g.comment(servicePathNumber, int32(i), methodPathNumber, int32(j))
+			data.Methods = append(data.Methods, &methodData{
+				Name:        generator.CamelCase(method.GetName()),
+				Command:     "/" + fullName + "/" + method.GetName(),
+				Input:       input,
+				Output:      output,
+				Comment:     commentLines(comment),
+				Description: comment,
+			})
+		}
+		services = append(services, data)
+	}
+
+	imports := make([]string, 0, len(g.imports))
+	for importPath, alias := range g.imports {
+		imports = append(imports, fmt.Sprintf("%s %q", alias, importPath))
+	}
+	sort.Strings(imports)
+
+	buf := &bytes.Buffer{}
+	if err := fileTemplate.Execute(buf, map[string]any{
+		"Source":   g.file.GetName(),
+		"Package":  g.pkg,
+		"Imports":  imports,
+		"Services": services,
+	}); err != nil {
+		return "", err
+	}
+
+	formatted, err := format.Source(buf.Bytes())
+	if err != nil {
+		return "", fmt.Errorf("格式化生成的代码失败: %s", err.Error())
+	}
+	return string(formatted), nil
+}
+
+var fileTemplate = template.Must(template.New("file").Parse(`// Code generated by protoc-gen-teamcmd. DO NOT EDIT.
+// source: {{.Source}}
+
+package {{.Package}}
+
+import (
+	"bufio"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/teamManagement/common/cmd"
+	"github.com/teamManagement/common/codec"
+	"github.com/teamManagement/common/errors"
+{{- range .Imports}}
+	{{.}}
+{{- end}}
+)
+{{range $service := .Services}}
+const (
+{{- range .Methods}}
+	// {{$service.Name}}_{{.Name}}_Name {{$service.Name}}.{{.Name}} 对应的命令
+	{{$service.Name}}_{{.Name}}_Name cmd.Name = "{{.Command}}"
+{{- end}}
+)
+
+// {{.Name}}Server {{.Name}} 服务端需要实现的接口
+{{- range .Comment}}
+// {{.}}
+{{- end}}
+type {{.Name}}Server interface {
+{{- range .Methods}}
+{{- range .Comment}}
+	// {{.}}
+{{- end}}
+	{{.Name}}(request *cmd.Request, in *{{.Input}}) (*{{.Output}}, error)
+{{- end}}
+}
+
+// Register{{.Name}}Server 将 srv 的方法注册为命令, 同时记录命令的类型信息
+func Register{{.Name}}Server(registrar cmd.Registrar, srv {{.Name}}Server) {
+{{- range .Methods}}
+	registrar.Handle({{$service.Name}}_{{.Name}}_Name, func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		data, err := stream.ReceiveMsg()
+		if err != nil {
+			return nil, err
+		}
+		in := &{{.Input}}{}
+		if err = cmd.ExchangeData(data).UnmarshalProto(in); err != nil {
+			return nil, errors.ErrCodeValidation.New(err.Error())
+		}
+		out, err := srv.{{.Name}}(cmd.RequestOf(quicStream), in)
+		if err != nil {
+			return nil, err
+		}
+		return cmd.NewExchangeDataByProto(out)
+	})
+	registrar.Describe({{$service.Name}}_{{.Name}}_Name, &cmd.CommandSpec{
+		Description: {{printf "%q" .Description}},
+		Request:     (*{{.Input}})(nil),
+		Response:    (*{{.Output}})(nil),
+		Codec:       codec.Proto,
+	})
+{{- end}}
+}
+
+// {{.Name}}Client {{.Name}} 的客户端, 每次调用使用 open 打开新的流, 调用结束之后由客户端关闭该流
+type {{.Name}}Client struct {
+	open func() (quic.Stream, error)
+}
+
+// New{{.Name}}Client 创建 {{.Name}} 的客户端, open 一般为 quic.Connection 的 OpenStream
+func New{{.Name}}Client(open func() (quic.Stream, error)) *{{.Name}}Client {
+	return &{{.Name}}Client{open: open}
+}
+{{range .Methods}}
+// {{.Name}} 执行 {{$service.Name}}_{{.Name}}_Name 命令, option 用于附加请求头等选项, 可以为nil;
+// 不修改 option 中的 Data 及 Codec, 交换完成后将响应头写入 option.ResponseHeader
+func (c *{{$service.Name}}Client) {{.Name}}(in *{{.Input}}, option *cmd.ExchangeOption) (*{{.Output}}, error) {
+	quicStream, err := c.open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = quicStream.Close()
+		quicStream.CancelRead(0)
+	}()
+
+	exchangeOption := cmd.ExchangeOption{}
+	if option != nil {
+		exchangeOption = *option
+	}
+	exchangeOption.Data = in
+	exchangeOption.Codec = codec.Proto
+
+	stream := transportstream.NewStream(bufio.NewReadWriter(bufio.NewReader(quicStream), bufio.NewWriter(quicStream)))
+	res, err := {{$service.Name}}_{{.Name}}_Name.ExchangeWithOption(stream, &exchangeOption)
+	if option != nil {
+		option.ResponseHeader = exchangeOption.ResponseHeader
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := &{{.Output}}{}
+	if err = res.UnmarshalProto(out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+{{end}}
+{{- end}}`))
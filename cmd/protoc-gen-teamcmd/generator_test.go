@@ -0,0 +1,101 @@
+package main
+
+import (
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/protoc-gen-gogo/descriptor"
+	plugin "github.com/gogo/protobuf/protoc-gen-gogo/plugin"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func testRequest(parameter string, streaming bool) *plugin.CodeGeneratorRequest {
+	message := &descriptor.FileDescriptorProto{
+		Name:    proto.String("protos/message.proto"),
+		Package: proto.String("teamprotos"),
+		Options: &descriptor.FileOptions{
+			GoPackage: proto.String("github.com/teamManagement/common/protos;team"),
+		},
+		MessageType: []*descriptor.DescriptorProto{{Name: proto.String("Message")}},
+	}
+	service := &descriptor.FileDescriptorProto{
+		Name:       proto.String("protos/chat.proto"),
+		Package:    proto.String("teamprotos"),
+		Dependency: []string{"protos/message.proto"},
+		Options: &descriptor.FileOptions{
+			GoPackage: proto.String("github.com/teamManagement/common/protos;team"),
+		},
+		Service: []*descriptor.ServiceDescriptorProto{{
+			Name: proto.String("Chat"),
+			Method: []*descriptor.MethodDescriptorProto{{
+				Name:            proto.String("Send"),
+				InputType:       proto.String(".teamprotos.Message"),
+				OutputType:      proto.String(".teamprotos.Message"),
+				ServerStreaming: proto.Bool(streaming),
+			}},
+		}},
+		SourceCodeInfo: &descriptor.SourceCodeInfo{
+			Location: []*descriptor.SourceCodeInfo_Location{{
+				Path:            []int32{servicePathNumber, 0, methodPathNumber, 0},
+				LeadingComments: proto.String(" 发送消息\n"),
+			}},
+		},
+	}
+	return &plugin.CodeGeneratorRequest{
+		FileToGenerate: []string{"protos/chat.proto"},
+		Parameter:      proto.String(parameter),
+		ProtoFile:      []*descriptor.FileDescriptorProto{message, service},
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	a := assert.New(t)
+
+	res := generate(testRequest("", false))
+	if !a.Nil(res.Error) || !a.Len(res.File, 1) {
+		return
+	}
+	a.Equal("protos/chat.cmd.go", res.File[0].GetName())
+	a.Contains(res.File[0].GetContent(), `Chat_Send_Name cmd.Name = "/teamprotos.Chat/Send"`)
+	a.Contains(res.File[0].GetContent(), "// 发送消息")
+	a.Contains(res.File[0].GetContent(), "Send(request *cmd.Request, in *Message) (*Message, error)")
+	// 客户端复制调用方的选项并关闭自身打开的流
+	a.NotContains(res.File[0].GetContent(), "option.Data = in")
+	a.Contains(res.File[0].GetContent(), "_ = quicStream.Close()")
+
+	res = generate(testRequest("", true))
+	a.Contains(res.GetError(), "流式方法")
+}
+
+// TestGenerateCompiles 将生成的代码放入独立的包中编译, 此时消息类型来自 protos 包
+func TestGenerateCompiles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("跳过编译生成代码")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("未找到 go 命令")
+	}
+
+	res := generate(testRequest("package=gentest", false))
+	if res.Error != nil {
+		t.Fatal(res.GetError())
+	}
+	assert.Contains(t, res.File[0].GetContent(), "team.Message")
+
+	dir, err := os.MkdirTemp(".", "gentest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = os.WriteFile(filepath.Join(dir, "chat.cmd.go"), []byte(res.File[0].GetContent()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(goBin, "vet", "./"+filepath.Base(dir)).CombinedOutput()
+	if err != nil {
+		t.Fatalf("生成的代码编译失败: %s\n%s", err.Error(), out)
+	}
+}
@@ -0,0 +1,44 @@
+// protoc-gen-teamcmd 根据 proto 文件中的 service 定义生成命令的服务端接口及客户端桩代码.
+//
+// 用法:
+//
+//	protoc --gogo_out=. --teamcmd_out=. *.proto
+//
+// 每个 rpc 方法对应一个名称为 /<package>.<Service>/<Method> 的命令, 数据使用 proto 编解码.
+// 生成的文件与 proto 文件位于同一目录, 文件名为 <name>.cmd.go, 暂不支持流式方法.
+// 参数 package=<name> 可以覆盖生成代码的包名
+package main
+
+import (
+	"fmt"
+	"github.com/gogo/protobuf/proto"
+	plugin "github.com/gogo/protobuf/protoc-gen-gogo/plugin"
+	"io"
+	"os"
+)
+
+func main() {
+	if err := run(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "protoc-gen-teamcmd: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(r io.Reader, w io.Writer) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("读取请求失败: %s", err.Error())
+	}
+
+	req := &plugin.CodeGeneratorRequest{}
+	if err = proto.Unmarshal(data, req); err != nil {
+		return fmt.Errorf("解析请求失败: %s", err.Error())
+	}
+
+	res := generate(req)
+	if data, err = proto.Marshal(res); err != nil {
+		return fmt.Errorf("序列化响应失败: %s", err.Error())
+	}
+	_, err = w.Write(data)
+	return err
+}
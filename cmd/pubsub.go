@@ -0,0 +1,634 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/teamManagement/common/errors"
+	"sync"
+	"time"
+)
+
+// SubscribeCmd 内置命令, 在长连接流上订阅主题并持续接收发布的事件
+const SubscribeCmd Name = "/_subscribe"
+
+func init() {
+	reservedCmdMap[SubscribeCmd] = subscribeHandler
+}
+
+const (
+	// DefaultSubscribeBufferSize 订阅者默认的事件缓冲数量
+	DefaultSubscribeBufferSize = 64
+	// maxSubscribeBufferSize 订阅者可以申请的最大事件缓冲数量
+	maxSubscribeBufferSize = 4096
+	// maxSubscribeTopics 一个订阅者可以同时订阅的最大主题数量
+	maxSubscribeTopics = 256
+)
+
+// SlowConsumerPolicy 订阅者的事件缓冲已满时的处理策略
+type SlowConsumerPolicy string
+
+const (
+	// SlowConsumerDropOldest 丢弃缓冲中最早的事件, 默认策略
+	SlowConsumerDropOldest SlowConsumerPolicy = "dropOldest"
+	// SlowConsumerDropNewest 丢弃新发布的事件
+	SlowConsumerDropNewest SlowConsumerPolicy = "dropNewest"
+	// SlowConsumerDisconnect 断开订阅, 客户端收到 errors.ErrCodeSlowConsumer
+	SlowConsumerDisconnect SlowConsumerPolicy = "disconnect"
+)
+
+// SubscribeRequest 订阅请求, 建立订阅时发送的第一条消息及之后用于增减主题的消息均使用该结构
+type SubscribeRequest struct {
+	// Topics 要订阅的主题
+	Topics []string `json:"topics,omitempty"`
+	// Unsubscribe 要取消订阅的主题
+	Unsubscribe []string `json:"unsubscribe,omitempty"`
+	// BufferSize 事件缓冲数量, 仅在建立订阅时生效
+	BufferSize int `json:"bufferSize,omitempty"`
+	// SlowConsumer 缓冲已满时的处理策略, 仅在建立订阅时生效
+	SlowConsumer SlowConsumerPolicy `json:"slowConsumer,omitempty"`
+}
+
+// Event 发布至主题的事件
+type Event struct {
+	// Topic 事件所属的主题
+	Topic string `json:"topic"`
+	// Seq 主题内递增的事件序号, 可用于检测丢失的事件, 主题的订阅者全部离开之后重新计数
+	Seq uint64 `json:"seq"`
+	// Data JSON格式的事件内容
+	Data json.RawMessage `json:"data,omitempty"`
+	// PublishedAt 发布时间
+	PublishedAt time.Time `json:"publishedAt"`
+	// Dropped 在该事件之前因缓冲已满而被丢弃的事件数量
+	Dropped uint64 `json:"dropped,omitempty"`
+}
+
+// Unmarshal 将事件内容反序列化至 v
+func (e *Event) Unmarshal(v any) error {
+	return json.Unmarshal(e.Data, v)
+}
+
+// SubscribeAuthorizer 订阅授权函数, 返回异常时拒绝订阅对应的主题;
+// 返回的异常不是 *transportstream.ErrInfo 时以 errors.ErrCodePermissionDenied 返回给客户端
+type SubscribeAuthorizer func(request *Request, topic string) error
+
+// SetSubscribeAuthorizer 设置订阅授权函数, 订阅命令属于内置命令不经过中间件, 需要鉴权时通过该函数实现, 为nil时不鉴权
+func (r *Router) SetSubscribeAuthorizer(authorizer SubscribeAuthorizer) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.subscribeAuthorizer = authorizer
+}
+
+func authorizeSubscribe(request *Request, topic string) error {
+	router := request.router
+	if router == nil {
+		router = DefaultRouter
+	}
+
+	router.lock.RLock()
+	authorizer := router.subscribeAuthorizer
+	router.lock.RUnlock()
+
+	if authorizer == nil {
+		return nil
+	}
+	if err := authorizer(request, topic); err != nil {
+		if _, ok := err.(*transportstream.ErrInfo); ok {
+			return err
+		}
+		return errors.ErrCodePermissionDenied.Newf("无权订阅主题[%s]: %s", topic, err.Error())
+	}
+	return nil
+}
+
+// Broker 主题的订阅关系及事件分发
+type Broker struct {
+	lock   sync.RWMutex
+	topics map[string]map[*subscriber]struct{}
+	// seq 仅记录有订阅者的主题, 避免任意发布的主题占用内存
+	seq map[string]uint64
+}
+
+// DefaultBroker 默认的事件分发器, 内置的订阅命令及 Publish 均使用该分发器
+var DefaultBroker = NewBroker()
+
+// NewBroker 创建事件分发器
+func NewBroker() *Broker {
+	return &Broker{
+		topics: map[string]map[*subscriber]struct{}{},
+		seq:    map[string]uint64{},
+	}
+}
+
+// Publish 使用 DefaultBroker 向主题发布事件
+func Publish(topic string, v any) error {
+	return DefaultBroker.Publish(topic, v)
+}
+
+// Publish 向主题发布事件, v 将被序列化为JSON, 主题没有订阅者时事件被丢弃
+func (b *Broker) Publish(topic string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("序列化事件内容失败: %s", err.Error())
+	}
+
+	b.lock.Lock()
+	if len(b.topics[topic]) == 0 {
+		b.lock.Unlock()
+		return nil
+	}
+	b.seq[topic]++
+	event := &Event{
+		Topic:       topic,
+		Seq:         b.seq[topic],
+		Data:        data,
+		PublishedAt: time.Now(),
+	}
+	subscribers := make([]*subscriber, 0, len(b.topics[topic]))
+	for sub := range b.topics[topic] {
+		subscribers = append(subscribers, sub)
+	}
+	b.lock.Unlock()
+
+	for _, sub := range subscribers {
+		sub.push(event)
+	}
+	return nil
+}
+
+// Subscribers 主题当前的订阅者数量
+func (b *Broker) Subscribers(topic string) int {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return len(b.topics[topic])
+}
+
+func (b *Broker) subscribe(sub *subscriber, topics ...string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, topic := range topics {
+		subs, ok := b.topics[topic]
+		if !ok {
+			subs = map[*subscriber]struct{}{}
+			b.topics[topic] = subs
+		}
+		subs[sub] = struct{}{}
+	}
+}
+
+func (b *Broker) unsubscribe(sub *subscriber, topics ...string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, topic := range topics {
+		if subs, ok := b.topics[topic]; ok {
+			delete(subs, sub)
+			if len(subs) == 0 {
+				delete(b.topics, topic)
+				delete(b.seq, topic)
+			}
+		}
+	}
+}
+
+// apply 按照订阅请求增减订阅者的主题, 订阅之后的主题数量超过 maxSubscribeTopics 时返回 errors.ErrCodeValidation
+func (b *Broker) apply(request *Request, sub *subscriber, req *SubscribeRequest) error {
+	if sub.countAfter(req.Topics) > maxSubscribeTopics {
+		return errors.ErrCodeValidation.Newf("订阅的主题数量不能超过 %d", maxSubscribeTopics)
+	}
+	for _, topic := range req.Topics {
+		if topic == "" {
+			return errors.ErrCodeValidation.New("订阅的主题不能为空")
+		}
+		if err := authorizeSubscribe(request, topic); err != nil {
+			return err
+		}
+	}
+
+	b.subscribe(sub, req.Topics...)
+	b.unsubscribe(sub, req.Unsubscribe...)
+	sub.lock.Lock()
+	for _, topic := range req.Topics {
+		sub.topics[topic] = struct{}{}
+	}
+	for _, topic := range req.Unsubscribe {
+		delete(sub.topics, topic)
+	}
+	sub.lock.Unlock()
+	return nil
+}
+
+// close 移除订阅者的所有订阅关系
+func (b *Broker) close(sub *subscriber) {
+	sub.lock.Lock()
+	sub.closed = true
+	topics := make([]string, 0, len(sub.topics))
+	for topic := range sub.topics {
+		topics = append(topics, topic)
+	}
+	sub.lock.Unlock()
+	b.unsubscribe(sub, topics...)
+}
+
+// subscriber 一条订阅流在服务端对应的订阅者
+type subscriber struct {
+	size   int
+	policy SlowConsumerPolicy
+	notify chan struct{}
+
+	lock    sync.Mutex
+	topics  map[string]struct{}
+	queue   []*Event
+	dropped uint64
+	slow    bool
+	closed  bool
+}
+
+func newSubscriber(size int, policy SlowConsumerPolicy) *subscriber {
+	if size <= 0 {
+		size = DefaultSubscribeBufferSize
+	} else if size > maxSubscribeBufferSize {
+		size = maxSubscribeBufferSize
+	}
+	if policy == "" {
+		policy = SlowConsumerDropOldest
+	}
+	return &subscriber{
+		size:   size,
+		policy: policy,
+		notify: make(chan struct{}, 1),
+		topics: map[string]struct{}{},
+	}
+}
+
+// countAfter 订阅 topics 之后的主题数量
+func (s *subscriber) countAfter(topics []string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	added := map[string]struct{}{}
+	for _, topic := range topics {
+		if _, ok := s.topics[topic]; !ok {
+			added[topic] = struct{}{}
+		}
+	}
+	return len(s.topics) + len(added)
+}
+
+func (s *subscriber) push(event *Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed || s.slow {
+		return
+	}
+
+	if len(s.queue) >= s.size {
+		switch s.policy {
+		case SlowConsumerDropNewest:
+			s.dropped++
+			return
+		case SlowConsumerDisconnect:
+			s.slow = true
+		default:
+			s.queue = s.queue[1:]
+			s.dropped++
+		}
+	}
+	if !s.slow {
+		// 丢弃最新事件时被丢弃的事件晚于缓冲中的事件, 记录在之后进入缓冲的第一个事件中
+		if s.policy == SlowConsumerDropNewest && s.dropped > 0 {
+			next := *event
+			next.Dropped = s.dropped
+			event = &next
+			s.dropped = 0
+		}
+		s.queue = append(s.queue, event)
+	}
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pop 取出缓冲中的所有事件, 丢弃最早事件时第一个事件的 Dropped 为此前丢弃的事件数量
+func (s *subscriber) pop() ([]*Event, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	events := s.queue
+	s.queue = nil
+	if len(events) > 0 && s.dropped > 0 && s.policy != SlowConsumerDropNewest {
+		first := *events[0]
+		first.Dropped = s.dropped
+		events[0] = &first
+		s.dropped = 0
+	}
+	return events, s.slow
+}
+
+func subscribeHandler(stream *transportstream.Stream, quicStream quic.Stream) (ExchangeData, error) {
+	request := RequestOf(quicStream)
+
+	var req *SubscribeRequest
+	if err := stream.ReceiveJsonMsg(&req); err != nil {
+		return nil, err
+	}
+	if req == nil {
+		return nil, errors.ErrCodeValidation.New("订阅请求不能为空")
+	}
+	switch req.SlowConsumer {
+	case "", SlowConsumerDropOldest, SlowConsumerDropNewest, SlowConsumerDisconnect:
+	default:
+		return nil, errors.ErrCodeValidation.Newf("不支持的慢消费处理策略: %s", req.SlowConsumer)
+	}
+
+	broker := DefaultBroker
+	sub := newSubscriber(req.BufferSize, req.SlowConsumer)
+	defer broker.close(sub)
+	if err := broker.apply(request, sub, req); err != nil {
+		return nil, err
+	}
+
+	// 客户端之后发送的消息用于增减订阅的主题, 客户端发送结束消息或流关闭时结束订阅
+	done := make(chan error, 1)
+	go func() {
+		for {
+			var req *SubscribeRequest
+			if err := stream.ReceiveJsonMsg(&req); err != nil {
+				done <- err
+				return
+			}
+			if req == nil {
+				continue
+			}
+			if err := broker.apply(request, sub, req); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case err := <-done:
+			if _, ok := err.(*transportstream.ErrInfo); ok {
+				return nil, err
+			}
+			return nil, nil
+		case <-sub.notify:
+			events, slow := sub.pop()
+			for _, event := range events {
+				if err := stream.WriteJsonMsg(event); err != nil {
+					<-done
+					return nil, nil
+				}
+			}
+			if slow {
+				// 读取协程仍在读取流, 需要等待客户端响应结束消息之后才能返回
+				_ = stream.WriteError(errors.ErrCodeSlowConsumer.New("订阅者消费过慢, 订阅已断开"))
+				<-done
+				return nil, nil
+			}
+		}
+	}
+}
+
+// SubscribeOption 客户端订阅选项
+type SubscribeOption struct {
+	// BufferSize 服务端及客户端的事件缓冲数量, 为0时使用 DefaultSubscribeBufferSize
+	BufferSize int
+	// SlowConsumer 服务端缓冲已满时的处理策略
+	SlowConsumer SlowConsumerPolicy
+	// Reconnect 流异常断开之后是否重新建立流并重新订阅所有主题, 断开期间发布的事件将丢失
+	Reconnect bool
+	// RetryInterval 重新建立流的间隔时间, 为0时为1秒
+	RetryInterval time.Duration
+}
+
+// Subscription 客户端的订阅
+type Subscription struct {
+	dial   func() (*transportstream.Stream, error)
+	option SubscribeOption
+	events chan *Event
+	closed chan struct{}
+
+	lock      sync.Mutex
+	stream    *transportstream.Stream
+	topics    map[string]struct{}
+	isClosed  bool
+	err       error
+	closeOnce sync.Once
+}
+
+// Subscribe 通过 dial 建立的流订阅主题, 开启重连时 dial 将被再次调用以建立新的流
+func Subscribe(dial func() (*transportstream.Stream, error), option *SubscribeOption, topics ...string) (*Subscription, error) {
+	if option == nil {
+		option = &SubscribeOption{}
+	}
+
+	s := &Subscription{
+		dial:   dial,
+		option: *option,
+		closed: make(chan struct{}),
+		topics: map[string]struct{}{},
+	}
+	if s.option.BufferSize <= 0 {
+		s.option.BufferSize = DefaultSubscribeBufferSize
+	}
+	if s.option.RetryInterval <= 0 {
+		s.option.RetryInterval = time.Second
+	}
+	s.events = make(chan *Event, s.option.BufferSize)
+	for _, topic := range topics {
+		s.topics[topic] = struct{}{}
+	}
+
+	stream, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	go s.run(stream)
+	return s, nil
+}
+
+// Events 接收事件的通道, 订阅结束之后关闭, 结束原因通过 Err 获取
+func (s *Subscription) Events() <-chan *Event {
+	return s.events
+}
+
+// Err 订阅异常结束的原因, 通过 Close 结束时为nil
+func (s *Subscription) Err() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.err
+}
+
+// Subscribe 追加订阅的主题
+func (s *Subscription) Subscribe(topics ...string) error {
+	return s.update(&SubscribeRequest{Topics: topics}, func() {
+		for _, topic := range topics {
+			s.topics[topic] = struct{}{}
+		}
+	})
+}
+
+// Unsubscribe 取消订阅的主题
+func (s *Subscription) Unsubscribe(topics ...string) error {
+	return s.update(&SubscribeRequest{Unsubscribe: topics}, func() {
+		for _, topic := range topics {
+			delete(s.topics, topic)
+		}
+	})
+}
+
+// Close 结束订阅
+func (s *Subscription) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.isClosed {
+		return nil
+	}
+	s.isClosed = true
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+	if s.stream != nil {
+		return s.stream.WriteEndMsg()
+	}
+	return nil
+}
+
+func (s *Subscription) update(req *SubscribeRequest, fn func()) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.isClosed {
+		return fmt.Errorf("订阅已结束")
+	}
+
+	fn()
+	// 未连接时仅记录主题, 重新建立流之后统一订阅
+	if s.stream == nil {
+		return nil
+	}
+	return s.stream.WriteJsonMsg(req)
+}
+
+// connect 建立流并订阅当前记录的所有主题
+func (s *Subscription) connect() (*transportstream.Stream, error) {
+	stream, err := s.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = SubscribeCmd.SendCommand(stream); err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.isClosed {
+		_ = stream.WriteEndMsg()
+		return nil, fmt.Errorf("订阅已结束")
+	}
+
+	req := &SubscribeRequest{
+		Topics:       make([]string, 0, len(s.topics)),
+		BufferSize:   s.option.BufferSize,
+		SlowConsumer: s.option.SlowConsumer,
+	}
+	for topic := range s.topics {
+		req.Topics = append(req.Topics, topic)
+	}
+	if err = stream.WriteJsonMsg(req); err != nil {
+		return nil, err
+	}
+	s.stream = stream
+	return stream, nil
+}
+
+func (s *Subscription) run(stream *transportstream.Stream) {
+	defer close(s.events)
+
+	for {
+		err := s.receive(stream)
+
+		s.lock.Lock()
+		s.stream = nil
+		closed := s.isClosed
+		s.lock.Unlock()
+
+		// finishStream 需要等待服务端的结束消息, 不能在持有锁时调用, 以免阻塞 Close 等操作
+		if _, ok := err.(*transportstream.ErrInfo); ok || err == nil {
+			finishStream(stream, err)
+		}
+		if closed || err == nil {
+			return
+		}
+
+		// 服务端明确返回的异常(如无权订阅、消费过慢)不再重连
+		if _, ok := err.(*transportstream.ErrInfo); ok || !s.option.Reconnect {
+			s.setErr(err)
+			return
+		}
+
+		for stream = nil; stream == nil; {
+			select {
+			case <-s.closed:
+				return
+			case <-time.After(s.option.RetryInterval):
+			}
+
+			if stream, err = s.connect(); err != nil {
+				if _, ok := err.(*transportstream.ErrInfo); ok {
+					s.setErr(err)
+					return
+				}
+				stream = nil
+			}
+		}
+	}
+}
+
+// receive 持续接收流上的事件, 服务端正常结束订阅时返回nil
+func (s *Subscription) receive(stream *transportstream.Stream) error {
+	for {
+		data, err := stream.ReceiveMsg()
+		if err == transportstream.StreamIsEnd {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var event *Event
+		if err = json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+
+		select {
+		case s.events <- event:
+		case <-s.closed:
+		}
+	}
+}
+
+// finishStream 服务端结束订阅之后响应结束消息, 收到异常时先发送结束消息终止服务端对流的读取,
+// 再读取剩余的消息直到服务端的结束消息
+func finishStream(stream *transportstream.Stream, err error) {
+	if err != nil {
+		_ = stream.WriteEndMsg()
+		for {
+			if _, e := stream.ReceiveMsg(); isStreamFinished(e) {
+				break
+			}
+		}
+	}
+	_ = stream.WriteEndMsg()
+}
+
+func (s *Subscription) setErr(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.err = err
+}
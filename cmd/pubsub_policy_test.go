@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/teamManagement/common/errors"
+	"testing"
+)
+
+func TestSubscriberSlowConsumerPolicy(t *testing.T) {
+	a := assert.New(t)
+
+	push := func(sub *subscriber, n int) {
+		for i := 1; i <= n; i++ {
+			sub.push(&Event{Topic: "t", Seq: uint64(i)})
+		}
+	}
+
+	sub := newSubscriber(2, "")
+	push(sub, 5)
+	events, slow := sub.pop()
+	a.False(slow)
+	if a.Len(events, 2) {
+		a.Equal(uint64(4), events[0].Seq)
+		a.Equal(uint64(3), events[0].Dropped)
+		a.Equal(uint64(5), events[1].Seq)
+	}
+
+	sub = newSubscriber(2, SlowConsumerDropNewest)
+	push(sub, 5)
+	events, _ = sub.pop()
+	if a.Len(events, 2) {
+		a.Equal(uint64(1), events[0].Seq)
+		a.Zero(events[0].Dropped)
+		a.Zero(events[1].Dropped)
+	}
+	// 被丢弃的事件位于之后的第一个事件之前
+	sub.push(&Event{Topic: "t", Seq: 6})
+	events, _ = sub.pop()
+	if a.Len(events, 1) {
+		a.Equal(uint64(6), events[0].Seq)
+		a.Equal(uint64(3), events[0].Dropped)
+	}
+
+	sub = newSubscriber(2, SlowConsumerDisconnect)
+	push(sub, 3)
+	events, slow = sub.pop()
+	a.True(slow)
+	a.Len(events, 2)
+}
+
+func TestBrokerTopicLimits(t *testing.T) {
+	a := assert.New(t)
+
+	broker := NewBroker()
+	a.NoError(broker.Publish("t", "nobody"))
+	a.Empty(broker.seq)
+
+	sub := newSubscriber(8, "")
+	a.NoError(broker.apply(&Request{}, sub, &SubscribeRequest{Topics: []string{"t"}}))
+	a.NoError(broker.Publish("t", "a"))
+	a.NoError(broker.Publish("t", "b"))
+	events, _ := sub.pop()
+	if a.Len(events, 2) {
+		a.Equal(uint64(2), events[1].Seq)
+	}
+
+	// 订阅者全部离开之后不再记录主题的序号
+	broker.close(sub)
+	a.Empty(broker.topics)
+	a.Empty(broker.seq)
+
+	sub = newSubscriber(8, "")
+	topics := make([]string, maxSubscribeTopics)
+	for i := range topics {
+		topics[i] = fmt.Sprintf("t%d", i)
+	}
+	a.NoError(broker.apply(&Request{}, sub, &SubscribeRequest{Topics: topics}))
+	// 重复订阅已有的主题不增加数量
+	a.NoError(broker.apply(&Request{}, sub, &SubscribeRequest{Topics: topics[:1]}))
+	err := broker.apply(&Request{}, sub, &SubscribeRequest{Topics: []string{"more"}})
+	a.True(errors.ErrCodeValidation.Equal(err), err)
+	a.Zero(broker.Subscribers("more"))
+}
@@ -0,0 +1,147 @@
+package cmd_test
+
+import (
+	"fmt"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/teamManagement/common/cmd"
+	"github.com/teamManagement/common/cmdtest"
+	"github.com/teamManagement/common/errors"
+	"testing"
+	"time"
+)
+
+// pubsubTimeout 等待订阅状态及事件的超时时间
+const pubsubTimeout = 5 * time.Second
+
+// waitSubscribers 等待主题的订阅者数量达到 n
+func waitSubscribers(t *testing.T, topic string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(pubsubTimeout)
+	for cmd.DefaultBroker.Subscribers(topic) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("主题[%s]的订阅者数量未达到 %d", topic, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func receiveEvent(t *testing.T, sub *cmd.Subscription) *cmd.Event {
+	t.Helper()
+	select {
+	case event := <-sub.Events():
+		return event
+	case <-time.After(pubsubTimeout):
+		t.Fatal("接收事件超时")
+		return nil
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	a := assert.New(t)
+
+	router := cmd.NewRouter()
+	sub, err := cmd.Subscribe(func() (*transportstream.Stream, error) {
+		return serveOnce(router), nil
+	}, nil, "pubsub/team/1")
+	if !a.NoError(err) {
+		return
+	}
+	waitSubscribers(t, "pubsub/team/1", 1)
+
+	a.NoError(cmd.Publish("pubsub/team/1", map[string]string{"member": "a"}))
+	event := receiveEvent(t, sub)
+	a.Equal("pubsub/team/1", event.Topic)
+	a.NotZero(event.Seq)
+	var data map[string]string
+	a.NoError(event.Unmarshal(&data))
+	a.Equal("a", data["member"])
+
+	a.NoError(sub.Subscribe("pubsub/team/2"))
+	a.NoError(sub.Unsubscribe("pubsub/team/1"))
+	waitSubscribers(t, "pubsub/team/2", 1)
+	waitSubscribers(t, "pubsub/team/1", 0)
+
+	a.NoError(cmd.Publish("pubsub/team/2", "b"))
+	a.Equal("pubsub/team/2", receiveEvent(t, sub).Topic)
+
+	a.NoError(sub.Close())
+	for range sub.Events() {
+	}
+	a.NoError(sub.Err())
+	waitSubscribers(t, "pubsub/team/2", 0)
+}
+
+func TestPubSubReconnect(t *testing.T) {
+	a := assert.New(t)
+
+	router := cmd.NewRouter()
+	servers := make(chan *cmdtest.Stream, 2)
+	served := make(chan struct{}, 2)
+	sub, err := cmd.Subscribe(func() (*transportstream.Stream, error) {
+		client, server := cmdtest.Pipe()
+		servers <- server
+		go func() {
+			_ = router.ServeStream(server)
+			served <- struct{}{}
+		}()
+		return client.TransportStream(), nil
+	}, &cmd.SubscribeOption{
+		Reconnect:     true,
+		RetryInterval: time.Millisecond,
+	}, "pubsub/reconnect")
+	if !a.NoError(err) {
+		return
+	}
+	defer sub.Close()
+	waitSubscribers(t, "pubsub/reconnect", 1)
+
+	// 模拟网络中断
+	server := <-servers
+	server.CancelWrite(0)
+	server.CancelRead(0)
+	<-served
+	<-servers
+	waitSubscribers(t, "pubsub/reconnect", 1)
+
+	a.NoError(cmd.Publish("pubsub/reconnect", "after"))
+	var data string
+	a.NoError(receiveEvent(t, sub).Unmarshal(&data))
+	a.Equal("after", data)
+}
+
+func TestSubscribeAuthorizer(t *testing.T) {
+	a := assert.New(t)
+
+	secured := cmd.NewRouter()
+	secured.SetSubscribeAuthorizer(func(request *cmd.Request, topic string) error {
+		if topic == "pubsub/secret" {
+			return fmt.Errorf("未登录")
+		}
+		return nil
+	})
+	denied, err := cmd.Subscribe(func() (*transportstream.Stream, error) {
+		return serveOnce(secured), nil
+	}, nil, "pubsub/secret")
+	if !a.NoError(err) {
+		return
+	}
+	select {
+	case _, ok := <-denied.Events():
+		a.False(ok)
+	case <-time.After(pubsubTimeout):
+		t.Fatal("等待订阅结束超时")
+	}
+	a.True(errors.ErrCodePermissionDenied.Equal(denied.Err()), denied.Err())
+
+	// 授权函数仅对设置它的路由表生效
+	sub, err := cmd.Subscribe(func() (*transportstream.Stream, error) {
+		return serveOnce(cmd.NewRouter()), nil
+	}, nil, "pubsub/secret")
+	if !a.NoError(err) {
+		return
+	}
+	waitSubscribers(t, "pubsub/secret", 1)
+	a.NoError(sub.Close())
+	waitSubscribers(t, "pubsub/secret", 0)
+}
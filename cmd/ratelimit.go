@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"fmt"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/teamManagement/common/errors"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitKey 限流的统计维度
+type RateLimitKey uint8
+
+const (
+	// RateLimitByConn 按连接限流
+	RateLimitByConn RateLimitKey = iota
+	// RateLimitByRemoteAddr 按对端IP限流, 同一IP的多条连接共享额度
+	RateLimitByRemoteAddr
+	// RateLimitByUser 按会话中已认证的用户限流, 未认证的会话按对端IP限流
+	RateLimitByUser
+)
+
+// RateLimitRule 命令的限流规则, 使用令牌桶算法
+type RateLimitRule struct {
+	// Rate 每秒生成的令牌数
+	Rate float64
+	// Burst 令牌桶容量, 即允许的突发请求数
+	Burst int
+	// Key 限流的统计维度
+	Key RateLimitKey
+}
+
+// bucketIdleTimeout 令牌桶空闲超过该时间之后将被清理
+const bucketIdleTimeout = 10 * time.Minute
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take 尝试从桶中取出一个令牌, 失败时返回需要等待的时间
+func (b *tokenBucket) take(rule *RateLimitRule, now time.Time) (bool, time.Duration) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(rule.Burst), b.tokens+elapsed.Seconds()*rule.Rate)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	if rule.Rate <= 0 {
+		return false, bucketIdleTimeout
+	}
+	return false, time.Duration((1 - b.tokens) / rule.Rate * float64(time.Second))
+}
+
+type rateLimiter struct {
+	lock      sync.Mutex
+	rule      *RateLimitRule
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func (r *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if now.Sub(r.lastSweep) > bucketIdleTimeout {
+		for k, b := range r.buckets {
+			if now.Sub(b.last) > bucketIdleTimeout {
+				delete(r.buckets, k)
+			}
+		}
+		r.lastSweep = now
+	}
+
+	bucket, ok := r.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(r.rule.Burst), last: now}
+		r.buckets[key] = bucket
+	}
+	return bucket.take(r.rule, now)
+}
+
+// SetRateLimit 设置 DefaultRouter 中命令的限流规则, rule 为nil时取消限流
+func SetRateLimit(name Name, rule *RateLimitRule) {
+	DefaultRouter.SetRateLimit(name, rule)
+}
+
+// SetRateLimit 设置命令的限流规则, 参数化命令使用注册时的名称, rule 为nil时取消限流
+func (r *Router) SetRateLimit(name Name, rule *RateLimitRule) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if rule == nil {
+		delete(r.rateLimiters, name)
+		return
+	}
+
+	r.rateLimiters[name] = &rateLimiter{
+		rule:      rule,
+		buckets:   map[string]*tokenBucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+// checkRateLimit 检查命令是否超出限流规则, 超出时返回 errors.ErrCodeTooManyRequests
+func (r *Router) checkRateLimit(request *Request) *transportstream.ErrInfo {
+	r.lock.RLock()
+	limiter, ok := r.rateLimiters[request.limitKey()]
+	r.lock.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	if allow, wait := limiter.allow(rateLimitKey(limiter.rule.Key, request.Session), time.Now()); !allow {
+		return errors.TooManyRequests(fmt.Sprintf("命令[%s]请求过于频繁, 请稍后再试", request.Name), wait)
+	}
+	return nil
+}
+
+// rateLimitKey 获取会话对应的限流维度值, 无会话时所有请求共享同一个令牌桶
+func rateLimitKey(key RateLimitKey, session *Session) string {
+	if session == nil {
+		return ""
+	}
+
+	switch key {
+	case RateLimitByUser:
+		if user := session.User(); user != "" {
+			return "user:" + user
+		}
+		fallthrough
+	case RateLimitByRemoteAddr:
+		return "addr:" + remoteHost(session)
+	default:
+		return "conn:" + strconv.FormatUint(session.ID(), 10)
+	}
+}
+
+func remoteHost(session *Session) string {
+	addr := session.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP.String()
+	default:
+		return addr.String()
+	}
+}
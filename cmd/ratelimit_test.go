@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/teamManagement/common/errors"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	a := assert.New(t)
+
+	rule := &RateLimitRule{Rate: 2, Burst: 2}
+	now := time.Now()
+	bucket := &tokenBucket{tokens: float64(rule.Burst), last: now}
+
+	for i := 0; i < rule.Burst; i++ {
+		ok, _ := bucket.take(rule, now)
+		a.True(ok)
+	}
+
+	ok, wait := bucket.take(rule, now)
+	a.False(ok)
+	a.Equal(500*time.Millisecond, wait)
+
+	ok, _ = bucket.take(rule, now.Add(wait))
+	a.True(ok)
+
+	ok, _ = bucket.take(rule, now.Add(time.Hour))
+	a.True(ok)
+	a.InDelta(float64(rule.Burst-1), bucket.tokens, 0.0001)
+}
+
+func TestRateLimiterKeys(t *testing.T) {
+	a := assert.New(t)
+
+	limiter := &rateLimiter{
+		rule:      &RateLimitRule{Rate: 1, Burst: 1},
+		buckets:   map[string]*tokenBucket{},
+		lastSweep: time.Now(),
+	}
+
+	now := time.Now()
+	ok, _ := limiter.allow("a", now)
+	a.True(ok)
+	ok, _ = limiter.allow("b", now)
+	a.True(ok)
+	ok, _ = limiter.allow("a", now)
+	a.False(ok)
+
+	ok, _ = limiter.allow("a", now.Add(bucketIdleTimeout*2))
+	a.True(ok)
+	a.Len(limiter.buckets, 1)
+}
+
+func TestRouterRateLimit(t *testing.T) {
+	a := assert.New(t)
+
+	limited, unlimited := NewRouter(), NewRouter()
+	limited.SetRateLimit("/team/list", &RateLimitRule{Rate: 1, Burst: 1})
+
+	request := &Request{Name: "/team/list", Pattern: "/team/list"}
+	a.Nil(limited.checkRateLimit(request))
+	if errInfo := limited.checkRateLimit(request); a.NotNil(errInfo) {
+		_, ok := errors.RetryAfter(errInfo)
+		a.True(ok)
+	}
+	for i := 0; i < 3; i++ {
+		a.Nil(unlimited.checkRateLimit(request))
+	}
+
+	limited.SetRateLimit("/team/list", nil)
+	a.Nil(limited.checkRateLimit(request))
+}
+
+func TestRateLimitNotFound(t *testing.T) {
+	a := assert.New(t)
+
+	router := NewRouter()
+	router.SetRateLimit("/team/unknown", &RateLimitRule{Rate: 1, Burst: 1})
+
+	// 未匹配到已注册命令时按命令名称区分限流
+	unknown := &Request{Name: "/team/unknown"}
+	a.Nil(router.checkRateLimit(unknown))
+	a.NotNil(router.checkRateLimit(unknown))
+	for i := 0; i < 3; i++ {
+		a.Nil(router.checkRateLimit(&Request{Name: "/team/other"}))
+	}
+}
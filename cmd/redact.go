@@ -0,0 +1,308 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/teamManagement/common/codec"
+	"github.com/teamManagement/common/record"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// RedactedMask 脱敏之后敏感字段的值, 无法解析的数据整体替换为该值
+	RedactedMask = "******"
+	// TagRedact 结构体字段的标签, 值为 true 时该字段为敏感字段, 例如 `json:"password" redact:"true"`
+	TagRedact = "redact"
+)
+
+var (
+	sensitiveLock   sync.RWMutex
+	sensitiveFields = map[Name][]string{}
+)
+
+func init() {
+	SetSensitiveFields(Login, "password")
+	SetSensitiveFields(Registry, "password")
+	SetSensitiveFields(Forgot, "password", "newPassword", "token", "resetToken")
+}
+
+// SetSensitiveFields 设置命令数据中的敏感字段, 数据被记录、审计或通过 Redact 输出至日志时敏感字段的值将被替换为 RedactedMask.
+// 不包含 . 的字段名匹配任意层级中同名的字段, 包含 . 的字段为从根开始的路径, 均忽略大小写, 数组不占用路径层级;
+// 参数化命令使用注册时的名称, fields 为空时删除命令的敏感字段
+func SetSensitiveFields(name Name, fields ...string) {
+	sensitiveLock.Lock()
+	defer sensitiveLock.Unlock()
+
+	if len(fields) == 0 {
+		delete(sensitiveFields, name)
+		return
+	}
+	sensitiveFields[name] = append([]string(nil), fields...)
+}
+
+// addSensitiveFields 追加命令的敏感字段, 已存在的字段不重复添加
+func addSensitiveFields(name Name, fields ...string) {
+	if len(fields) == 0 {
+		return
+	}
+
+	sensitiveLock.Lock()
+	defer sensitiveLock.Unlock()
+
+	existing := sensitiveFields[name]
+	for _, field := range fields {
+		found := false
+		for _, e := range existing {
+			if strings.EqualFold(e, field) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			existing = append(existing, field)
+		}
+	}
+	sensitiveFields[name] = existing
+}
+
+// SensitiveFields 获取命令的敏感字段, 包括 SetSensitiveFields 设置的字段及 Describe 记录的类型中带有 redact 标签的字段
+func SensitiveFields(names ...Name) []string {
+	sensitiveLock.RLock()
+	defer sensitiveLock.RUnlock()
+
+	var fields []string
+	for _, name := range names {
+		fields = append(fields, sensitiveFields[name]...)
+	}
+	return fields
+}
+
+// SensitiveFieldsOf 获取类型中带有 redact 标签的字段路径, 字段名按照 encoding/json 的规则确定
+func SensitiveFieldsOf(v any) []string {
+	if v == nil {
+		return nil
+	}
+
+	var fields []string
+	collectSensitiveFields(reflect.TypeOf(v), "", map[reflect.Type]bool{}, &fields)
+	sort.Strings(fields)
+	return fields
+}
+
+func collectSensitiveFields(t reflect.Type, prefix string, visiting map[reflect.Type]bool, fields *[]string) {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || visiting[t] {
+		return
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			collectSensitiveFields(field.Type, prefix, visiting, fields)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		path := prefix + name
+		if field.Tag.Get(TagRedact) == "true" {
+			*fields = append(*fields, path)
+			continue
+		}
+		collectSensitiveFields(field.Type, path+".", visiting, fields)
+	}
+}
+
+// Redact 按命令的敏感字段对数据脱敏, 用于输出日志, 数据中没有敏感字段时原样返回,
+// 命令设置了敏感字段但数据无法使用 codecName 解析时返回 RedactedMask
+func Redact(name Name, codecName string, data []byte) []byte {
+	return redactPayload(name, SensitiveFields(name), codecName, data)
+}
+
+// Redact 按本次命令的敏感字段对数据脱敏, 用于在 Handler 或中间件中输出日志
+func (r *Request) Redact(data []byte) []byte {
+	return redactPayload(r.Name, r.sensitiveFields(), r.Codec().Name(), data)
+}
+
+func (r *Request) sensitiveFields() []string {
+	if r.Pattern == "" || r.Pattern == r.Name {
+		return SensitiveFields(r.Name)
+	}
+	return SensitiveFields(r.Pattern, r.Name)
+}
+
+// redactPayload 对命令的数据脱敏, 批量执行的请求按其中每条命令的敏感字段分别脱敏
+func redactPayload(name Name, fields []string, codecName string, data []byte) []byte {
+	if name == Batch {
+		return redactBatch(data)
+	}
+	return redactFields(fields, codecName, data)
+}
+
+// redactBatch 对批量执行请求中每条命令的数据脱敏, 不是批量执行请求的数据原样返回
+func redactBatch(data []byte) []byte {
+	var req *BatchRequest
+	if err := json.Unmarshal(data, &req); err != nil || req == nil || len(req.Items) == 0 {
+		return data
+	}
+
+	changed := false
+	for _, item := range req.Items {
+		if item == nil {
+			continue
+		}
+		redacted := Redact(item.Name, item.Codec, item.Data)
+		if !bytes.Equal(redacted, item.Data) {
+			item.Data = redacted
+			changed = true
+		}
+	}
+	if !changed {
+		return data
+	}
+
+	marshal, err := json.Marshal(req)
+	if err != nil {
+		return []byte(RedactedMask)
+	}
+	return marshal
+}
+
+func redactFields(fields []string, codecName string, data []byte) []byte {
+	if len(fields) == 0 || len(data) == 0 {
+		return data
+	}
+
+	var v any
+	if err := codec.Unmarshal(codecName, data, &v); err != nil {
+		return []byte(RedactedMask)
+	}
+
+	redacted, changed := redactValue(v, nil, fields)
+	if !changed {
+		return data
+	}
+
+	marshal, err := codec.Marshal(codecName, redacted)
+	if err != nil {
+		return []byte(RedactedMask)
+	}
+	return marshal
+}
+
+// redactValue 递归替换敏感字段的值, path 为当前值的字段路径
+func redactValue(v any, path []string, fields []string) (any, bool) {
+	changed := false
+	switch value := v.(type) {
+	case map[string]any:
+		for k, item := range value {
+			var c bool
+			value[k], c = redactField(k, item, path, fields)
+			changed = changed || c
+		}
+	case map[any]any:
+		for k, item := range value {
+			key, ok := k.(string)
+			if !ok {
+				key = fmt.Sprint(k)
+			}
+			var c bool
+			value[k], c = redactField(key, item, path, fields)
+			changed = changed || c
+		}
+	case []any:
+		for i, item := range value {
+			var c bool
+			value[i], c = redactValue(item, path, fields)
+			changed = changed || c
+		}
+	}
+	return v, changed
+}
+
+func redactField(key string, value any, path []string, fields []string) (any, bool) {
+	path = append(path[:len(path):len(path)], key)
+	if isSensitiveField(path, fields) {
+		return RedactedMask, true
+	}
+	return redactValue(value, path, fields)
+}
+
+func isSensitiveField(path []string, fields []string) bool {
+	for _, field := range fields {
+		if strings.Contains(field, ".") {
+			if strings.EqualFold(field, strings.Join(path, ".")) {
+				return true
+			}
+			continue
+		}
+		if strings.EqualFold(field, path[len(path)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordRedactor 单个流的记录脱敏器, 从客户端发送的第一条消息中解析命令, 之后按命令的敏感字段对数据消息脱敏
+type recordRedactor struct {
+	router    *Router
+	parsed    bool
+	name      Name
+	fields    []string
+	codecName string
+}
+
+// NewRecordRedactor 创建按命令敏感字段脱敏的 record.Redactor, router 用于匹配参数化命令, 可以为nil
+func NewRecordRedactor(router *Router) func() record.Redactor {
+	return func() record.Redactor {
+		return &recordRedactor{router: router}
+	}
+}
+
+func (r *recordRedactor) Redact(entry *record.Entry) {
+	if entry.Flag == record.FlagRaw || transportstream.MsgFlag(entry.Flag) == transportstream.MsgFlagErr {
+		return
+	}
+
+	if !r.parsed {
+		if !entry.FromClient() {
+			return
+		}
+		r.parsed = true
+		name, header, err := decodeCommand(entry.Payload)
+		if err != nil {
+			return
+		}
+		names := []Name{name}
+		if r.router != nil {
+			if routeEntry, _, ok := r.router.match(name); ok && routeEntry.pattern != name {
+				names = append(names, routeEntry.pattern)
+			}
+		}
+		r.name = name
+		r.fields = SensitiveFields(names...)
+		r.codecName = header.Get(HeaderContentType)
+		return
+	}
+
+	entry.Payload = redactPayload(r.name, r.fields, r.codecName, entry.Payload)
+}
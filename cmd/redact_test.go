@@ -0,0 +1,162 @@
+package cmd_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/teamManagement/common/audit"
+	"github.com/teamManagement/common/cmd"
+	"github.com/teamManagement/common/cmdtest"
+	"github.com/teamManagement/common/codec"
+	"github.com/teamManagement/common/conn"
+	"github.com/teamManagement/common/record"
+	"net"
+	"sync"
+	"testing"
+)
+
+type inviteRequest struct {
+	TeamID  string `json:"teamId"`
+	Invitee struct {
+		Email string `json:"email" redact:"true"`
+		Name  string `json:"name"`
+	} `json:"invitee"`
+	Secrets []struct {
+		Value string `json:"value" redact:"true"`
+	} `json:"secrets,omitempty"`
+}
+
+type inviteResponse struct {
+	Link string `redact:"true"`
+}
+
+func TestRedact(t *testing.T) {
+	a := assert.New(t)
+
+	a.JSONEq(`{"username":"alice","password":"******"}`,
+		string(cmd.Redact(cmd.Login, codec.JSON, []byte(`{"username":"alice","password":"secret"}`))))
+	a.JSONEq(`{"email":"alice@example.com","token":"******","newPassword":"******"}`,
+		string(cmd.Redact(cmd.Forgot, codec.JSON, []byte(`{"email":"alice@example.com","token":"abc","newPassword":"secret"}`))))
+
+	raw := []byte(`{"name":"core"}`)
+	a.Equal(raw, cmd.Redact("/team/create", codec.JSON, raw))
+	a.Equal(raw, cmd.Redact(cmd.Login, codec.JSON, raw))
+	a.Equal(cmd.RedactedMask, string(cmd.Redact(cmd.Login, codec.JSON, []byte("not json"))))
+
+	cmd.SetSensitiveFields("/team/settings", "webhook.secret", "apiKey")
+	defer cmd.SetSensitiveFields("/team/settings")
+	a.JSONEq(`{"secret":"kept","webhook":{"secret":"******"},"hooks":[{"apiKey":"******"}]}`,
+		string(cmd.Redact("/team/settings", codec.JSON, []byte(`{"secret":"kept","webhook":{"secret":"s"},"hooks":[{"apiKey":"k"}]}`))))
+
+	packed, err := codec.Marshal(codec.Msgpack, map[string]string{"username": "alice", "password": "secret"})
+	a.NoError(err)
+	var unpacked map[string]string
+	a.NoError(codec.Unmarshal(codec.Msgpack, cmd.Redact(cmd.Login, codec.Msgpack, packed), &unpacked))
+	a.Equal(map[string]string{"username": "alice", "password": cmd.RedactedMask}, unpacked)
+
+	a.Equal([]string{"invitee.email", "secrets.value"}, cmd.SensitiveFieldsOf(&inviteRequest{}))
+
+	router := cmd.NewRouter()
+	cmd.HandleTyped(router, "/team/:teamId/invite", func(request *cmd.Request, in *inviteRequest) (*inviteResponse, error) {
+		return &inviteResponse{Link: "https://example.com/join/" + in.Invitee.Email}, nil
+	}, nil)
+	defer cmd.SetSensitiveFields("/team/:teamId/invite")
+	a.JSONEq(`{"teamId":"1","invitee":{"email":"******","name":"bob"},"secrets":[{"value":"******"}]}`,
+		string(cmd.Redact("/team/:teamId/invite", codec.JSON, []byte(`{"teamId":"1","invitee":{"email":"bob@example.com","name":"bob"},"secrets":[{"value":"v"}]}`))))
+	a.JSONEq(`{"Link":"******"}`, string(cmd.Redact("/team/:teamId/invite", codec.JSON, []byte(`{"Link":"https://example.com"}`))))
+}
+
+func TestRedactRecordAndAudit(t *testing.T) {
+	a := assert.New(t)
+
+	router := cmd.NewRouter()
+	router.Handle(cmd.Login, func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		return stream.ReceiveMsg()
+	})
+	cmd.HandleTyped(router, "/team/:teamId/invite", func(request *cmd.Request, in *inviteRequest) (*inviteResponse, error) {
+		return &inviteResponse{Link: "https://example.com/join"}, nil
+	}, nil)
+	defer cmd.SetSensitiveFields("/team/:teamId/invite")
+
+	buf := &bytes.Buffer{}
+	rec := record.NewRecorder(buf)
+	router.SetRecorder(rec)
+	var (
+		lock    sync.Mutex
+		records []*audit.Record
+	)
+	router.SetAuditSink(audit.SinkFunc(func(record *audit.Record) error {
+		lock.Lock()
+		defer lock.Unlock()
+		records = append(records, record)
+		return nil
+	}))
+
+	login := map[string]string{"username": "alice", "password": "secret-password"}
+	_, err := cmdtest.Call(t, router, cmd.Login, login)
+	a.NoError(err)
+	_, err = cmdtest.Call(t, router, "/team/1/invite", map[string]any{"invitee": map[string]string{"email": "bob@example.com"}})
+	a.NoError(err)
+
+	batch := cmd.NewBatchRequest()
+	a.NoError(batch.Add(cmd.Login, login))
+	_, err = cmdtest.Call(t, router, cmd.Batch, batch)
+	a.NoError(err)
+
+	a.NoError(rec.Close())
+	a.NotContains(buf.String(), "secret-password")
+	a.NotContains(buf.String(), "bob@example.com")
+	entries, err := record.Decode(buf)
+	a.NoError(err)
+	a.NotEmpty(entries)
+	for _, entry := range entries {
+		a.NotContains(string(entry.Payload), "secret-password")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if a.Len(records, 4) {
+		redacted := sha256.Sum256([]byte(`{"password":"******","username":"alice"}`))
+		a.Equal(hex.EncodeToString(redacted[:]), records[0].PayloadDigest)
+	}
+}
+
+func TestRedactWrapperRecord(t *testing.T) {
+	a := assert.New(t)
+
+	buf := &bytes.Buffer{}
+	rec := record.NewRecorder(buf)
+	rec.SetRedactor(cmd.NewRecordRedactor(nil))
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+	client := conn.NewWrapper(clientConn).SetRecorder(rec, record.SideClient)
+	server := conn.NewWrapper(serverConn)
+
+	login := map[string]string{"username": "alice", "password": "secret-password"}
+	received := make(chan map[string]string, 1)
+	go func() {
+		var res map[string]string
+		if _, err := server.ReadeFormatBytesData(); err == nil {
+			_ = server.ReadFormatJsonData(&res)
+		}
+		received <- res
+	}()
+	a.NoError(client.WriteFormatBytesData([]byte(cmd.Login)).WriteFormatJsonData(login).Error())
+	// 对端收到的数据不受脱敏影响
+	a.Equal(login, <-received)
+
+	a.NoError(rec.Close())
+	entries, err := record.Decode(buf)
+	if !a.NoError(err) || !a.Len(entries, 2) {
+		return
+	}
+	var messageInfo *conn.MessageInfo
+	a.NoError(json.Unmarshal(entries[1].Payload, &messageInfo))
+	a.JSONEq(`{"password":"******","username":"alice"}`, string(messageInfo.Data))
+}
@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/teamManagement/common/compress"
+	"github.com/teamManagement/common/internal/memstream"
+	"github.com/teamManagement/common/record"
+	"io"
+	"time"
+)
+
+// DefaultReplayTimeout 回放单条流时等待服务端处理完成的默认时间
+const DefaultReplayTimeout = 10 * time.Second
+
+// ReplayResult 一条流的回放结果
+type ReplayResult struct {
+	// Stream 记录中的流编号
+	Stream uint64
+	// Command 流上执行的命令
+	Command Name
+	// Recorded 记录中服务端发送的消息
+	Recorded []*record.Entry
+	// Replayed 回放时服务端发送的消息
+	Replayed []*record.Entry
+	// Err 回放失败的原因
+	Err error
+}
+
+// Matched 回放时服务端发送的消息与记录是否一致
+func (r *ReplayResult) Matched() bool {
+	if r.Err != nil || len(r.Recorded) != len(r.Replayed) {
+		return false
+	}
+	for i, recorded := range r.Recorded {
+		replayed := r.Replayed[i]
+		if recorded.Flag != replayed.Flag || !bytes.Equal(recorded.Payload, replayed.Payload) {
+			return false
+		}
+	}
+	return true
+}
+
+// Replay 按流将记录中客户端发送的消息依次重新发送至 router, 流之间串行执行且不属于任何会话.
+// 记录可以来自 Router.SetRecorder 或客户端使用 record.Recorder 包装的流, 回放时不协商压缩,
+// 以便直接比较服务端发送的消息. timeout 为单条流的最长处理时间, 为0时使用 DefaultReplayTimeout
+func Replay(router *Router, entries []*record.Entry, timeout time.Duration) []*ReplayResult {
+	return ReplayTo(func() (quic.Stream, error) {
+		client, server := memstream.Pipe()
+		go func() {
+			_ = router.serveTransport(server, nil, newStreamTransport(server, nil))
+		}()
+		return client, nil
+	}, entries, timeout)
+}
+
+// ReplayTo 与 Replay 相同, 但将记录中的每条流发送至 dial 建立的流, 用于回放至远程服务端, 流使用完毕之后将被关闭
+func ReplayTo(dial func() (quic.Stream, error), entries []*record.Entry, timeout time.Duration) []*ReplayResult {
+	if timeout <= 0 {
+		timeout = DefaultReplayTimeout
+	}
+
+	groups := record.GroupByStream(entries)
+	results := make([]*ReplayResult, 0, len(groups))
+	for _, group := range groups {
+		results = append(results, replayStream(dial, group, timeout))
+	}
+	return results
+}
+
+func replayStream(dial func() (quic.Stream, error), entries []*record.Entry, timeout time.Duration) *ReplayResult {
+	result := &ReplayResult{Stream: entries[0].Stream}
+
+	var clientMessages []*record.Entry
+	for _, entry := range entries {
+		if entry.FromClient() {
+			clientMessages = append(clientMessages, entry)
+		} else {
+			result.Recorded = append(result.Recorded, entry)
+		}
+	}
+	if len(clientMessages) == 0 {
+		result.Err = fmt.Errorf("流[%d]中没有客户端发送的消息", result.Stream)
+		return result
+	}
+
+	name, header, err := decodeCommand(clientMessages[0].Payload)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Command = name
+	header.Del(HeaderAcceptEncoding)
+	cmdBytes, err := encodeCommand(name, header)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	var compressor compress.Compressor
+	if len(result.Recorded) > 0 {
+		if compressor, result.Recorded[0], err = normalizeRecordedAck(result.Recorded[0]); err != nil {
+			result.Err = err
+			return result
+		}
+	}
+
+	// 客户端记录的数据消息在协商压缩之后带有压缩标识, 服务端记录的为解压之后的消息
+	if compressor != nil && entries[0].Side == record.SideClient {
+		for i, entry := range result.Recorded[1:] {
+			if result.Recorded[i+1], err = decodeRecordedPayload(compressor, entry); err != nil {
+				result.Err = err
+				return result
+			}
+		}
+		for i, entry := range clientMessages[1:] {
+			if clientMessages[i+1], err = decodeRecordedPayload(compressor, entry); err != nil {
+				result.Err = err
+				return result
+			}
+		}
+	}
+
+	client, err := dial()
+	if err != nil {
+		result.Err = fmt.Errorf("建立回放的流失败: %s", err.Error())
+		return result
+	}
+	defer client.Close()
+
+	stream := transportstream.NewStream(bufio.NewReadWriter(bufio.NewReader(client), bufio.NewWriter(client)))
+	for i, entry := range clientMessages {
+		payload := entry.Payload
+		if i == 0 {
+			payload = cmdBytes
+		}
+		if err = stream.WriteMsg(payload, transportstream.MsgFlag(entry.Flag)); err != nil {
+			result.Err = err
+			return result
+		}
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		frame, err := readFrame(client)
+		if err == io.EOF {
+			return result
+		}
+		if err != nil {
+			result.Err = fmt.Errorf("读取回放结果失败: %s", err.Error())
+			return result
+		}
+		if len(frame) == 0 {
+			continue
+		}
+
+		result.Replayed = append(result.Replayed, &record.Entry{
+			Stream:    result.Stream,
+			Side:      record.SideServer,
+			Direction: record.DirectionSend,
+			Time:      time.Now(),
+			Flag:      frame[0],
+			Payload:   frame[1:],
+		})
+	}
+}
+
+// normalizeRecordedAck 移除记录的命令确认消息中的压缩协商结果, 并返回记录时协商的压缩算法
+func normalizeRecordedAck(ack *record.Entry) (compress.Compressor, *record.Entry, error) {
+	if transportstream.MsgFlag(ack.Flag) != transportstream.MsgFlagSuccess {
+		return nil, ack, nil
+	}
+
+	header, err := decodeHeader(ack.Payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	contentEncoding := header.Get(HeaderContentEncoding)
+	if contentEncoding == "" {
+		return nil, ack, nil
+	}
+
+	compressor, ok := compress.Get(contentEncoding)
+	if !ok {
+		return nil, nil, fmt.Errorf("记录中使用了不支持的压缩算法: %s", contentEncoding)
+	}
+
+	// 与 writeAck 一致, 响应头为空时确认消息不携带内容
+	normalized := *ack
+	normalized.Payload = nil
+	if header.Del(HeaderContentEncoding); len(header) > 0 {
+		if normalized.Payload, err = json.Marshal(header); err != nil {
+			return nil, nil, err
+		}
+	}
+	return compressor, &normalized, nil
+}
+
+func decodeRecordedPayload(c compress.Compressor, entry *record.Entry) (*record.Entry, error) {
+	if !isPayloadFlag(entry.Flag) {
+		return entry, nil
+	}
+
+	payload, err := decodePayload(c, entry.Payload, 0)
+	if err != nil {
+		return nil, err
+	}
+	decoded := *entry
+	decoded.Payload = payload
+	return &decoded, nil
+}
@@ -0,0 +1,91 @@
+package cmd_test
+
+import (
+	"bytes"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/teamManagement/common/cmd"
+	"github.com/teamManagement/common/cmdtest"
+	"github.com/teamManagement/common/record"
+	"strings"
+	"testing"
+)
+
+func echoRouter() *cmd.Router {
+	router := cmd.NewRouter()
+	router.Handle("/echo", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		return stream.ReceiveMsg()
+	})
+	return router
+}
+
+func TestReplayServerRecording(t *testing.T) {
+	a := assert.New(t)
+
+	buf := &bytes.Buffer{}
+	router := echoRouter()
+	router.SetRecorder(record.NewRecorder(buf))
+
+	msg := strings.Repeat("team", 1024)
+	res, err := cmdtest.CallWithOption(t, router, "/echo", &cmd.ExchangeOption{Data: msg, Compress: true})
+	a.NoError(err)
+	var str string
+	a.NoError(res.UnmarshalJson(&str))
+	a.Equal(msg, str)
+	router.SetRecorder(nil)
+
+	entries, err := record.Decode(buf)
+	if !a.NoError(err) {
+		return
+	}
+
+	results := cmd.Replay(router, entries, 0)
+	if a.Len(results, 1) {
+		a.NoError(results[0].Err)
+		a.Equal(cmd.Name("/echo"), results[0].Command)
+		a.True(results[0].Matched())
+	}
+}
+
+func TestReplayClientRecording(t *testing.T) {
+	a := assert.New(t)
+
+	buf := &bytes.Buffer{}
+	rec := record.NewRecorder(buf)
+	router := echoRouter()
+
+	client, server := cmdtest.Pipe()
+	go func() {
+		_ = router.ServeStream(server)
+	}()
+	msg := strings.Repeat("team", 1024)
+	_, err := cmd.Name("/echo").ExchangeWithOption(rec.NewTransportStream(client, record.SideClient), &cmd.ExchangeOption{
+		Data:     msg,
+		Compress: true,
+	})
+	a.NoError(err)
+
+	entries, err := record.Decode(buf)
+	if !a.NoError(err) {
+		return
+	}
+
+	results := cmd.Replay(router, entries, 0)
+	if a.Len(results, 1) {
+		a.True(results[0].Matched())
+	}
+
+	// 修改服务端行为之后回放结果不再一致
+	router.Handle("/echo", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		if _, err := stream.ReceiveMsg(); err != nil {
+			return nil, err
+		}
+		return cmd.NewExchangeDataByStr("changed"), nil
+	})
+	results = cmd.Replay(router, entries, 0)
+	if a.Len(results, 1) {
+		a.NoError(results[0].Err)
+		a.False(results[0].Matched())
+	}
+}
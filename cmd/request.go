@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"context"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/teamManagement/common/codec"
+	"sync"
+)
+
+// Request 一次命令交换的上下文信息
+type Request struct {
+	// Name 命令名称
+	Name Name
+	// Pattern 匹配到的已注册命令, 参数化命令为注册时的名称, 未匹配到时为空
+	Pattern Name
+	// Params 参数化命令中解析出的参数
+	Params map[string]string
+	// Session 命令所属的会话, 未通过 ServeConn 处理时为nil
+	Session *Session
+	// Header 请求头
+	Header Header
+	// ResponseHeader 响应头, 随命令确认消息发送, 在 Handler 中修改不会生效
+	ResponseHeader Header
+
+	ctx            context.Context
+	codec          codec.Codec
+	router         *Router
+	nested         bool
+	writeLock      sync.Mutex
+	progressStream *transportstream.Stream
+}
+
+// Context 本次命令的 context, 客户端取消交换或 Handler 返回之后被取消
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// cancelled 本次命令是否已被客户端取消
+func (r *Request) cancelled() bool {
+	return r.ctx != nil && r.ctx.Err() != nil
+}
+
+// limitKey 限流、并发及数据大小限制使用的命令名称, 未匹配到已注册命令时使用命令名称,
+// 避免所有由 NotFound 处理的命令共享同一个限制
+func (r *Request) limitKey() Name {
+	if r.Pattern == "" {
+		return r.Name
+	}
+	return r.Pattern
+}
+
+// WriteLocked 持有本次命令的写锁执行 fn, 在其他 goroutine 中调用 ReportProgress 时, Handler 向流中写入数据需要通过该方法进行
+func (r *Request) WriteLocked(fn func() error) error {
+	r.writeLock.Lock()
+	defer r.writeLock.Unlock()
+	return fn()
+}
+
+// stopProgress Handler 返回之后停止发送进度消息, 等待正在发送的进度消息写入完成
+func (r *Request) stopProgress() {
+	r.writeLock.Lock()
+	defer r.writeLock.Unlock()
+	r.progressStream = nil
+}
+
+// Param 获取参数化命令中的参数, 不存在时返回空字符串
+func (r *Request) Param(name string) string {
+	return r.Params[name]
+}
+
+// Codec 本次命令协商的编解码器
+func (r *Request) Codec() codec.Codec {
+	if r.codec == nil {
+		c, _ := codec.Get(codec.JSON)
+		return c
+	}
+	return r.codec
+}
+
+// Unmarshal 使用本次命令协商的编解码器解码客户端发送的数据
+func (r *Request) Unmarshal(data []byte, v any) error {
+	return ExchangeData(data).UnmarshalCodec(r.Codec().Name(), v)
+}
+
+// Marshal 使用本次命令协商的编解码器编码返回给客户端的数据
+func (r *Request) Marshal(v any) (ExchangeData, error) {
+	return NewExchangeDataByCodec(r.Codec().Name(), v)
+}
+
+// requestStream 携带命令上下文的 quic.Stream, 传递给 Handler
+type requestStream struct {
+	quic.Stream
+	request *Request
+}
+
+// RequestOf 从 Handler 收到的 quic.Stream 中获取本次命令的上下文信息
+func RequestOf(quicStream quic.Stream) *Request {
+	if s, ok := quicStream.(*requestStream); ok {
+		return s.request
+	}
+	return &Request{Header: Header{}, ResponseHeader: Header{}}
+}
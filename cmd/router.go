@@ -0,0 +1,356 @@
+package cmd
+
+import (
+	"fmt"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/teamManagement/common/audit"
+	"github.com/teamManagement/common/record"
+	"strings"
+	"sync"
+)
+
+// Middleware 命令处理中间件, 包装 next 并返回新的处理函数
+type Middleware func(next Handler) Handler
+
+// Registrar 可以注册命令的路由表或分组, 由 protoc-gen-teamcmd 生成的代码使用
+type Registrar interface {
+	Handle(name Name, handle Handler)
+	Describe(name Name, spec *CommandSpec)
+}
+
+var (
+	_ Registrar = (*Router)(nil)
+	_ Registrar = (*Group)(nil)
+)
+
+// routeEntry 已注册的命令
+type routeEntry struct {
+	pattern Name
+	handle  Handler
+	group   *Group
+}
+
+// routeMatcher 命令名称的匹配表, 静态命令按名称直接查找, 参数化命令通过路由树匹配, 本身不加锁
+type routeMatcher struct {
+	handlers map[Name]*routeEntry
+	tree     *routeNode
+}
+
+func newRouteMatcher() *routeMatcher {
+	return &routeMatcher{
+		handlers: map[Name]*routeEntry{},
+		tree:     &routeNode{},
+	}
+}
+
+// routeNode 参数化命令的路由树节点, 按 / 分隔的路径段逐级匹配
+type routeNode struct {
+	children map[string]*routeNode
+
+	param     *routeNode
+	paramName string
+
+	wildcard     *routeEntry
+	wildcardName string
+
+	entry *routeEntry
+}
+
+// Router 命令路由表, 负责将命令分发至对应的 Handler.
+// 命令名称中以 : 开头的路径段为参数, 例如 /team/:teamId/member/list;
+// 以 * 开头的路径段匹配剩余的所有路径, 只能作为最后一段, 例如 /file/*path
+type Router struct {
+	lock        sync.RWMutex
+	routes      *routeMatcher
+	middlewares []Middleware
+	notFound    Handler
+
+	aliases        map[Name]Name
+	deprecations   map[Name]*Deprecation
+	deprecatedHook DeprecatedCallHook
+
+	recorder       *record.Recorder
+	auditSink      audit.Sink
+	auditErrorHook AuditErrorHook
+	introspection  bool
+	specs          map[Name]*CommandSpec
+
+	rateLimiters       map[Name]*rateLimiter
+	bulkheads          map[Name]*bulkhead
+	connConcurrency    *ConcurrencyLimit
+	payloadSizes       map[Name]int64
+	defaultPayloadSize int64
+
+	healthCheckers map[string]HealthChecker
+	draining       int32
+
+	maxBatchSize     int
+	maxBatchParallel int
+
+	jobManager *JobManager
+
+	sessions            map[uint64]*Session
+	adminAuthorizer     AdminAuthorizer
+	subscribeAuthorizer SubscribeAuthorizer
+}
+
+// DefaultRouter 默认的路由表, Name.Registry、Route 及 ServeConn 均使用该路由表
+var DefaultRouter = NewRouter()
+
+// NewRouter 创建一个空的路由表
+func NewRouter() *Router {
+	return &Router{
+		routes:   newRouteMatcher(),
+		sessions: map[uint64]*Session{},
+
+		healthCheckers: map[string]HealthChecker{},
+
+		maxBatchSize:     DefaultMaxBatchSize,
+		maxBatchParallel: DefaultMaxBatchParallel,
+
+		rateLimiters:       map[Name]*rateLimiter{},
+		bulkheads:          map[Name]*bulkhead{},
+		payloadSizes:       map[Name]int64{},
+		defaultPayloadSize: DefaultMaxPayloadSize,
+	}
+}
+
+// Handle 注册命令的处理函数, 同名命令将被覆盖
+func (r *Router) Handle(name Name, handle Handler) {
+	r.handle(name, handle, nil)
+}
+
+// Use 添加对所有命令生效的中间件, 内置命令除外, 先添加的中间件先执行
+func (r *Router) Use(middlewares ...Middleware) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+// NotFound 设置命令未注册时的处理函数, 未设置时返回 errors.ErrCodeCommandUndefined
+func (r *Router) NotFound(handle Handler) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.notFound = handle
+}
+
+// Group 创建命令分组, 分组内注册的命令自动添加前缀并共享分组的中间件
+func (r *Router) Group(prefix Name, middlewares ...Middleware) *Group {
+	return &Group{
+		router:      r,
+		prefix:      prefix,
+		middlewares: middlewares,
+	}
+}
+
+// SetRecorder 记录 ServeConn 及 ServeStream 处理的每条流上的消息, 记录的内容为解压之后的消息, rec 为nil时停止记录
+func (r *Router) SetRecorder(rec *record.Recorder) {
+	if rec != nil {
+		rec.SetRedactor(NewRecordRedactor(r))
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.recorder = rec
+}
+
+func (r *Router) currentRecorder() *record.Recorder {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.recorder
+}
+
+func (r *Router) handle(name Name, handle Handler, group *Group) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.routes.add(&routeEntry{
+		pattern: name,
+		handle:  handle,
+		group:   group,
+	})
+}
+
+// add 添加命令, 同名命令将被覆盖, 参数名称冲突或通配符不在最后一段时 panic
+func (m *routeMatcher) add(entry *routeEntry) {
+	name := entry.pattern
+	segments := splitName(name)
+	if !isPattern(segments) {
+		m.handlers[name] = entry
+		return
+	}
+
+	node := m.tree
+	for i, segment := range segments {
+		switch {
+		case strings.HasPrefix(segment, ":"):
+			if node.param == nil {
+				node.param = &routeNode{}
+				node.paramName = segment[1:]
+			} else if node.paramName != segment[1:] {
+				panic(fmt.Sprintf("命令[%s]的参数[%s]与已注册的参数[%s]冲突", name, segment[1:], node.paramName))
+			}
+			node = node.param
+		case strings.HasPrefix(segment, "*"):
+			if i != len(segments)-1 {
+				panic(fmt.Sprintf("命令[%s]的通配符只能位于最后一段", name))
+			}
+			node.wildcard = entry
+			node.wildcardName = segment[1:]
+			return
+		default:
+			if node.children == nil {
+				node.children = map[string]*routeNode{}
+			}
+			child, ok := node.children[segment]
+			if !ok {
+				child = &routeNode{}
+				node.children[segment] = child
+			}
+			node = child
+		}
+	}
+	node.entry = entry
+}
+
+// match 解析别名之后查找命令对应的注册信息
+func (r *Router) match(name Name) (*routeEntry, map[string]string, bool) {
+	name = r.resolveAlias(name)
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.routes.match(name)
+}
+
+// match 查找命令对应的注册信息, 静态命令优先于参数化命令
+func (m *routeMatcher) match(name Name) (*routeEntry, map[string]string, bool) {
+	if entry, ok := m.handlers[name]; ok {
+		return entry, map[string]string{}, true
+	}
+
+	params := map[string]string{}
+	if entry := m.tree.match(splitName(name), params); entry != nil {
+		return entry, params, true
+	}
+	return nil, nil, false
+}
+
+func (n *routeNode) match(segments []string, params map[string]string) *routeEntry {
+	if len(segments) == 0 {
+		return n.entry
+	}
+
+	if child, ok := n.children[segments[0]]; ok {
+		if entry := child.match(segments[1:], params); entry != nil {
+			return entry
+		}
+	}
+
+	if n.param != nil {
+		if entry := n.param.match(segments[1:], params); entry != nil {
+			params[n.paramName] = segments[0]
+			return entry
+		}
+	}
+
+	if n.wildcard != nil {
+		params[n.wildcardName] = strings.Join(segments, "/")
+		return n.wildcard
+	}
+	return nil
+}
+
+// handler 获取经过中间件包装之后的处理函数
+func (r *Router) handler(entry *routeEntry) Handler {
+	r.lock.RLock()
+	middlewares := append([]Middleware(nil), r.middlewares...)
+	r.lock.RUnlock()
+
+	if entry.group != nil {
+		middlewares = append(middlewares, entry.group.middlewareList()...)
+	}
+
+	handle := entry.handle
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handle = middlewares[i](handle)
+	}
+	return handle
+}
+
+func (r *Router) notFoundHandler() (Handler, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.notFound, r.notFound != nil
+}
+
+// Route 处理流上的一次命令交换, quicStream 不为nil时在其之上构建与 ServeStream 相同的传输层,
+// 支持数据大小限制、透明压缩及取消, 此时不再使用 stream, 调用方在此之前不能从 stream 中读取数据, 流由调用方关闭;
+// quicStream 为nil时直接在 stream 上交换, 不支持上述功能, 仅用于测试等可信的对端
+func (r *Router) Route(stream *transportstream.Stream, quicStream quic.Stream) error {
+	if quicStream == nil {
+		return r.route(stream, nil, nil, nil)
+	}
+	return r.routeTransport(quicStream, nil, newStreamTransport(quicStream, r.currentRecorder()))
+}
+
+// Group 命令分组
+type Group struct {
+	router *Router
+	parent *Group
+	prefix Name
+
+	lock        sync.RWMutex
+	middlewares []Middleware
+}
+
+// Group 创建子分组, 子分组的前缀及中间件在父分组的基础上追加
+func (g *Group) Group(prefix Name, middlewares ...Middleware) *Group {
+	return &Group{
+		router:      g.router,
+		parent:      g,
+		prefix:      g.prefix + prefix,
+		middlewares: middlewares,
+	}
+}
+
+// Use 添加分组的中间件, 对分组内已注册及之后注册的命令均生效
+func (g *Group) Use(middlewares ...Middleware) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.middlewares = append(g.middlewares, middlewares...)
+}
+
+// Handle 在分组内注册命令, 命令名称为分组前缀与 name 的拼接
+func (g *Group) Handle(name Name, handle Handler) {
+	g.router.handle(g.prefix+name, handle, g)
+}
+
+// middlewareList 分组自身的中间件, 父分组的中间件先于子分组执行
+func (g *Group) middlewareList() []Middleware {
+	var parent []Middleware
+	if g.parent != nil {
+		parent = g.parent.middlewareList()
+	}
+
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return append(parent, g.middlewares...)
+}
+
+func splitName(name Name) []string {
+	trimmed := strings.Trim(string(name), "/")
+	if trimmed == "" {
+		return nil
+	}
+	return strings.Split(trimmed, "/")
+}
+
+func isPattern(segments []string) bool {
+	for _, segment := range segments {
+		if segment != "" && (segment[0] == ':' || segment[0] == '*') {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package cmd_test
+
+import (
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/teamManagement/common/cmd"
+	"github.com/teamManagement/common/cmdtest"
+	"github.com/teamManagement/common/errors"
+	"testing"
+	"time"
+)
+
+// patternHandler 返回匹配到的命令及参数
+func patternHandler(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+	if _, err := stream.ReceiveMsg(); err != nil {
+		return nil, err
+	}
+	request := cmd.RequestOf(quicStream)
+	return cmd.NewExchangeDataByJson(map[string]any{
+		"pattern": request.Pattern,
+		"params":  request.Params,
+	})
+}
+
+func tagMiddleware(tag string) cmd.Middleware {
+	return func(next cmd.Handler) cmd.Handler {
+		return func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+			request := cmd.RequestOf(quicStream)
+			request.Params["tags"] += tag
+			return next(stream, quicStream)
+		}
+	}
+}
+
+func TestRouterMatch(t *testing.T) {
+	a := assert.New(t)
+
+	router := cmd.NewRouter()
+	router.Use(tagMiddleware("r"))
+	router.Handle("/team/list", patternHandler)
+	router.Handle("/file/*path", patternHandler)
+
+	team := router.Group("/team/:teamId", tagMiddleware("t"))
+	team.Handle("/member/list", patternHandler)
+	member := team.Group("/member/:memberId")
+	member.Use(tagMiddleware("m"))
+	member.Handle("/info", patternHandler)
+
+	testData := []struct {
+		name    cmd.Name
+		pattern cmd.Name
+		params  map[string]string
+	}{
+		{"/team/list", "/team/list", map[string]string{"tags": "r"}},
+		{"/team/1/member/list", "/team/:teamId/member/list", map[string]string{"teamId": "1", "tags": "rt"}},
+		{"/team/1/member/2/info", "/team/:teamId/member/:memberId/info", map[string]string{"teamId": "1", "memberId": "2", "tags": "rtm"}},
+		{"/file/a/b.txt", "/file/*path", map[string]string{"path": "a/b.txt", "tags": "r"}},
+	}
+
+	for _, data := range testData {
+		var res struct {
+			Pattern cmd.Name          `json:"pattern"`
+			Params  map[string]string `json:"params"`
+		}
+		cmdtest.MustCall(t, router, data.name, nil, &res)
+		a.Equal(data.pattern, res.Pattern, data.name)
+		a.Equal(data.params, res.Params, data.name)
+	}
+
+	_, err := cmdtest.Call(t, router, "/team/1/unknown", nil)
+	a.True(errors.ErrCodeCommandUndefined.Equal(err))
+
+	router.NotFound(patternHandler)
+	var res struct {
+		Pattern cmd.Name `json:"pattern"`
+	}
+	cmdtest.MustCall(t, router, "/team/1/unknown", nil, &res)
+	a.Empty(res.Pattern)
+}
+
+func TestRouterAliasAndDeprecation(t *testing.T) {
+	a := assert.New(t)
+
+	router := cmd.NewRouter()
+	router.Handle("/team/:teamId/member/list", patternHandler)
+	router.Alias("/member/list", "/team/default/member/list")
+
+	sunset := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	marked := &cmd.Deprecation{Sunset: sunset}
+	router.Deprecate("/member/list", marked)
+	// 不修改调用方传入的参数
+	a.Empty(marked.Replacement)
+
+	var deprecatedCalls []cmd.Name
+	router.OnDeprecatedCall(func(request *cmd.Request, deprecation *cmd.Deprecation) {
+		deprecatedCalls = append(deprecatedCalls, request.Name)
+	})
+
+	option := &cmd.ExchangeOption{}
+	data, err := cmdtest.CallWithOption(t, router, "/member/list", option)
+	if !a.NoError(err) {
+		return
+	}
+
+	var res struct {
+		Pattern cmd.Name          `json:"pattern"`
+		Params  map[string]string `json:"params"`
+	}
+	a.NoError(data.UnmarshalJson(&res))
+	a.Equal(cmd.Name("/team/:teamId/member/list"), res.Pattern)
+	a.Equal("default", res.Params["teamId"])
+
+	deprecation, ok := cmd.DeprecationOf(option.ResponseHeader)
+	if a.True(ok) {
+		a.True(sunset.Equal(deprecation.Sunset))
+		a.Equal(cmd.Name("/team/default/member/list"), deprecation.Replacement)
+		a.NotEmpty(deprecation.Message)
+	}
+	a.Equal([]cmd.Name{"/member/list"}, deprecatedCalls)
+
+	option = &cmd.ExchangeOption{}
+	_, err = cmdtest.CallWithOption(t, router, "/team/1/member/list", option)
+	a.NoError(err)
+	_, ok = cmd.DeprecationOf(option.ResponseHeader)
+	a.False(ok)
+}
@@ -0,0 +1,364 @@
+package cmd
+
+import (
+	"encoding/json"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/teamManagement/common/errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Schema 内置命令, 导出路由表中命令的描述文档, 与 Commands 一样需要通过 Router.EnableIntrospection 开启
+const Schema Name = "/_schema"
+
+func init() {
+	reservedCmdMap[Schema] = schemaHandler
+}
+
+// SchemaVersion 描述文档的格式版本
+const SchemaVersion = "1"
+
+// StreamMode 命令的流式交换模式
+type StreamMode string
+
+const (
+	// StreamNone 一来一回的交换, 默认值
+	StreamNone StreamMode = "none"
+	// StreamServer 服务端在结束之前持续发送消息
+	StreamServer StreamMode = "server"
+	// StreamClient 客户端在结束之前持续发送消息
+	StreamClient StreamMode = "client"
+	// StreamBidi 双方均持续发送消息
+	StreamBidi StreamMode = "bidi"
+)
+
+// CommandSpec 命令的类型信息, 用于导出描述文档
+type CommandSpec struct {
+	// Description 命令说明
+	Description string
+	// Request 请求数据的类型, 传入该类型的零值或指针, 例如 (*ListRequest)(nil), 为nil时表示不需要数据
+	Request any
+	// Response 返回数据的类型, 规则与 Request 相同
+	Response any
+	// Streaming 流式交换模式, 为空时为 StreamNone
+	Streaming StreamMode
+	// Codec 命令使用的编解码器, 为空时为JSON
+	Codec string
+	// Errors 命令可能返回的业务异常码, 所有命令都可能返回的通用异常码不需要列出
+	Errors []transportstream.ErrCode
+}
+
+// Describe 记录命令的类型信息, 同名命令将被覆盖
+func (r *Router) Describe(name Name, spec *CommandSpec) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if spec == nil {
+		delete(r.specs, name)
+		return
+	}
+	if r.specs == nil {
+		r.specs = map[Name]*CommandSpec{}
+	}
+	r.specs[name] = spec
+	addSensitiveFields(name, SensitiveFieldsOf(spec.Request)...)
+	addSensitiveFields(name, SensitiveFieldsOf(spec.Response)...)
+}
+
+// Describe 在分组内记录命令的类型信息, 命令名称为分组前缀与 name 的拼接
+func (g *Group) Describe(name Name, spec *CommandSpec) {
+	g.router.Describe(g.prefix+name, spec)
+}
+
+// HandleTyped 注册类型化的命令, 请求及返回的数据使用本次命令协商的编解码器,
+// 同时将 Req 及 Res 记录为命令的类型信息, spec 中的其他信息可以为nil
+func HandleTyped[Req any, Res any](registrar Registrar, name Name, fn func(request *Request, in *Req) (*Res, error), spec *CommandSpec) {
+	registrar.Handle(name, func(stream *transportstream.Stream, quicStream quic.Stream) (ExchangeData, error) {
+		data, err := stream.ReceiveMsg()
+		if err != nil {
+			return nil, err
+		}
+
+		request := RequestOf(quicStream)
+		in := new(Req)
+		if len(data) > 0 {
+			if err = request.Unmarshal(data, in); err != nil {
+				return nil, errors.ErrCodeValidation.Newf("解析命令[%s]的数据失败: %s", request.Name, err.Error())
+			}
+		}
+
+		out, err := fn(request, in)
+		if err != nil {
+			return nil, err
+		}
+		if out == nil {
+			return nil, nil
+		}
+		return request.Marshal(out)
+	})
+
+	typed := &CommandSpec{}
+	if spec != nil {
+		*typed = *spec
+	}
+	typed.Request = (*Req)(nil)
+	typed.Response = (*Res)(nil)
+	registrar.Describe(name, typed)
+}
+
+// JSONSchema JSON Schema 的子集, 足以描述命令的数据结构
+type JSONSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+}
+
+// ErrorCodeSchema 异常码的描述
+type ErrorCodeSchema struct {
+	Code transportstream.ErrCode `json:"code"`
+	Name string                  `json:"name"`
+}
+
+// CommandSchema 单个命令的描述
+type CommandSchema struct {
+	Name        Name               `json:"name"`
+	Description string             `json:"description,omitempty"`
+	Builtin     bool               `json:"builtin,omitempty"`
+	AliasOf     Name               `json:"aliasOf,omitempty"`
+	Deprecated  bool               `json:"deprecated,omitempty"`
+	Sunset      *time.Time         `json:"sunset,omitempty"`
+	Replacement Name               `json:"replacement,omitempty"`
+	Params      []string           `json:"params,omitempty"`
+	Streaming   StreamMode         `json:"streaming"`
+	Codec       string             `json:"codec,omitempty"`
+	Request     *JSONSchema        `json:"request,omitempty"`
+	Response    *JSONSchema        `json:"response,omitempty"`
+	Errors      []*ErrorCodeSchema `json:"errors,omitempty"`
+}
+
+// SchemaDocument 路由表的描述文档
+type SchemaDocument struct {
+	Version string `json:"version"`
+	// Commands 所有命令, 按名称排序
+	Commands []*CommandSchema `json:"commands"`
+	// Errors 所有已定义的异常码
+	Errors []*ErrorCodeSchema `json:"errors"`
+	// Definitions 命令中引用的结构体
+	Definitions map[string]*JSONSchema `json:"$defs,omitempty"`
+}
+
+// Schema 导出路由表的描述文档, 未通过 Describe 或 HandleTyped 记录类型信息的命令仅包含名称
+func (r *Router) Schema() *SchemaDocument {
+	commands := r.Commands()
+
+	r.lock.RLock()
+	specs := make(map[Name]*CommandSpec, len(r.specs))
+	for name, spec := range r.specs {
+		specs[name] = spec
+	}
+	r.lock.RUnlock()
+
+	doc := &SchemaDocument{
+		Version:     SchemaVersion,
+		Commands:    make([]*CommandSchema, 0, len(commands)),
+		Errors:      errorCodeSchemas(errors.Codes()),
+		Definitions: map[string]*JSONSchema{},
+	}
+
+	for _, info := range commands {
+		command := &CommandSchema{
+			Name:        info.Name,
+			Builtin:     info.Builtin,
+			AliasOf:     info.AliasOf,
+			Deprecated:  info.Deprecated,
+			Sunset:      info.Sunset,
+			Replacement: info.Replacement,
+			Streaming:   StreamNone,
+		}
+		for _, segment := range splitName(info.Name) {
+			if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+				command.Params = append(command.Params, segment[1:])
+			}
+		}
+
+		spec, ok := specs[info.Name]
+		if !ok && info.AliasOf != "" {
+			spec, ok = specs[info.AliasOf]
+		}
+		if ok {
+			command.Description = spec.Description
+			command.Codec = spec.Codec
+			if spec.Streaming != "" {
+				command.Streaming = spec.Streaming
+			}
+			command.Request = typeSchema(spec.Request, doc.Definitions)
+			command.Response = typeSchema(spec.Response, doc.Definitions)
+			command.Errors = errorCodeSchemas(spec.Errors)
+		}
+		doc.Commands = append(doc.Commands, command)
+	}
+
+	if len(doc.Definitions) == 0 {
+		doc.Definitions = nil
+	}
+	return doc
+}
+
+func errorCodeSchemas(codes []transportstream.ErrCode) []*ErrorCodeSchema {
+	schemas := make([]*ErrorCodeSchema, 0, len(codes))
+	for _, code := range codes {
+		schemas = append(schemas, &ErrorCodeSchema{Code: code, Name: errors.CodeName(code)})
+	}
+	return schemas
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	marshalerType  = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// typeSchema 生成 v 的类型对应的 JSON Schema, v 为nil时返回nil
+func typeSchema(v any, defs map[string]*JSONSchema) *JSONSchema {
+	if v == nil {
+		return nil
+	}
+	return reflectSchema(reflect.TypeOf(v), defs)
+}
+
+func reflectSchema(t reflect.Type, defs map[string]*JSONSchema) *JSONSchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return &JSONSchema{Type: "string", Format: "date-time"}
+	case t == rawMessageType:
+		return &JSONSchema{}
+	case t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType):
+		// 自定义序列化的类型无法推断结构
+		return &JSONSchema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &JSONSchema{Type: "string", Format: "byte"}
+		}
+		return &JSONSchema{Type: "array", Items: reflectSchema(t.Elem(), defs)}
+	case reflect.Map:
+		return &JSONSchema{Type: "object", AdditionalProperties: reflectSchema(t.Elem(), defs)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, defs)
+		}
+		name := definitionName(t)
+		if _, ok := defs[name]; !ok {
+			// 先占位以支持递归引用
+			defs[name] = &JSONSchema{}
+			*defs[name] = *structSchema(t, defs)
+		}
+		return &JSONSchema{Ref: definitionRef(name)}
+	default:
+		return &JSONSchema{}
+	}
+}
+
+// definitionName 类型在 $defs 中的名称, 包含完整的包路径及泛型的类型参数, 避免不同包中的同名类型或泛型的不同实例共用同一个定义
+func definitionName(t reflect.Type) string {
+	if t.PkgPath() == "" {
+		return t.Name()
+	}
+	return t.PkgPath() + "." + t.Name()
+}
+
+// definitionRefEscaper 按 RFC 6901 转义 JSON Pointer 中的 ~ 及 /
+var definitionRefEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// definitionRef 引用 $defs 中定义的 $ref, 名称按 JSON Pointer 转义之后再按 URI 片段转义
+func definitionRef(name string) string {
+	return "#/$defs/" + url.PathEscape(definitionRefEscaper.Replace(name))
+}
+
+// structSchema 按照 encoding/json 的规则生成结构体的 JSON Schema
+func structSchema(t reflect.Type, defs map[string]*JSONSchema) *JSONSchema {
+	schema := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				inner := structSchema(embedded, defs)
+				for k, v := range inner.Properties {
+					schema.Properties[k] = v
+				}
+				schema.Required = append(schema.Required, inner.Required...)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		fieldSchema := reflectSchema(field.Type, defs)
+		if strings.Contains(opts, "string") {
+			fieldSchema = &JSONSchema{Type: "string"}
+		}
+		schema.Properties[name] = fieldSchema
+		if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Pointer {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
+
+func schemaHandler(stream *transportstream.Stream, quicStream quic.Stream) (ExchangeData, error) {
+	router, err := introspectionRouter(stream, quicStream, Schema)
+	if err != nil {
+		return nil, err
+	}
+	return NewExchangeDataByJson(router.Schema())
+}
+
+// FetchSchema 获取对端路由表的描述文档
+func FetchSchema(stream *transportstream.Stream) (*SchemaDocument, error) {
+	res, err := Schema.Exchange(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc *SchemaDocument
+	if err = res.UnmarshalJson(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
@@ -0,0 +1,127 @@
+package cmd_test
+
+import (
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/teamManagement/common/cmd"
+	"github.com/teamManagement/common/cmdtest"
+	"github.com/teamManagement/common/errors"
+	"testing"
+	"time"
+)
+
+type memberQuery struct {
+	Keyword string   `json:"keyword"`
+	Roles   []string `json:"roles,omitempty"`
+	Page    *int     `json:"page"`
+}
+
+type member struct {
+	ID       string    `json:"id"`
+	JoinedAt time.Time `json:"joinedAt"`
+	Leader   *member   `json:"leader,omitempty"`
+	internal string
+}
+
+type memberList struct {
+	Members []*member `json:"members"`
+	Total   int       `json:"total,string"`
+}
+
+type page[T any] struct {
+	Items []T `json:"items"`
+}
+
+func TestHandleTypedSchema(t *testing.T) {
+	a := assert.New(t)
+
+	router := cmd.NewRouter()
+	group := router.Group("/team/:teamId")
+	cmd.HandleTyped(group, "/member/search", func(request *cmd.Request, in *memberQuery) (*memberList, error) {
+		if in.Keyword == "" {
+			return nil, errors.ErrCodeValidation.New("关键字不能为空")
+		}
+		return &memberList{Members: []*member{{ID: request.Param("teamId") + "-" + in.Keyword}}, Total: 1}, nil
+	}, &cmd.CommandSpec{
+		Description: "搜索成员",
+		Errors:      []transportstream.ErrCode{errors.ErrCodeValidation},
+	})
+	router.Handle("/untyped", patternHandler)
+
+	var res *memberList
+	cmdtest.MustCall(t, router, "/team/1/member/search", &memberQuery{Keyword: "a"}, &res)
+	a.Equal("1-a", res.Members[0].ID)
+
+	_, err := cmdtest.Call(t, router, "/team/1/member/search", &memberQuery{})
+	a.True(errors.ErrCodeValidation.Equal(err))
+
+	doc := router.Schema()
+	a.Equal(cmd.SchemaVersion, doc.Version)
+	a.NotEmpty(doc.Errors)
+
+	commands := map[cmd.Name]*cmd.CommandSchema{}
+	for _, command := range doc.Commands {
+		commands[command.Name] = command
+	}
+
+	search := commands["/team/:teamId/member/search"]
+	if !a.NotNil(search) {
+		return
+	}
+	a.Equal("搜索成员", search.Description)
+	a.Equal(cmd.StreamNone, search.Streaming)
+	a.Equal([]string{"teamId"}, search.Params)
+	a.Equal("#/$defs/github.com~1teamManagement~1common~1cmd_test.memberQuery", search.Request.Ref)
+	a.Equal("#/$defs/github.com~1teamManagement~1common~1cmd_test.memberList", search.Response.Ref)
+	if a.Len(search.Errors, 1) {
+		a.Equal("Validation", search.Errors[0].Name)
+	}
+
+	query := doc.Definitions["github.com/teamManagement/common/cmd_test.memberQuery"]
+	a.Equal([]string{"keyword"}, query.Required)
+	a.Equal("array", query.Properties["roles"].Type)
+
+	m := doc.Definitions["github.com/teamManagement/common/cmd_test.member"]
+	a.Equal("date-time", m.Properties["joinedAt"].Format)
+	a.Equal("#/$defs/github.com~1teamManagement~1common~1cmd_test.member", m.Properties["leader"].Ref)
+	a.NotContains(m.Properties, "internal")
+	a.Equal("string", doc.Definitions["github.com/teamManagement/common/cmd_test.memberList"].Properties["total"].Type)
+
+	untyped := commands["/untyped"]
+	if a.NotNil(untyped) {
+		a.Nil(untyped.Request)
+	}
+
+	router.EnableIntrospection(true)
+	fetched, err := cmd.FetchSchema(serveOnce(router))
+	a.NoError(err)
+	a.Len(fetched.Commands, len(doc.Commands))
+}
+
+func TestSchemaGenericDefinitions(t *testing.T) {
+	a := assert.New(t)
+
+	router := cmd.NewRouter()
+	router.Handle("/member/page", patternHandler)
+	router.Handle("/query/page", patternHandler)
+	router.Describe("/member/page", &cmd.CommandSpec{Response: (*page[member])(nil)})
+	router.Describe("/query/page", &cmd.CommandSpec{Response: (*page[memberQuery])(nil)})
+
+	doc := router.Schema()
+	// 泛型的不同实例使用不同的定义
+	refs := map[string]bool{}
+	for _, command := range doc.Commands {
+		if command.Response != nil {
+			refs[command.Response.Ref] = true
+		}
+	}
+	a.Len(refs, 2)
+
+	pkg := "github.com/teamManagement/common/cmd_test."
+	memberPage := doc.Definitions[pkg+"page["+pkg+"member]"]
+	if a.NotNil(memberPage) {
+		a.Equal("#/$defs/github.com~1teamManagement~1common~1cmd_test.member", memberPage.Properties["items"].Items.Ref)
+	}
+	a.NotNil(doc.Definitions[pkg+"page["+pkg+"memberQuery]"])
+	a.True(refs["#/$defs/github.com~1teamManagement~1common~1cmd_test.page%5Bgithub.com~1teamManagement~1common~1cmd_test.member%5D"])
+}
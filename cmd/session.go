@@ -0,0 +1,262 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/teamManagement/common/errors"
+	"github.com/teamManagement/common/record"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var sessionIdSeq uint64
+
+// Session 一条QUIC连接对应的会话信息
+type Session struct {
+	id          uint64
+	conn        quic.Connection
+	connectedAt time.Time
+
+	bulkhead *bulkhead
+
+	lock        sync.RWMutex
+	user        string
+	inflightSeq uint64
+	inflight    map[uint64]*InflightCommand
+}
+
+// InflightCommand 会话中正在执行的命令
+type InflightCommand struct {
+	// Name 命令名称
+	Name Name `json:"name"`
+	// StartedAt 开始执行的时间
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// SessionInfo 会话的快照, 由管理命令返回
+type SessionInfo struct {
+	// ID 会话编号
+	ID uint64 `json:"id"`
+	// User 已认证的用户, 未认证时为空
+	User string `json:"user,omitempty"`
+	// RemoteAddr 对端地址
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	// ConnectedAt 建立连接的时间
+	ConnectedAt time.Time `json:"connectedAt"`
+	// Inflight 正在执行的命令, 按开始时间排序
+	Inflight []*InflightCommand `json:"inflight,omitempty"`
+}
+
+func newSession(conn quic.Connection, bulkhead *bulkhead) *Session {
+	return &Session{
+		id:          atomic.AddUint64(&sessionIdSeq, 1),
+		conn:        conn,
+		connectedAt: time.Now(),
+		bulkhead:    bulkhead,
+		inflight:    map[uint64]*InflightCommand{},
+	}
+}
+
+// ID 会话编号, 进程内唯一
+func (s *Session) ID() uint64 {
+	return s.id
+}
+
+// Conn 会话对应的QUIC连接
+func (s *Session) Conn() quic.Connection {
+	return s.conn
+}
+
+// RemoteAddr 对端地址
+func (s *Session) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+// ConnectedAt 建立连接的时间
+func (s *Session) ConnectedAt() time.Time {
+	return s.connectedAt
+}
+
+// User 获取会话中已认证的用户, 未认证时为空
+func (s *Session) User() string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.user
+}
+
+// SetUser 设置会话中已认证的用户, 一般在 Login 命令处理成功之后调用
+func (s *Session) SetUser(user string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.user = user
+}
+
+// Inflight 会话中正在执行的命令, 按开始时间排序
+func (s *Session) Inflight() []*InflightCommand {
+	s.lock.RLock()
+	commands := make([]*InflightCommand, 0, len(s.inflight))
+	for _, command := range s.inflight {
+		c := *command
+		commands = append(commands, &c)
+	}
+	s.lock.RUnlock()
+
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].StartedAt.Before(commands[j].StartedAt)
+	})
+	return commands
+}
+
+// Info 获取会话的快照
+func (s *Session) Info() *SessionInfo {
+	info := &SessionInfo{
+		ID:          s.id,
+		User:        s.User(),
+		ConnectedAt: s.connectedAt,
+		Inflight:    s.Inflight(),
+	}
+	if s.conn != nil {
+		info.RemoteAddr = s.RemoteAddr().String()
+	}
+	return info
+}
+
+// Close 强制关闭会话对应的QUIC连接, 对端收到的关闭码为 errors.ErrCodeSessionClosed, reason 为关闭原因
+func (s *Session) Close(reason string) error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.CloseWithError(quic.ApplicationErrorCode(errors.ErrCodeSessionClosed), reason)
+}
+
+// track 记录开始执行的命令, 返回命令结束时调用的函数, 会话为nil时不记录
+func (s *Session) track(name Name) func() {
+	if s == nil {
+		return func() {}
+	}
+
+	s.lock.Lock()
+	s.inflightSeq++
+	seq := s.inflightSeq
+	s.inflight[seq] = &InflightCommand{Name: name, StartedAt: time.Now()}
+	s.lock.Unlock()
+
+	return func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		delete(s.inflight, seq)
+	}
+}
+
+// ServeConn 使用 DefaultRouter 处理连接上的所有流, 直到连接关闭
+func ServeConn(conn quic.Connection) error {
+	return DefaultRouter.ServeConn(conn)
+}
+
+// ServeConn 持续接收连接上的流并处理其中的命令交换, 直到连接关闭
+func (r *Router) ServeConn(conn quic.Connection) error {
+	session := newSession(conn, r.newConnBulkhead())
+	r.addSession(session)
+	defer r.removeSession(session)
+	for {
+		quicStream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			_ = r.serveStream(quicStream, session)
+		}()
+	}
+}
+
+// ServeStream 处理单个流上的一次命令交换, 与 Route 不同的是会启用数据大小限制及透明压缩, 处理结束之后关闭流
+func (r *Router) ServeStream(quicStream quic.Stream) error {
+	return r.serveStream(quicStream, nil)
+}
+
+func (r *Router) serveStream(quicStream quic.Stream, session *Session) error {
+	return r.serveTransport(quicStream, session, newStreamTransport(quicStream, r.currentRecorder()))
+}
+
+func (r *Router) serveTransport(quicStream quic.Stream, session *Session, transport *streamTransport) error {
+	defer quicStream.Close()
+	return r.routeTransport(quicStream, session, transport)
+}
+
+// routeTransport 在传输层上处理一次命令交换, 结束之后停止读取超出限制或仍在预读的流, 不关闭流
+func (r *Router) routeTransport(quicStream quic.Stream, session *Session, transport *streamTransport) error {
+	err := r.route(transport.stream, quicStream, session, transport)
+	if transport.limiter.isExceeded() {
+		quicStream.CancelRead(quic.StreamErrorCode(errors.ErrCodePayloadTooLarge))
+	} else if transport.canceller.isWatching() {
+		// 交换已结束, 停止后台预读
+		quicStream.CancelRead(quic.StreamErrorCode(errors.ErrCodeCancelled))
+	}
+	transport.canceller.stop()
+	return err
+}
+
+// streamTransport ServeConn 为每个流构建的传输层, 负责数据大小限制、压缩、取消消息的监听及审计摘要的统计
+type streamTransport struct {
+	stream    *transportstream.Stream
+	limiter   *frameLimitReader
+	reader    *frameCompressReader
+	writer    *frameCompressWriter
+	canceller *frameCancelReader
+	digest    *frameDigestReader
+	// nested 是否为批量执行中的命令, 此时连接的执行名额已由外层的 Batch 命令占用
+	nested bool
+	// ctx 批量执行中的命令继承外层 Batch 命令的上下文, 为nil时使用 context.Background()
+	ctx context.Context
+}
+
+// newStreamTransport 构建传输层, rec 不为nil时记录解压之后的所有消息
+func newStreamTransport(rw io.ReadWriter, rec *record.Recorder) *streamTransport {
+	limiter := newFrameLimitReader(rw, maxCommandSize)
+	reader := &frameCompressReader{r: limiter, limiter: limiter}
+	writer := &frameCompressWriter{w: rw}
+	canceller := newFrameCancelReader(reader, limiter)
+	digest := &frameDigestReader{r: canceller}
+
+	var messageRW io.ReadWriter = struct {
+		io.Reader
+		io.Writer
+	}{digest, writer}
+	if rec != nil {
+		messageRW = rec.Wrap(messageRW, record.SideServer)
+	}
+	return &streamTransport{
+		stream:    transportstream.NewStream(bufio.NewReadWriter(bufio.NewReader(messageRW), bufio.NewWriter(messageRW))),
+		limiter:   limiter,
+		reader:    reader,
+		writer:    writer,
+		canceller: canceller,
+		digest:    digest,
+	}
+}
+
+// isExceeded 是否读取到了超出限制的数据
+func (t *streamTransport) isExceeded() bool {
+	return t != nil && t.limiter.isExceeded()
+}
+
+// watchCancel 开始监听客户端的取消消息
+func (t *streamTransport) watchCancel(cancel context.CancelFunc) {
+	if t != nil {
+		t.canceller.watch(cancel)
+	}
+}
+
+// setLimit 设置单个消息的最大字节数
+func (t *streamTransport) setLimit(limit int64) {
+	if t != nil {
+		t.limiter.setLimit(limit)
+	}
+}
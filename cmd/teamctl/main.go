@@ -0,0 +1,505 @@
+// teamctl 通过QUIC连接服务端并执行命令, 用于运维及调试 Handler.
+//
+// 用法:
+//
+//	teamctl -addr host:port [flags] call <command> [json]   执行命令, 未指定 json 或为 - 时从标准输入读取
+//	teamctl -addr host:port [flags] list                    列出服务端注册的命令, 需要服务端开启 EnableIntrospection
+//	teamctl -addr host:port [flags] schema                  输出服务端命令的描述文档, 需要服务端开启 EnableIntrospection
+//	teamctl -addr host:port [flags] ping                    测量往返耗时
+//	teamctl -addr host:port [flags] health [component]      查询健康状态
+//	teamctl -addr host:port [flags] sessions [user]         列出服务端的会话, 需要服务端开启 EnableAdmin
+//	teamctl -addr host:port [flags] kick <session|user> [reason]  强制关闭会话或用户的所有会话, 需要服务端开启 EnableAdmin
+//	teamctl -addr host:port [flags] replay <file>           将 -record 或 Router.SetRecorder 记录的交换回放至服务端并比较结果
+//
+// 回放时存在结果不一致或失败的流时退出码同样为1
+//
+// 命令返回异常时退出码为1, 参数错误或连接失败时为2
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/teamManagement/common/cmd"
+	"github.com/teamManagement/common/codec"
+	"github.com/teamManagement/common/errors"
+	"github.com/teamManagement/common/record"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	exitOK = iota
+	exitCommandErr
+	exitUsage
+)
+
+// headerFlag 可重复指定的 key=value 请求头
+type headerFlag cmd.Header
+
+func (h headerFlag) String() string {
+	pairs := make([]string, 0, len(h))
+	for k, v := range h {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (h headerFlag) Set(value string) error {
+	k, v, ok := strings.Cut(value, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("请求头格式应为 key=value: %s", value)
+	}
+	h[k] = v
+	return nil
+}
+
+type options struct {
+	addr       string
+	alpn       string
+	serverName string
+	ca         string
+	insecure   bool
+	timeout    time.Duration
+	codec      string
+	header     headerFlag
+	compress   bool
+	stream     bool
+	progress   bool
+	raw        bool
+	record     string
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	opts := &options{header: headerFlag{}}
+	fs := flag.NewFlagSet("teamctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&opts.addr, "addr", "", "服务端地址, host:port")
+	fs.StringVar(&opts.alpn, "alpn", "teamManagement", "TLS ALPN 协议名称, 需与服务端一致")
+	fs.StringVar(&opts.serverName, "server-name", "", "校验服务端证书时使用的名称, 默认为地址中的主机名")
+	fs.StringVar(&opts.ca, "ca", "", "校验服务端证书的 CA 证书文件(PEM)")
+	fs.BoolVar(&opts.insecure, "insecure", false, "不校验服务端证书, 仅用于调试")
+	fs.DurationVar(&opts.timeout, "timeout", 30*time.Second, "连接及命令执行的超时时间, 为0时不限制")
+	fs.StringVar(&opts.codec, "codec", "", "数据编解码器, 默认为json, 输入输出均为JSON, raw 时原样发送及输出")
+	fs.Var(opts.header, "header", "附加的请求头 key=value, 可重复指定")
+	fs.BoolVar(&opts.compress, "compress", false, "与服务端协商压缩")
+	fs.BoolVar(&opts.stream, "stream", false, "逐条输出服务端在交换过程中发送的消息")
+	fs.BoolVar(&opts.progress, "progress", false, "将服务端报告的处理进度输出至标准错误")
+	fs.BoolVar(&opts.raw, "raw", false, "原样输出返回的数据, 不格式化")
+	fs.StringVar(&opts.record, "record", "", "将交换的所有消息记录至文件, 可通过 replay 子命令回放")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "用法: teamctl -addr host:port [flags] call <command> [json] | list | schema | ping | health [component] | sessions [user] | kick <session|user> [reason] | replay <file>")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if opts.addr == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	var action func(stream *transportstream.Stream) error
+	switch sub := fs.Arg(0); sub {
+	case "call":
+		if fs.NArg() < 2 || fs.NArg() > 3 {
+			fs.Usage()
+			return exitUsage
+		}
+		payload, err := readPayload(fs.Arg(2), stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "读取数据失败: %s\n", err.Error())
+			return exitUsage
+		}
+		action = func(stream *transportstream.Stream) error {
+			return call(opts, cmd.Name(fs.Arg(1)), payload, stream, stdout, stderr)
+		}
+	case "list":
+		action = func(stream *transportstream.Stream) error {
+			return list(stream, stdout)
+		}
+	case "schema":
+		action = func(stream *transportstream.Stream) error {
+			doc, err := cmd.FetchSchema(stream)
+			if err != nil {
+				return err
+			}
+			return printJSON(stdout, doc)
+		}
+	case "ping":
+		action = func(stream *transportstream.Stream) error {
+			latency, err := cmd.MeasureLatency(stream)
+			if err == nil {
+				fmt.Fprintln(stdout, latency)
+			}
+			return err
+		}
+	case "health":
+		action = func(stream *transportstream.Stream) error {
+			report, err := cmd.RemoteHealth(fs.Arg(1), stream)
+			if err != nil {
+				return err
+			}
+			return printJSON(stdout, report)
+		}
+	case "sessions":
+		action = func(stream *transportstream.Stream) error {
+			return sessions(fs.Arg(1), stream, stdout)
+		}
+	case "kick":
+		if fs.NArg() < 2 || fs.NArg() > 3 {
+			fs.Usage()
+			return exitUsage
+		}
+		req := &cmd.CloseSessionRequest{Reason: fs.Arg(2)}
+		if id, err := strconv.ParseUint(fs.Arg(1), 10, 64); err == nil {
+			req.Session = id
+		} else {
+			req.User = fs.Arg(1)
+		}
+		action = func(stream *transportstream.Stream) error {
+			closed, err := cmd.CloseSessions(req, stream)
+			if err == nil {
+				fmt.Fprintf(stdout, "已关闭 %d 个会话: %v\n", len(closed), closed)
+			}
+			return err
+		}
+	case "replay":
+		if fs.NArg() != 2 {
+			fs.Usage()
+			return exitUsage
+		}
+		entries, err := record.Load(fs.Arg(1))
+		if err != nil {
+			fmt.Fprintf(stderr, "读取记录失败: %s\n", err.Error())
+			return exitUsage
+		}
+		conn, err := dialConn(opts)
+		if err != nil {
+			fmt.Fprintf(stderr, "连接服务端失败: %s\n", err.Error())
+			return exitUsage
+		}
+		defer conn.CloseWithError(0, "")
+		return replay(conn, entries, opts.timeout, stdout)
+	default:
+		fmt.Fprintf(stderr, "未知的子命令: %s\n", sub)
+		fs.Usage()
+		return exitUsage
+	}
+
+	stream, closeFn, err := dial(opts)
+	if err != nil {
+		fmt.Fprintf(stderr, "连接服务端失败: %s\n", err.Error())
+		return exitUsage
+	}
+	defer closeFn()
+	return report(action(stream), stderr)
+}
+
+// readPayload 读取要发送的数据, arg 为空或为 - 时从标准输入读取
+func readPayload(arg string, stdin io.Reader) ([]byte, error) {
+	if arg != "" && arg != "-" {
+		return []byte(arg), nil
+	}
+	if f, ok := stdin.(*os.File); ok && arg == "" {
+		// 标准输入为终端时视为不发送数据
+		if info, err := f.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			return nil, nil
+		}
+	}
+	return io.ReadAll(stdin)
+}
+
+func dialConn(opts *options) (quic.Connection, error) {
+	tlsConf := &tls.Config{
+		NextProtos:         []string{opts.alpn},
+		ServerName:         opts.serverName,
+		InsecureSkipVerify: opts.insecure,
+	}
+	if opts.ca != "" {
+		pem, err := os.ReadFile(opts.ca)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA 证书文件中没有有效的证书: %s", opts.ca)
+		}
+		tlsConf.RootCAs = pool
+	}
+
+	ctx, cancel := timeoutContext(opts.timeout)
+	defer cancel()
+	return quic.DialAddrContext(ctx, opts.addr, tlsConf, nil)
+}
+
+// timeoutContext timeout 为0时不限制
+func timeoutContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// openStream 在连接上打开新的流, 设置超时时间
+func openStream(conn quic.Connection, timeout time.Duration) (quic.Stream, error) {
+	ctx, cancel := timeoutContext(timeout)
+	defer cancel()
+
+	quicStream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		_ = quicStream.SetDeadline(time.Now().Add(timeout))
+	}
+	return quicStream, nil
+}
+
+func dial(opts *options) (*transportstream.Stream, func(), error) {
+	conn, err := dialConn(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	quicStream, err := openStream(conn, opts.timeout)
+	if err != nil {
+		_ = conn.CloseWithError(0, "")
+		return nil, nil, err
+	}
+
+	var rec *record.Recorder
+	if opts.record != "" {
+		if rec, err = record.Create(opts.record); err != nil {
+			_ = conn.CloseWithError(0, "")
+			return nil, nil, err
+		}
+		rec.SetRedactor(cmd.NewRecordRedactor(nil))
+	}
+
+	var stream *transportstream.Stream
+	if rec != nil {
+		stream = rec.NewTransportStream(quicStream, record.SideClient)
+	} else {
+		stream = transportstream.NewStream(bufio.NewReadWriter(bufio.NewReader(quicStream), bufio.NewWriter(quicStream)))
+	}
+	return stream, func() {
+		_ = quicStream.Close()
+		_ = conn.CloseWithError(0, "")
+		if rec != nil {
+			_ = rec.Close()
+		}
+	}, nil
+}
+
+func call(opts *options, name cmd.Name, payload []byte, stream *transportstream.Stream, stdout, stderr io.Writer) error {
+	data, err := buildData(opts.codec, payload)
+	if err != nil {
+		return err
+	}
+
+	option := &cmd.ExchangeOption{
+		Data:     data,
+		Codec:    opts.codec,
+		Header:   cmd.Header(opts.header),
+		Compress: opts.compress,
+	}
+	if opts.stream {
+		option.StreamHandle = func(exchangeData cmd.ExchangeData, stream *transportstream.Stream) (cmd.ExchangeData, error) {
+			return nil, printData(stdout, opts.codec, exchangeData, opts.raw)
+		}
+	}
+	if opts.progress {
+		option.Progress = func(progress cmd.Progress) {
+			fmt.Fprintf(stderr, "进度: %.1f%% %s %s\n", progress.Percent, progress.Stage, progress.Message)
+		}
+	}
+
+	res, err := name.ExchangeWithOption(stream, option)
+	if deprecation, ok := cmd.DeprecationOf(option.ResponseHeader); ok {
+		fmt.Fprintf(stderr, "警告: %s\n", deprecation.Message)
+	}
+	if err != nil {
+		return err
+	}
+	return printData(stdout, opts.codec, res, opts.raw)
+}
+
+// buildData 将输入的数据转换为 ExchangeOption.Data, JSON 编解码时原样发送, 其余编解码器先解析输入的JSON
+func buildData(codecName string, payload []byte) (any, error) {
+	payload = bytes.TrimSpace(payload)
+	if len(payload) == 0 {
+		return nil, nil
+	}
+
+	switch codecName {
+	case "", codec.JSON:
+		if !json.Valid(payload) {
+			return nil, fmt.Errorf("输入的数据不是有效的JSON")
+		}
+		return json.RawMessage(payload), nil
+	case codec.Raw:
+		return payload, nil
+	default:
+		var v any
+		if err := json.Unmarshal(payload, &v); err != nil {
+			return nil, fmt.Errorf("输入的数据不是有效的JSON: %s", err.Error())
+		}
+		return v, nil
+	}
+}
+
+// printData 输出服务端返回的数据, 非 raw 编解码器的数据转换为格式化的JSON
+func printData(w io.Writer, codecName string, data cmd.ExchangeData, raw bool) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	if raw || codecName == codec.Raw {
+		_, err := fmt.Fprintf(w, "%s\n", data)
+		return err
+	}
+
+	if codecName == "" || codecName == codec.JSON {
+		buf := &bytes.Buffer{}
+		if err := json.Indent(buf, data, "", "  "); err != nil {
+			_, err = fmt.Fprintf(w, "%s\n", data)
+			return err
+		}
+		_, err := fmt.Fprintln(w, buf.String())
+		return err
+	}
+
+	var v any
+	if err := data.UnmarshalCodec(codecName, &v); err != nil {
+		return err
+	}
+	if err := printJSON(w, v); err != nil {
+		_, err = fmt.Fprintf(w, "%v\n", v)
+		return err
+	}
+	return nil
+}
+
+func printJSON(w io.Writer, v any) error {
+	marshal, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(marshal))
+	return err
+}
+
+func list(stream *transportstream.Stream, stdout io.Writer) error {
+	commands, err := cmd.ListCommands(stream)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tINFO")
+	for _, info := range commands {
+		var notes []string
+		if info.Builtin {
+			notes = append(notes, "builtin")
+		}
+		if info.AliasOf != "" {
+			notes = append(notes, "alias of "+string(info.AliasOf))
+		}
+		if info.Deprecated {
+			note := "deprecated"
+			if info.Sunset != nil {
+				note += ", sunset " + info.Sunset.Format("2006-01-02")
+			}
+			if info.Replacement != "" {
+				note += ", use " + string(info.Replacement)
+			}
+			notes = append(notes, note)
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", info.Name, strings.Join(notes, "; "))
+	}
+	return tw.Flush()
+}
+
+func sessions(user string, stream *transportstream.Stream, stdout io.Writer) error {
+	infos, err := cmd.ListSessions(user, stream)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUSER\tREMOTE\tCONNECTED\tINFLIGHT")
+	for _, info := range infos {
+		inflight := make([]string, 0, len(info.Inflight))
+		for _, command := range info.Inflight {
+			inflight = append(inflight, fmt.Sprintf("%s(%s)", command.Name, time.Since(command.StartedAt).Round(time.Millisecond)))
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", info.ID, info.User, info.RemoteAddr,
+			info.ConnectedAt.Format(time.RFC3339), strings.Join(inflight, ", "))
+	}
+	return tw.Flush()
+}
+
+// replay 将记录中的每条流在新的流上回放, 输出每条流的比较结果, 存在不一致或失败的流时返回 exitCommandErr
+func replay(conn quic.Connection, entries []*record.Entry, timeout time.Duration, stdout io.Writer) int {
+	results := cmd.ReplayTo(func() (quic.Stream, error) {
+		return openStream(conn, timeout)
+	}, entries, timeout)
+
+	code := exitOK
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STREAM\tCOMMAND\tRESULT")
+	for _, result := range results {
+		var res string
+		switch {
+		case result.Err != nil:
+			res = "失败: " + result.Err.Error()
+		case result.Matched():
+			res = "一致"
+		default:
+			res = fmt.Sprintf("不一致: 记录 %d 条消息, 回放 %d 条消息", len(result.Recorded), len(result.Replayed))
+		}
+		if result.Err != nil || !result.Matched() {
+			code = exitCommandErr
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", result.Stream, result.Command, res)
+	}
+	_ = tw.Flush()
+	return code
+}
+
+// report 输出执行结果并返回退出码
+func report(err error, stderr io.Writer) int {
+	if err == nil {
+		return exitOK
+	}
+
+	errInfo, ok := transportstream.ErrConvert(err)
+	if !ok {
+		fmt.Fprintf(stderr, "错误: %s\n", err.Error())
+		return exitUsage
+	}
+
+	fmt.Fprintf(stderr, "错误[%s(%d)]: %s\n", errors.CodeName(errInfo.Code), uint(errInfo.Code), errInfo.Msg)
+	if retryAfter, ok := errors.RetryAfter(err); ok {
+		fmt.Fprintf(stderr, "建议重试间隔: %s\n", retryAfter)
+	} else if len(errInfo.RawData) > 0 {
+		fmt.Fprintf(stderr, "%s\n", errInfo.RawData)
+	}
+	return exitCommandErr
+}
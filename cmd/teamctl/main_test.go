@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/teamManagement/common/cmd"
+	"github.com/teamManagement/common/errors"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func selfSignedTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		NextProtos:   []string{"teamManagement"},
+	}
+}
+
+func startServer(t *testing.T, router *cmd.Router) string {
+	listener, err := quic.ListenAddr("127.0.0.1:0", selfSignedTLSConfig(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = router.ServeConn(conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestRun(t *testing.T) {
+	a := assert.New(t)
+
+	router := cmd.NewRouter()
+	router.EnableIntrospection(true)
+	router.EnableAdmin(func(request *cmd.Request, name cmd.Name) error {
+		return nil
+	})
+	router.Handle("/echo", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		return stream.ReceiveMsg()
+	})
+	router.Handle("/fail", func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		return nil, errors.ErrCodeValidation.New("参数错误")
+	})
+	addr := startServer(t, router)
+
+	exec := func(args ...string) (int, string, string) {
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		code := run(append([]string{"-addr", addr, "-insecure", "-timeout", "5s"}, args...), strings.NewReader(`{"from":"stdin"}`), stdout, stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	code, stdout, _ := exec("call", "/echo", `{"team":1}`)
+	a.Equal(exitOK, code)
+	a.JSONEq(`{"team":1}`, stdout)
+
+	code, stdout, _ = exec("call", "/echo", "-")
+	a.Equal(exitOK, code)
+	a.JSONEq(`{"from":"stdin"}`, stdout)
+
+	code, stdout, _ = exec("-codec", "msgpack", "call", "/echo", `{"team":1}`)
+	a.Equal(exitOK, code)
+	a.JSONEq(`{"team":1}`, stdout)
+
+	code, _, stderr := exec("call", "/fail", "{}")
+	a.Equal(exitCommandErr, code)
+	a.Contains(stderr, "Validation")
+
+	code, stdout, _ = exec("list")
+	a.Equal(exitOK, code)
+	a.Contains(stdout, "/echo")
+
+	code, stdout, _ = exec("schema")
+	a.Equal(exitOK, code)
+	a.Contains(stdout, `"version"`)
+
+	code, stdout, _ = exec("sessions")
+	a.Equal(exitOK, code)
+	a.Contains(stdout, "INFLIGHT")
+	a.Contains(stdout, string(cmd.AdminSessionsCmd))
+
+	code, _, stderr = exec("kick", "999999")
+	a.Equal(exitCommandErr, code)
+	a.Contains(stderr, "Validation")
+
+	recorded := filepath.Join(t.TempDir(), "echo.jsonl")
+	code, _, _ = exec("-record", recorded, "call", "/echo", `{"team":2}`)
+	a.Equal(exitOK, code)
+	code, stdout, _ = exec("replay", recorded)
+	a.Equal(exitOK, code)
+	a.Contains(stdout, "/echo")
+	a.Contains(stdout, "一致")
+	a.NotContains(stdout, "不一致")
+
+	code, _, _ = exec("replay", filepath.Join(t.TempDir(), "missing.jsonl"))
+	a.Equal(exitUsage, code)
+
+	code, _, _ = exec("unknown")
+	a.Equal(exitUsage, code)
+}
@@ -0,0 +1,76 @@
+package cmdtest
+
+import (
+	"github.com/teamManagement/common/cmd"
+	"testing"
+	"time"
+)
+
+// DefaultTimeout Call 等待一次命令交换完成的最长时间
+var DefaultTimeout = 5 * time.Second
+
+// Call 在内存中通过 router 执行一次命令交换, req 为发送的数据
+func Call(t testing.TB, router *cmd.Router, name cmd.Name, req any) (cmd.ExchangeData, error) {
+	t.Helper()
+	return CallWithOption(t, router, name, &cmd.ExchangeOption{
+		Data: req,
+	})
+}
+
+// CallWithOption 在内存中通过 router 执行一次命令交换, 超过 DefaultTimeout 未完成时测试失败
+func CallWithOption(t testing.TB, router *cmd.Router, name cmd.Name, option *cmd.ExchangeOption) (cmd.ExchangeData, error) {
+	t.Helper()
+
+	client, server := Pipe()
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		_ = router.ServeStream(server)
+	}()
+
+	type result struct {
+		data cmd.ExchangeData
+		err  error
+	}
+	resCh := make(chan result, 1)
+	go func() {
+		data, err := name.ExchangeWithOption(client.TransportStream(), option)
+		_ = client.Close()
+		resCh <- result{data: data, err: err}
+	}()
+
+	timer := time.NewTimer(DefaultTimeout)
+	defer timer.Stop()
+
+	var res result
+	select {
+	case res = <-resCh:
+	case <-timer.C:
+		t.Fatalf("命令[%s]交换超时", name)
+	}
+
+	select {
+	case <-served:
+	case <-timer.C:
+		t.Fatalf("命令[%s]的服务端处理超时", name)
+	}
+	return res.data, res.err
+}
+
+// MustCall 执行一次命令交换并将返回的JSON数据反序列化至 res, 交换失败时测试立即失败
+func MustCall(t testing.TB, router *cmd.Router, name cmd.Name, req any, res any) {
+	t.Helper()
+
+	data, err := Call(t, router, name, req)
+	if err != nil {
+		t.Fatalf("命令[%s]交换失败: %s", name, err.Error())
+	}
+
+	if res == nil {
+		return
+	}
+
+	if err = data.UnmarshalJson(res); err != nil {
+		t.Fatalf("命令[%s]返回的数据解析失败: %s", name, err.Error())
+	}
+}
@@ -0,0 +1,76 @@
+package cmdtest
+
+import (
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/teamManagement/common/cmd"
+	"github.com/teamManagement/common/compress"
+	"github.com/teamManagement/common/errors"
+	"strings"
+	"testing"
+)
+
+const echo cmd.Name = "/test/echo"
+
+func newEchoRouter() *cmd.Router {
+	router := cmd.NewRouter()
+	router.Handle(echo, func(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+		data, err := stream.ReceiveMsg()
+		if err != nil {
+			return nil, err
+		}
+
+		var msg string
+		if err = cmd.RequestOf(quicStream).Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		if msg == "" {
+			return nil, errors.ErrCodeValidation.New("消息不能为空")
+		}
+		return cmd.RequestOf(quicStream).Marshal(msg)
+	})
+	return router
+}
+
+func TestCall(t *testing.T) {
+	a := assert.New(t)
+	router := newEchoRouter()
+
+	var res string
+	MustCall(t, router, echo, "hello", &res)
+	a.Equal("hello", res)
+
+	_, err := Call(t, router, echo, "")
+	a.True(errors.ErrCodeValidation.Equal(err))
+
+	_, err = Call(t, router, "/test/undefined", nil)
+	a.True(errors.ErrCodeCommandUndefined.Equal(err))
+}
+
+func TestCallWithCompression(t *testing.T) {
+	a := assert.New(t)
+	router := newEchoRouter()
+
+	msg := strings.Repeat("team", 1024)
+	option := &cmd.ExchangeOption{Data: msg, Compress: true}
+	data, err := CallWithOption(t, router, echo, option)
+	if !a.NoError(err) {
+		return
+	}
+	a.Equal(compress.Snappy, option.ResponseHeader.Get(cmd.HeaderContentEncoding))
+
+	var res string
+	a.NoError(data.UnmarshalJson(&res))
+	a.Equal(msg, res)
+}
+
+func TestCallPayloadTooLarge(t *testing.T) {
+	a := assert.New(t)
+	router := newEchoRouter()
+
+	router.SetMaxPayloadSize(echo, 16)
+
+	_, err := Call(t, router, echo, strings.Repeat("a", 32))
+	a.True(errors.ErrCodePayloadTooLarge.Equal(err))
+}
@@ -0,0 +1,213 @@
+package cmdtest
+
+import (
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/teamManagement/common/cmd"
+	"github.com/teamManagement/common/errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// disconnectErrCode 模拟断开连接时使用的流错误码
+const disconnectErrCode quic.StreamErrorCode = 0
+
+// ReceivedCall 模拟服务器收到的一次命令调用
+type ReceivedCall struct {
+	// Name 命令名称
+	Name cmd.Name
+	// Header 请求头
+	Header cmd.Header
+	// Data 客户端发送的数据
+	Data cmd.ExchangeData
+	// At 收到命令的时间
+	At time.Time
+}
+
+// Expectation 对一个命令的预期及其模拟行为, 通过 MockServer.Expect 创建
+type Expectation struct {
+	name       cmd.Name
+	times      int
+	called     int
+	match      func(data cmd.ExchangeData) bool
+	response   cmd.ExchangeData
+	err        *transportstream.ErrInfo
+	delay      time.Duration
+	disconnect bool
+}
+
+// Return 设置返回的数据, 使用JSON序列化
+func (e *Expectation) Return(data any) *Expectation {
+	e.response = cmd.NewExchangeDataByJsonMust(data)
+	return e
+}
+
+// ReturnRaw 设置返回的原始数据
+func (e *Expectation) ReturnRaw(data cmd.ExchangeData) *Expectation {
+	e.response = data
+	return e
+}
+
+// ReturnError 设置返回的异常, 一般使用 errors 包中的错误码构建
+func (e *Expectation) ReturnError(err *transportstream.ErrInfo) *Expectation {
+	e.err = err
+	return e
+}
+
+// Delay 收到请求数据之后延迟返回
+func (e *Expectation) Delay(d time.Duration) *Expectation {
+	e.delay = d
+	return e
+}
+
+// Disconnect 收到请求数据之后直接断开流, 模拟交换过程中连接中断
+func (e *Expectation) Disconnect() *Expectation {
+	e.disconnect = true
+	return e
+}
+
+// Match 仅匹配请求数据满足条件的调用
+func (e *Expectation) Match(fn func(data cmd.ExchangeData) bool) *Expectation {
+	e.match = fn
+	return e
+}
+
+// Times 设置预期的调用次数, 默认为1次
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+// AnyTimes 不限制调用次数, 包括不被调用
+func (e *Expectation) AnyTimes() *Expectation {
+	e.times = -1
+	return e
+}
+
+func (e *Expectation) matches(data cmd.ExchangeData) bool {
+	if e.times >= 0 && e.called >= e.times {
+		return false
+	}
+	return e.match == nil || e.match(data)
+}
+
+// MockServer 可编排的模拟命令服务器, 用于测试客户端调用命令的逻辑
+type MockServer struct {
+	t      testing.TB
+	router *cmd.Router
+
+	lock         sync.Mutex
+	expectations []*Expectation
+	calls        []*ReceivedCall
+}
+
+// NewMockServer 创建模拟服务器, 测试结束时自动校验所有预期是否满足
+func NewMockServer(t testing.TB) *MockServer {
+	m := &MockServer{
+		t:      t,
+		router: cmd.NewRouter(),
+	}
+	t.Cleanup(m.AssertExpectations)
+	return m
+}
+
+// Expect 声明预期收到的命令, 同一命令的多个预期按声明顺序匹配
+func (m *MockServer) Expect(name cmd.Name) *Expectation {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	e := &Expectation{name: name, times: 1}
+	m.expectations = append(m.expectations, e)
+	m.router.Handle(name, m.handle)
+	return e
+}
+
+// Dial 创建一个连接至模拟服务器的客户端流
+func (m *MockServer) Dial() *transportstream.Stream {
+	client, server := Pipe()
+	go func() {
+		_ = m.router.ServeStream(server)
+	}()
+	return client.TransportStream()
+}
+
+// Calls 模拟服务器收到的所有命令调用
+func (m *MockServer) Calls() []*ReceivedCall {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]*ReceivedCall(nil), m.calls...)
+}
+
+// CallsOf 模拟服务器收到的指定命令的调用
+func (m *MockServer) CallsOf(name cmd.Name) []*ReceivedCall {
+	var res []*ReceivedCall
+	for _, call := range m.Calls() {
+		if call.Name == name {
+			res = append(res, call)
+		}
+	}
+	return res
+}
+
+// AssertExpectations 校验所有预期的调用次数是否满足
+func (m *MockServer) AssertExpectations() {
+	m.t.Helper()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, e := range m.expectations {
+		if e.times >= 0 && e.called != e.times {
+			m.t.Errorf("命令[%s]预期调用%d次, 实际调用%d次", e.name, e.times, e.called)
+		}
+	}
+}
+
+func (m *MockServer) handle(stream *transportstream.Stream, quicStream quic.Stream) (cmd.ExchangeData, error) {
+	data, err := stream.ReceiveMsg()
+	if err != nil {
+		return nil, err
+	}
+
+	request := cmd.RequestOf(quicStream)
+	e := m.record(&ReceivedCall{
+		Name:   request.Name,
+		Header: request.Header,
+		Data:   data,
+		At:     time.Now(),
+	})
+	if e == nil {
+		m.t.Errorf("模拟服务器收到未预期的命令[%s]", request.Name)
+		return nil, errors.ErrCodeCommandUndefined.Newf("命令[%s]未被预期", request.Name)
+	}
+
+	if e.delay > 0 {
+		time.Sleep(e.delay)
+	}
+
+	if e.disconnect {
+		quicStream.CancelWrite(disconnectErrCode)
+		quicStream.CancelRead(disconnectErrCode)
+		return nil, transportstream.StreamIsEnd
+	}
+
+	if e.err != nil {
+		return nil, e.err
+	}
+	return e.response, nil
+}
+
+// record 记录调用并返回匹配到的预期, 未匹配到时返回nil
+func (m *MockServer) record(call *ReceivedCall) *Expectation {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.calls = append(m.calls, call)
+	for _, e := range m.expectations {
+		if e.name == call.Name && e.matches(call.Data) {
+			e.called++
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package cmdtest
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/teamManagement/common/cmd"
+	"github.com/teamManagement/common/errors"
+	"testing"
+	"time"
+)
+
+func TestMockServer(t *testing.T) {
+	a := assert.New(t)
+
+	mock := NewMockServer(t)
+	mock.Expect(cmd.Login).Return("token")
+	mock.Expect(cmd.Login).ReturnError(errors.ErrCodeValidation.New("密码错误"))
+	mock.Expect(cmd.Forgot).Delay(10 * time.Millisecond).Disconnect()
+
+	data, err := cmd.Login.ExchangeWithData(map[string]string{"username": "admin"}, mock.Dial())
+	a.NoError(err)
+	var token string
+	a.NoError(data.UnmarshalJson(&token))
+	a.Equal("token", token)
+
+	_, err = cmd.Login.Exchange(mock.Dial())
+	a.True(errors.ErrCodeValidation.Equal(err))
+
+	start := time.Now()
+	_, err = cmd.Forgot.Exchange(mock.Dial())
+	a.Error(err)
+	a.GreaterOrEqual(time.Since(start), 10*time.Millisecond)
+
+	calls := mock.CallsOf(cmd.Login)
+	if a.Len(calls, 2) {
+		a.JSONEq(`{"username":"admin"}`, string(calls[0].Data))
+	}
+}
@@ -0,0 +1,11 @@
+package cmdtest
+
+import "github.com/teamManagement/common/internal/memstream"
+
+// Stream 基于内存管道实现的 quic.Stream, 用于在测试中代替真实的QUIC流
+type Stream = memstream.Stream
+
+// Pipe 创建一对相互连接的内存流, 一端写入的数据可从另一端读取
+func Pipe() (client *Stream, server *Stream) {
+	return memstream.Pipe()
+}
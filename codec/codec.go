@@ -0,0 +1,190 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gogo/protobuf/proto"
+	"github.com/vmihailenco/msgpack/v5"
+	"reflect"
+	"sync"
+)
+
+const (
+	// JSON JSON编解码, 未指定编解码器时的默认值
+	JSON = "json"
+	// Proto gogo protobuf 编解码
+	Proto = "proto"
+	// Msgpack msgpack 编解码
+	Msgpack = "msgpack"
+	// CBOR CBOR 编解码
+	CBOR = "cbor"
+	// Raw 原始字节, 不做任何编解码
+	Raw = "raw"
+)
+
+// Codec 数据编解码器
+type Codec interface {
+	// Name 编解码器名称, 同时作为协商时使用的 Content-Type
+	Name() string
+	// Marshal 编码
+	Marshal(v any) ([]byte, error)
+	// Unmarshal 解码
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	codecLock sync.RWMutex
+	codecMap  = map[string]Codec{}
+)
+
+func init() {
+	Register(jsonCodec{})
+	Register(protoCodec{})
+	Register(msgpackCodec{})
+	Register(cborCodec{})
+	Register(rawCodec{})
+}
+
+// Register 注册编解码器, 同名编解码器将被覆盖
+func Register(c Codec) {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+	codecMap[c.Name()] = c
+}
+
+// Get 获取已注册的编解码器, name 为空时返回 JSON 编解码器
+func Get(name string) (Codec, bool) {
+	if name == "" {
+		name = JSON
+	}
+
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	c, ok := codecMap[name]
+	return c, ok
+}
+
+// Marshal 使用指定名称的编解码器编码
+func Marshal(name string, v any) ([]byte, error) {
+	c, ok := Get(name)
+	if !ok {
+		return nil, fmt.Errorf("编解码器[%s]未注册", name)
+	}
+	return c.Marshal(v)
+}
+
+// Unmarshal 使用指定名称的编解码器解码
+func Unmarshal(name string, data []byte, v any) error {
+	c, ok := Get(name)
+	if !ok {
+		return fmt.Errorf("编解码器[%s]未注册", name)
+	}
+	return c.Unmarshal(data, v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return JSON
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type protoCodec struct{}
+
+func (protoCodec) Name() string {
+	return Proto
+}
+
+func (protoCodec) Marshal(v any) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("类型[%T]未实现 proto.Message", v)
+	}
+	return proto.Marshal(msg)
+}
+
+func (protoCodec) Unmarshal(data []byte, v any) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("类型[%T]未实现 proto.Message", v)
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return Msgpack
+}
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+type cborCodec struct{}
+
+func (cborCodec) Name() string {
+	return CBOR
+}
+
+func (cborCodec) Marshal(v any) ([]byte, error) {
+	return cbor.Marshal(v)
+}
+
+func (cborCodec) Unmarshal(data []byte, v any) error {
+	return cbor.Unmarshal(data, v)
+}
+
+var bytesType = reflect.TypeOf([]byte(nil))
+
+// rawCodec 原始字节编解码器, 支持 string 及底层类型为 []byte 的数据
+type rawCodec struct{}
+
+func (rawCodec) Name() string {
+	return Raw
+}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	switch t := v.(type) {
+	case []byte:
+		return t, nil
+	case string:
+		return []byte(t), nil
+	}
+
+	val := reflect.ValueOf(v)
+	if val.Kind() == reflect.Slice && val.Type().ConvertibleTo(bytesType) {
+		return val.Convert(bytesType).Interface().([]byte), nil
+	}
+	return nil, fmt.Errorf("原始字节编解码器不支持类型[%T]", v)
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	switch t := v.(type) {
+	case *[]byte:
+		*t = append((*t)[:0], data...)
+		return nil
+	case *string:
+		*t = string(data)
+		return nil
+	}
+
+	val := reflect.ValueOf(v)
+	if val.Kind() == reflect.Pointer && val.Elem().Kind() == reflect.Slice && bytesType.ConvertibleTo(val.Elem().Type()) {
+		val.Elem().Set(reflect.ValueOf(append([]byte(nil), data...)).Convert(val.Elem().Type()))
+		return nil
+	}
+	return fmt.Errorf("原始字节编解码器不支持类型[%T]", v)
+}
@@ -0,0 +1,50 @@
+package codec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type testData struct {
+	Name string `json:"name" msgpack:"name" cbor:"name"`
+	Age  int    `json:"age" msgpack:"age" cbor:"age"`
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	a := assert.New(t)
+
+	for _, name := range []string{"", JSON, Msgpack, CBOR} {
+		data, err := Marshal(name, &testData{Name: "team", Age: 3})
+		if !a.NoError(err, name) {
+			return
+		}
+
+		var res *testData
+		a.NoError(Unmarshal(name, data, &res), name)
+		a.Equal(&testData{Name: "team", Age: 3}, res, name)
+	}
+
+	_, err := Marshal("unknown", nil)
+	a.Error(err)
+}
+
+func TestRawCodec(t *testing.T) {
+	a := assert.New(t)
+
+	type bytesAlias []byte
+
+	data, err := Marshal(Raw, bytesAlias("raw"))
+	a.NoError(err)
+	a.Equal([]byte("raw"), data)
+
+	var str string
+	a.NoError(Unmarshal(Raw, data, &str))
+	a.Equal("raw", str)
+
+	var alias bytesAlias
+	a.NoError(Unmarshal(Raw, data, &alias))
+	a.Equal(bytesAlias("raw"), alias)
+
+	_, err = Marshal(Raw, 1)
+	a.Error(err)
+}
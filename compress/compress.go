@@ -0,0 +1,190 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/golang/snappy"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// Gzip gzip 压缩, 压缩率较高
+	Gzip = "gzip"
+	// Snappy snappy 压缩, 速度较快
+	Snappy = "snappy"
+)
+
+// DefaultThreshold 默认的压缩阈值, 数据小于该字节数时不压缩
+const DefaultThreshold = 1024
+
+// Compressor 数据压缩算法
+type Compressor interface {
+	// Name 算法名称, 协商时使用
+	Name() string
+	// Compress 压缩数据
+	Compress(data []byte) ([]byte, error)
+	// Decompress 解压数据, 解压之后的数据大于 limit 时返回 ErrTooLarge, limit 为0时不限制
+	Decompress(data []byte, limit int64) ([]byte, error)
+}
+
+// ErrTooLarge 解压之后的数据超出限制
+var ErrTooLarge = errors.New("解压之后的数据超出限制")
+
+var (
+	compressorLock sync.RWMutex
+	compressorMap  = map[string]Compressor{}
+	// preference 协商时的优先顺序, 速度较快的算法优先
+	preference = []string{Snappy, Gzip}
+)
+
+func init() {
+	Register(gzipCompressor{})
+	Register(snappyCompressor{})
+}
+
+// Register 注册压缩算法, 同名算法将被覆盖
+func Register(c Compressor) {
+	compressorLock.Lock()
+	defer compressorLock.Unlock()
+	compressorMap[c.Name()] = c
+}
+
+// Get 获取已注册的压缩算法
+func Get(name string) (Compressor, bool) {
+	compressorLock.RLock()
+	defer compressorLock.RUnlock()
+	c, ok := compressorMap[name]
+	return c, ok
+}
+
+// Names 已注册的压缩算法名称, 按名称排序
+func Names() []string {
+	compressorLock.RLock()
+	defer compressorLock.RUnlock()
+
+	names := make([]string, 0, len(compressorMap))
+	for name := range compressorMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetPreference 设置协商压缩算法时的优先顺序, 未列出的已注册算法按名称排在之后
+func SetPreference(names ...string) {
+	compressorLock.Lock()
+	defer compressorLock.Unlock()
+	preference = append([]string(nil), names...)
+}
+
+// Preferred 已注册的压缩算法名称, 按协商时的优先顺序排列, 用于向对端声明支持的算法
+func Preferred() []string {
+	names := Names()
+
+	compressorLock.RLock()
+	defer compressorLock.RUnlock()
+
+	rank := make(map[string]int, len(preference))
+	for i, name := range preference {
+		if _, ok := rank[name]; !ok {
+			rank[name] = i
+		}
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		ri, iok := rank[names[i]]
+		rj, jok := rank[names[j]]
+		if iok != jok {
+			return iok
+		}
+		return iok && ri < rj
+	})
+	return names
+}
+
+// Negotiate 从对端声明支持的算法中按本端的优先顺序选择算法, accept 为逗号分隔的算法名称, 没有共同支持的算法时返回false
+func Negotiate(accept string) (Compressor, bool) {
+	accepted := map[string]bool{}
+	for _, name := range strings.Split(accept, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			accepted[name] = true
+		}
+	}
+
+	for _, name := range Preferred() {
+		if accepted[name] {
+			return Get(name)
+		}
+	}
+	return nil, false
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string {
+	return Gzip
+}
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("gzip压缩数据失败: %s", err.Error())
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("gzip压缩数据失败: %s", err.Error())
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte, limit int64) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("gzip解压数据失败: %s", err.Error())
+	}
+	defer r.Close()
+
+	var reader io.Reader = r
+	if limit > 0 {
+		reader = io.LimitReader(r, limit+1)
+	}
+
+	res, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("gzip解压数据失败: %s", err.Error())
+	}
+	if limit > 0 && int64(len(res)) > limit {
+		return nil, ErrTooLarge
+	}
+	return res, nil
+}
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) Name() string {
+	return Snappy
+}
+
+func (snappyCompressor) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (snappyCompressor) Decompress(data []byte, limit int64) ([]byte, error) {
+	l, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, fmt.Errorf("snappy解压数据失败: %s", err.Error())
+	}
+	if limit > 0 && int64(l) > limit {
+		return nil, ErrTooLarge
+	}
+
+	res, err := snappy.Decode(nil, data)
+	if err != nil {
+		return nil, fmt.Errorf("snappy解压数据失败: %s", err.Error())
+	}
+	return res, nil
+}
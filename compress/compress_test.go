@@ -0,0 +1,58 @@
+package compress
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	a := assert.New(t)
+
+	data := bytes.Repeat([]byte("team"), 1024)
+	for _, name := range Names() {
+		c, ok := Get(name)
+		if !a.True(ok, name) {
+			return
+		}
+
+		compressed, err := c.Compress(data)
+		a.NoError(err, name)
+		a.Less(len(compressed), len(data), name)
+
+		res, err := c.Decompress(compressed, int64(len(data)))
+		a.NoError(err, name)
+		a.Equal(data, res, name)
+
+		_, err = c.Decompress(compressed, int64(len(data)-1))
+		a.Equal(ErrTooLarge, err, name)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal([]string{Snappy, Gzip}, Preferred())
+
+	// 按本端的优先顺序选择, 与对端的声明顺序无关
+	c, ok := Negotiate("gzip, snappy")
+	if a.True(ok) {
+		a.Equal(Snappy, c.Name())
+	}
+	c, ok = Negotiate("br,gzip")
+	if a.True(ok) {
+		a.Equal(Gzip, c.Name())
+	}
+	_, ok = Negotiate("br")
+	a.False(ok)
+	_, ok = Negotiate("")
+	a.False(ok)
+
+	defer SetPreference(Snappy, Gzip)
+	SetPreference(Gzip)
+	a.Equal([]string{Gzip, Snappy}, Preferred())
+	c, ok = Negotiate("snappy,gzip")
+	if a.True(ok) {
+		a.Equal(Gzip, c.Name())
+	}
+}
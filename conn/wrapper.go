@@ -2,12 +2,18 @@ package conn
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-base-lib/goextension"
 	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/teamManagement/common/compress"
+	commonErrors "github.com/teamManagement/common/errors"
+	"github.com/teamManagement/common/record"
 	"net"
+	"strings"
+	"sync"
 )
 
 type MessageType uint
@@ -22,6 +28,8 @@ type MessageInfo struct {
 	ErrCode uint        `json:"errCode,omitempty"`
 	Message string      `json:"message,omitempty"`
 	Data    []byte      `json:"data,omitempty"`
+	// AcceptEncoding 发送方支持的压缩算法, 多个算法之间使用逗号分隔, 开启协商压缩时携带
+	AcceptEncoding string `json:"acceptEncoding,omitempty"`
 }
 
 func NewErrorMessageInfo(msg string) *MessageInfo {
@@ -36,15 +44,125 @@ func NewErrorMessageInfoWithCode(code uint, msg string) *MessageInfo {
 	}
 }
 
+// DefaultMaxFrameSize 默认的单帧数据最大字节数
+const DefaultMaxFrameSize int64 = 32 << 20
+
+// compressedFrameMark 压缩帧的首字节, 未压缩的帧为JSON数据, 首字节不会为0
+const compressedFrameMark byte = 0
+
 type Wrapper struct {
-	rw  *bufio.ReadWriter
-	err error
+	rw           *bufio.ReadWriter
+	err          error
+	maxFrameSize int64
+
+	compressor        compress.Compressor
+	compressThreshold int
+	negotiate         bool
+
+	recorder     *record.Recorder
+	recordStream uint64
+	recordSide   record.Side
+	redactLock   sync.Mutex
+	redactor     record.Redactor
 }
 
 func NewWrapper(conn net.Conn) *Wrapper {
 	return &Wrapper{
-		rw: bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)),
+		rw:           bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)),
+		maxFrameSize: DefaultMaxFrameSize,
+	}
+}
+
+// EnableCompression 开启协商压缩, 写出的每个数据帧中携带本端支持的压缩算法,
+// 收到对端声明的算法之后按 compress.Preferred 的顺序选择双方都支持的算法压缩之后写出的数据帧,
+// 数据大于等于 threshold 字节时才进行压缩; 对端未开启协商时不压缩
+func (w *Wrapper) EnableCompression(threshold int) *Wrapper {
+	w.negotiate = true
+	w.compressThreshold = threshold
+	return w
+}
+
+// SetCompression 手动设置写出数据帧时使用的压缩算法, 不经过协商, 数据大于等于 threshold 字节时才进行压缩,
+// name 为空时不压缩并关闭协商. 读取时自动识别压缩帧, 需确保对端同样支持压缩帧之后再开启
+func (w *Wrapper) SetCompression(name string, threshold int) *Wrapper {
+	return w.wrapperError(func() error {
+		w.negotiate = false
+		if name == "" {
+			w.compressor = nil
+			return nil
+		}
+
+		c, ok := compress.Get(name)
+		if !ok {
+			return fmt.Errorf("不支持的压缩算法: %s", name)
+		}
+		w.compressor = c
+		w.compressThreshold = threshold
+		return nil
+	})
+}
+
+// SetRecorder 记录会话中读写的每个数据帧, 记录的内容为压缩之前的帧, rec 为nil时停止记录;
+// rec 设置了 Redactor 时记录之前先脱敏, Redactor 收到的 Payload 为消息中的 Data
+func (w *Wrapper) SetRecorder(rec *record.Recorder, side record.Side) *Wrapper {
+	w.recorder = rec
+	w.recordSide = side
+	w.redactor = nil
+	if rec != nil {
+		w.recordStream = rec.NewStream()
+		w.redactor = rec.NewRedactor()
 	}
+	return w
+}
+
+func (w *Wrapper) record(direction record.Direction, flag byte, payload []byte) {
+	if w.recorder == nil {
+		return
+	}
+	entry := &record.Entry{
+		Stream:    w.recordStream,
+		Side:      w.recordSide,
+		Direction: direction,
+		Flag:      flag,
+		Payload:   payload,
+	}
+	if w.redactor != nil {
+		w.redact(entry)
+	}
+	w.recorder.Record(entry)
+}
+
+// redact 对消息中的 Data 脱敏, 单字节数据直接交给 Redactor
+func (w *Wrapper) redact(entry *record.Entry) {
+	w.redactLock.Lock()
+	defer w.redactLock.Unlock()
+
+	if entry.Flag == record.FlagRaw {
+		w.redactor.Redact(entry)
+		return
+	}
+
+	var messageInfo *MessageInfo
+	if err := json.Unmarshal(entry.Payload, &messageInfo); err != nil || messageInfo == nil {
+		return
+	}
+	data := *entry
+	data.Payload = messageInfo.Data
+	w.redactor.Redact(&data)
+	if bytes.Equal(data.Payload, messageInfo.Data) {
+		return
+	}
+
+	messageInfo.Data = data.Payload
+	if marshal, err := json.Marshal(messageInfo); err == nil {
+		entry.Payload = marshal
+	}
+}
+
+// SetMaxFrameSize 设置读取时单帧数据的最大字节数, 等于0时不限制
+func (w *Wrapper) SetMaxFrameSize(size int64) *Wrapper {
+	w.maxFrameSize = size
+	return w
 }
 
 func (w *Wrapper) Error() error {
@@ -67,7 +185,11 @@ func (w *Wrapper) WriteByte(b byte) *Wrapper {
 		if err := w.rw.WriteByte(b); err != nil {
 			return err
 		}
-		return w.rw.Flush()
+		if err := w.rw.Flush(); err != nil {
+			return err
+		}
+		w.record(record.DirectionSend, record.FlagRaw, []byte{b})
+		return nil
 	})
 }
 
@@ -76,8 +198,7 @@ func (w *Wrapper) WriteErrMessage(msg string) *Wrapper {
 }
 
 func (w *Wrapper) WriteErrMessageWithCode(errCode uint, msg string) *Wrapper {
-	marshal, _ := json.Marshal(NewErrorMessageInfoWithCode(errCode, msg))
-	return w.writeBytes(marshal)
+	return w.writeMessage(NewErrorMessageInfoWithCode(errCode, msg))
 }
 
 func (w *Wrapper) WriteFormatJsonData(data any) *Wrapper {
@@ -94,16 +215,27 @@ func (w *Wrapper) WriteFormatJsonData(data any) *Wrapper {
 }
 
 func (w *Wrapper) WriteFormatBytesData(data []byte) *Wrapper {
-	marshal, _ := json.Marshal(&MessageInfo{
+	return w.writeMessage(&MessageInfo{
 		Type: MessageTypeSuccess,
 		Data: data,
 	})
+}
 
-	return w.writeBytes(marshal)
+// writeMessage 写出消息, 开启协商压缩时在消息中声明本端支持的压缩算法
+func (w *Wrapper) writeMessage(info *MessageInfo) *Wrapper {
+	if w.negotiate {
+		info.AcceptEncoding = strings.Join(compress.Preferred(), ",")
+	}
+	marshal, _ := json.Marshal(info)
+	return w.writeBytes(info.Type, marshal)
 }
 
-func (w *Wrapper) writeBytes(data []byte) *Wrapper {
+func (w *Wrapper) writeBytes(messageType MessageType, frame []byte) *Wrapper {
 	return w.wrapperError(func() error {
+		data, err := w.compressFrame(frame)
+		if err != nil {
+			return err
+		}
 
 		dataLen := len(data)
 		lenBytes, err := transportstream.IntToBytes[int64](int64(dataLen))
@@ -118,7 +250,11 @@ func (w *Wrapper) writeBytes(data []byte) *Wrapper {
 		if _, err = w.rw.Write(data); err != nil {
 			return err
 		}
-		return w.rw.Flush()
+		if err = w.rw.Flush(); err != nil {
+			return err
+		}
+		w.record(record.DirectionSend, byte(messageType), frame)
+		return nil
 	})
 }
 
@@ -133,15 +269,29 @@ func (w *Wrapper) ReadeFormatBytesData() (goextension.Bytes, error) {
 		return nil, err
 	}
 
+	// 超出限制时帧内容未被读取, 连接已无法继续使用
+	if dataLen < 0 || (w.maxFrameSize > 0 && dataLen > w.maxFrameSize) {
+		return nil, commonErrors.ErrCodePayloadTooLarge.Newf("数据帧长度[%d]超出限制: %d 字节", dataLen, w.maxFrameSize)
+	}
+
 	wrapperBytes, err := w.ReadeCountBytes(dataLen)
 	if err != nil {
 		return nil, err
 	}
 
+	if wrapperBytes, err = w.decompressFrame(wrapperBytes); err != nil {
+		return nil, err
+	}
+
 	var messageInfo *MessageInfo
 	if err = json.Unmarshal(wrapperBytes, &messageInfo); err != nil {
 		return nil, fmt.Errorf("数据格式解析失败: %s", err.Error())
 	}
+	w.record(record.DirectionReceive, byte(messageInfo.Type), wrapperBytes)
+
+	if w.negotiate && messageInfo.AcceptEncoding != "" {
+		w.compressor, _ = compress.Negotiate(messageInfo.AcceptEncoding)
+	}
 
 	if messageInfo.Type == MessageTypeSuccess {
 		return messageInfo.Data, nil
@@ -150,6 +300,49 @@ func (w *Wrapper) ReadeFormatBytesData() (goextension.Bytes, error) {
 	return nil, errors.New(messageInfo.Message)
 }
 
+// compressFrame 压缩帧格式: 标识(1字节) + 算法名称长度(1字节) + 算法名称 + 压缩之后的数据
+func (w *Wrapper) compressFrame(data []byte) ([]byte, error) {
+	if w.compressor == nil || len(data) < w.compressThreshold {
+		return data, nil
+	}
+
+	compressed, err := w.compressor.Compress(data)
+	if err != nil {
+		return nil, err
+	}
+
+	name := w.compressor.Name()
+	frame := make([]byte, 0, len(compressed)+len(name)+2)
+	frame = append(frame, compressedFrameMark, byte(len(name)))
+	frame = append(frame, name...)
+	if len(frame)+len(compressed) >= len(data) {
+		return data, nil
+	}
+	return append(frame, compressed...), nil
+}
+
+func (w *Wrapper) decompressFrame(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != compressedFrameMark {
+		return data, nil
+	}
+
+	if len(data) < 2 || len(data) < int(data[1])+2 {
+		return nil, fmt.Errorf("压缩帧格式错误")
+	}
+
+	name := string(data[2 : data[1]+2])
+	c, ok := compress.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("不支持的压缩算法: %s", name)
+	}
+
+	res, err := c.Decompress(data[data[1]+2:], w.maxFrameSize)
+	if err == compress.ErrTooLarge {
+		return nil, commonErrors.ErrCodePayloadTooLarge.Newf("数据帧解压之后超出限制: %d 字节", w.maxFrameSize)
+	}
+	return res, err
+}
+
 func (w *Wrapper) ReadeCountBytes(count int64) (goextension.Bytes, error) {
 	buf := make([]byte, count)
 	for i := int64(0); i < count; i++ {
@@ -172,5 +365,9 @@ func (w *Wrapper) ReadFormatJsonData(res any) error {
 }
 
 func (w *Wrapper) ReadByte() (byte, error) {
-	return w.rw.ReadByte()
+	b, err := w.rw.ReadByte()
+	if err == nil {
+		w.record(record.DirectionReceive, record.FlagRaw, []byte{b})
+	}
+	return b, err
 }
@@ -3,6 +3,8 @@ package errors
 import (
 	"fmt"
 	transportstream "github.com/go-base-lib/transport-stream"
+	"sort"
+	"time"
 )
 
 const (
@@ -16,8 +18,109 @@ const (
 	ErrCodeValidation
 	// ErrServerInside 服务器内部异常
 	ErrServerInside
+	// ErrCodeServerDraining 服务器正在下线排空, 不再接收新的命令
+	ErrCodeServerDraining
+	// ErrCodeTooManyRequests 请求过于频繁, 异常数据中携带 RetryInfo
+	ErrCodeTooManyRequests
+	// ErrCodeServerBusy 服务器繁忙, 命令的并发执行数已达上限
+	ErrCodeServerBusy
+	// ErrCodePayloadTooLarge 数据包大小超出限制
+	ErrCodePayloadTooLarge
+	// ErrCodeUnsupportedCodec 不支持的数据编解码器
+	ErrCodeUnsupportedCodec
+	// ErrCodeBatchSkipped 批量执行中因前序命令失败而未执行
+	ErrCodeBatchSkipped
+	// ErrCodeCancelled 执行已被取消
+	ErrCodeCancelled
+	// ErrCodeJobNotFound 异步任务不存在或已过期
+	ErrCodeJobNotFound
+	// ErrCodeJobNotFinished 异步任务尚未执行完成
+	ErrCodeJobNotFinished
+	// ErrCodeSlowConsumer 订阅者消费过慢, 订阅已被断开
+	ErrCodeSlowConsumer
+	// ErrCodeCircuitOpen 客户端熔断器已打开, 请求未发送至服务端, 异常数据中携带 RetryInfo
+	ErrCodeCircuitOpen
+	// ErrCodeSessionClosed 会话已被管理员强制关闭, 作为QUIC连接的关闭码发送
+	ErrCodeSessionClosed
+	// ErrCodePermissionDenied 无权执行命令或订阅主题
+	ErrCodePermissionDenied
+	// ErrCodeProgress 进度消息, 并非异常, 仅在客户端声明接收进度时由服务端发送
+	ErrCodeProgress
 )
 
+var codeNames = map[transportstream.ErrCode]string{
+	ErrCodeUnknown:          "Unknown",
+	ErrCodeReadCommand:      "ReadCommand",
+	ErrCodeCommandUndefined: "CommandUndefined",
+	ErrCodeValidation:       "Validation",
+	ErrServerInside:         "ServerInside",
+	ErrCodeServerDraining:   "ServerDraining",
+	ErrCodeTooManyRequests:  "TooManyRequests",
+	ErrCodeServerBusy:       "ServerBusy",
+	ErrCodePayloadTooLarge:  "PayloadTooLarge",
+	ErrCodeUnsupportedCodec: "UnsupportedCodec",
+	ErrCodeBatchSkipped:     "BatchSkipped",
+	ErrCodeCancelled:        "Cancelled",
+	ErrCodeJobNotFound:      "JobNotFound",
+	ErrCodeJobNotFinished:   "JobNotFinished",
+	ErrCodeSlowConsumer:     "SlowConsumer",
+	ErrCodeCircuitOpen:      "CircuitOpen",
+	ErrCodeSessionClosed:    "SessionClosed",
+	ErrCodePermissionDenied: "PermissionDenied",
+	ErrCodeProgress:         "Progress",
+}
+
+// CodeName 异常码的名称, 用于日志及命令行输出, 未知的异常码返回其数值
+func CodeName(code transportstream.ErrCode) string {
+	if name, ok := codeNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("ErrCode(%d)", uint(code))
+}
+
+// Codes 所有已定义的异常码, 按数值排序, 不包含仅用于传递进度的 ErrCodeProgress
+func Codes() []transportstream.ErrCode {
+	codes := make([]transportstream.ErrCode, 0, len(codeNames))
+	for code := range codeNames {
+		if code != ErrCodeProgress {
+			codes = append(codes, code)
+		}
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		return codes[i] < codes[j]
+	})
+	return codes
+}
+
+// RetryInfo 可重试异常中携带的重试建议
+type RetryInfo struct {
+	// RetryAfter 建议的重试等待时间, 单位毫秒
+	RetryAfter int64 `json:"retryAfter"`
+}
+
+// TooManyRequests 构建请求过于频繁的异常, retryAfter 为建议的重试等待时间
+func TooManyRequests(msg string, retryAfter time.Duration) *transportstream.ErrInfo {
+	errInfo, err := ErrCodeTooManyRequests.NewWithData(msg, &RetryInfo{RetryAfter: retryAfter.Milliseconds()})
+	if err != nil {
+		return ErrCodeTooManyRequests.New(msg)
+	}
+	return errInfo
+}
+
+// RetryAfter 从异常中获取建议的重试等待时间, 异常中未携带 RetryInfo 时返回false
+func RetryAfter(err error) (time.Duration, bool) {
+	errInfo, ok := transportstream.ErrConvert(err)
+	if !ok || errInfo.RawData == nil {
+		return 0, false
+	}
+
+	var info *RetryInfo
+	if e := errInfo.UnmarshalData(&info); e != nil || info == nil {
+		return 0, false
+	}
+	return time.Duration(info.RetryAfter) * time.Millisecond, true
+}
+
 func ErrorByErr(err error) *transportstream.ErrInfo {
 	targetErr, ok := transportstream.ErrConvert(err)
 	if ok {
@@ -0,0 +1,95 @@
+package memstream
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// pipeBuffer 单向的内存管道, 写入不会阻塞, 避免交换双方同时写入时死锁
+type pipeBuffer struct {
+	lock     sync.Mutex
+	cond     *sync.Cond
+	buf      bytes.Buffer
+	err      error
+	deadline time.Time
+	timer    *time.Timer
+}
+
+func newPipeBuffer() *pipeBuffer {
+	p := &pipeBuffer{}
+	p.cond = sync.NewCond(&p.lock)
+	return p
+}
+
+func (p *pipeBuffer) Read(b []byte) (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for p.buf.Len() == 0 {
+		if p.err != nil {
+			return 0, p.err
+		}
+		if !p.deadline.IsZero() && !time.Now().Before(p.deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		p.cond.Wait()
+	}
+	return p.buf.Read(b)
+}
+
+func (p *pipeBuffer) Write(b []byte) (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.err != nil {
+		return 0, io.ErrClosedPipe
+	}
+	if !p.deadline.IsZero() && !time.Now().Before(p.deadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	n, _ := p.buf.Write(b)
+	p.cond.Broadcast()
+	return n, nil
+}
+
+// closeWithError 关闭管道, 缓存中的数据读取完毕之后返回 err
+func (p *pipeBuffer) closeWithError(err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.err == nil {
+		p.err = err
+	}
+	p.cond.Broadcast()
+}
+
+// discard 丢弃缓存中未读取的数据并关闭管道
+func (p *pipeBuffer) discard(err error) {
+	p.lock.Lock()
+	p.buf.Reset()
+	p.lock.Unlock()
+	p.closeWithError(err)
+}
+
+func (p *pipeBuffer) setDeadline(t time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.deadline = t
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	if !t.IsZero() {
+		p.timer = time.AfterFunc(time.Until(t), func() {
+			p.lock.Lock()
+			defer p.lock.Unlock()
+			p.cond.Broadcast()
+		})
+	}
+	p.cond.Broadcast()
+}
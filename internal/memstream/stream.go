@@ -0,0 +1,104 @@
+package memstream
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"github.com/lucas-clemente/quic-go"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var streamIdSeq int64
+
+// Stream 基于内存管道实现的 quic.Stream, 用于进程内的命令交换及测试
+type Stream struct {
+	id     quic.StreamID
+	reader *pipeBuffer
+	writer *pipeBuffer
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	once            sync.Once
+	transportStream *transportstream.Stream
+}
+
+var _ quic.Stream = (*Stream)(nil)
+
+// Pipe 创建一对相互连接的内存流, 一端写入的数据可从另一端读取
+func Pipe() (client *Stream, server *Stream) {
+	id := quic.StreamID(atomic.AddInt64(&streamIdSeq, 1) * 4)
+	clientToServer, serverToClient := newPipeBuffer(), newPipeBuffer()
+	return newStream(id, serverToClient, clientToServer), newStream(id, clientToServer, serverToClient)
+}
+
+func newStream(id quic.StreamID, reader, writer *pipeBuffer) *Stream {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Stream{
+		id:     id,
+		reader: reader,
+		writer: writer,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// TransportStream 获取基于该流构建的 transportstream.Stream, 多次调用返回同一个实例
+func (s *Stream) TransportStream() *transportstream.Stream {
+	s.once.Do(func() {
+		s.transportStream = transportstream.NewStream(bufio.NewReadWriter(bufio.NewReader(s), bufio.NewWriter(s)))
+	})
+	return s.transportStream
+}
+
+func (s *Stream) StreamID() quic.StreamID {
+	return s.id
+}
+
+func (s *Stream) Read(p []byte) (int, error) {
+	return s.reader.Read(p)
+}
+
+func (s *Stream) Write(p []byte) (int, error) {
+	return s.writer.Write(p)
+}
+
+// Close 关闭写入端, 对端读取完剩余数据之后将收到 io.EOF
+func (s *Stream) Close() error {
+	s.writer.closeWithError(io.EOF)
+	s.cancel()
+	return nil
+}
+
+func (s *Stream) CancelRead(code quic.StreamErrorCode) {
+	s.reader.discard(fmt.Errorf("流读取已被取消, 错误码: %d", code))
+}
+
+func (s *Stream) CancelWrite(code quic.StreamErrorCode) {
+	s.writer.discard(fmt.Errorf("流写入已被对端取消, 错误码: %d", code))
+	s.cancel()
+}
+
+// Context 写入端关闭之后被取消
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *Stream) SetReadDeadline(t time.Time) error {
+	s.reader.setDeadline(t)
+	return nil
+}
+
+func (s *Stream) SetWriteDeadline(t time.Time) error {
+	s.writer.setDeadline(t)
+	return nil
+}
+
+func (s *Stream) SetDeadline(t time.Time) error {
+	_ = s.SetReadDeadline(t)
+	return s.SetWriteDeadline(t)
+}
@@ -0,0 +1,298 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"sync"
+)
+
+const (
+	// Argon2id argon2id 算法, 默认使用的算法
+	Argon2id = "argon2id"
+	// Bcrypt bcrypt 算法, 用于兼容已有的密码哈希
+	Bcrypt = "bcrypt"
+)
+
+// DefaultBcryptCost 默认的 bcrypt 计算成本
+const DefaultBcryptCost = 12
+
+// bcryptMaxLength bcrypt 只使用密码的前72个字节, 超出时拒绝, 避免不同的密码得到相同的哈希
+const bcryptMaxLength = 72
+
+// 校验时 argon2id 哈希中参数的上限, 避免被篡改的哈希耗尽内存或CPU, 内存单位为 KiB, 即 256 MiB
+const (
+	maxArgon2idMemory      = 256 << 10
+	maxArgon2idIterations  = 10
+	maxArgon2idParallelism = 16
+	maxArgon2idKeyLength   = 128
+)
+
+var (
+	// ErrMismatch 密码与哈希不匹配
+	ErrMismatch = errors.New("密码不正确")
+	// ErrUnsupported 无法识别哈希的算法
+	ErrUnsupported = errors.New("不支持的密码哈希算法")
+)
+
+// Hasher 密码哈希算法, 哈希结果中包含算法名称及参数, 参数变更之后已有的哈希仍可以校验
+type Hasher interface {
+	// Name 算法名称
+	Name() string
+	// Hash 计算密码的哈希, 每次使用随机的盐
+	Hash(password string) (string, error)
+	// Verify 使用哈希中记录的参数以常量时间校验密码, 不匹配时返回 ErrMismatch
+	Verify(password, encoded string) error
+	// NeedsRehash 哈希的算法或参数与当前的设置不同, 需要在校验通过之后重新计算; 无法解析的哈希同样返回true
+	NeedsRehash(encoded string) bool
+}
+
+// Argon2idParams argon2id 的参数
+type Argon2idParams struct {
+	// Memory 使用的内存, 单位 KiB
+	Memory uint32
+	// Iterations 迭代次数
+	Iterations uint32
+	// Parallelism 并行度
+	Parallelism uint8
+	// SaltLength 盐的字节数
+	SaltLength uint32
+	// KeyLength 哈希的字节数
+	KeyLength uint32
+}
+
+// DefaultArgon2idParams 默认的 argon2id 参数, 参考 RFC 9106 中内存受限环境的推荐值
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+type argon2idHasher struct {
+	params Argon2idParams
+}
+
+// NewArgon2id 创建 argon2id 算法, params 为nil时使用 DefaultArgon2idParams, 其中为0的参数同样使用默认值;
+// 内存、迭代次数、并行度或哈希长度超出校验时的上限时, 计算得到的哈希将无法通过校验
+func NewArgon2id(params *Argon2idParams) Hasher {
+	h := &argon2idHasher{params: DefaultArgon2idParams}
+	if params == nil {
+		return h
+	}
+	if params.Memory > 0 {
+		h.params.Memory = params.Memory
+	}
+	if params.Iterations > 0 {
+		h.params.Iterations = params.Iterations
+	}
+	if params.Parallelism > 0 {
+		h.params.Parallelism = params.Parallelism
+	}
+	if params.SaltLength > 0 {
+		h.params.SaltLength = params.SaltLength
+	}
+	if params.KeyLength > 0 {
+		h.params.KeyLength = params.KeyLength
+	}
+	return h
+}
+
+func (h *argon2idHasher) Name() string {
+	return Argon2id
+}
+
+// Hash 哈希的格式为 $argon2id$v=19$m=65536,t=3,p=2$<盐>$<哈希>, 盐及哈希为不带填充的 base64 编码
+func (h *argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("生成随机盐失败: %s", err.Error())
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, h.params.KeyLength)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", Argon2id, argon2.Version,
+		h.params.Memory, h.params.Iterations, h.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *argon2idHasher) Verify(password, encoded string) error {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+
+	actual := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	if subtle.ConstantTimeCompare(actual, key) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func (h *argon2idHasher) NeedsRehash(encoded string) bool {
+	params, _, _, err := decodeArgon2id(encoded)
+	return err != nil || *params != h.params
+}
+
+// decodeArgon2id 解析 argon2id 哈希, 返回的参数中盐及哈希的字节数为实际的长度
+func decodeArgon2id(encoded string) (*Argon2idParams, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != Argon2id {
+		return nil, nil, nil, ErrUnsupported
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, nil, nil, fmt.Errorf("argon2id 哈希的版本格式错误: %s", err.Error())
+	}
+	if version != argon2.Version {
+		return nil, nil, nil, fmt.Errorf("不支持的 argon2 版本: %d", version)
+	}
+
+	params := &Argon2idParams{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return nil, nil, nil, fmt.Errorf("argon2id 哈希的参数格式错误: %s", err.Error())
+	}
+	if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		return nil, nil, nil, fmt.Errorf("argon2id 哈希的参数无效: %s", parts[3])
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("argon2id 哈希的盐格式错误: %s", err.Error())
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("argon2id 哈希格式错误: %s", err.Error())
+	}
+	if len(salt) == 0 || len(key) == 0 {
+		return nil, nil, nil, fmt.Errorf("argon2id 哈希的盐或哈希为空")
+	}
+	if params.Memory > maxArgon2idMemory || params.Iterations > maxArgon2idIterations ||
+		params.Parallelism > maxArgon2idParallelism || len(key) > maxArgon2idKeyLength {
+		return nil, nil, nil, fmt.Errorf("argon2id 哈希的参数超出上限: %s, 哈希长度 %d", parts[3], len(key))
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+type bcryptHasher struct {
+	cost int
+}
+
+// NewBcrypt 创建 bcrypt 算法, cost 为0时使用 DefaultBcryptCost, 密码超出72字节时无法计算哈希
+func NewBcrypt(cost int) (Hasher, error) {
+	if cost == 0 {
+		cost = DefaultBcryptCost
+	}
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt 的计算成本应在 %d 至 %d 之间: %d", bcrypt.MinCost, bcrypt.MaxCost, cost)
+	}
+	return &bcryptHasher{cost: cost}, nil
+}
+
+func (h *bcryptHasher) Name() string {
+	return Bcrypt
+}
+
+func (h *bcryptHasher) Hash(password string) (string, error) {
+	if len(password) > bcryptMaxLength {
+		return "", fmt.Errorf("bcrypt 的密码不能超出 %d 字节", bcryptMaxLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	if err != nil {
+		return "", fmt.Errorf("计算 bcrypt 哈希失败: %s", err.Error())
+	}
+	return string(hash), nil
+}
+
+func (h *bcryptHasher) Verify(password, encoded string) error {
+	if !isBcrypt(encoded) {
+		return ErrUnsupported
+	}
+	if len(password) > bcryptMaxLength {
+		return ErrMismatch
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrMismatch
+	}
+	if err != nil {
+		return fmt.Errorf("bcrypt 哈希格式错误: %s", err.Error())
+	}
+	return nil
+}
+
+func (h *bcryptHasher) NeedsRehash(encoded string) bool {
+	if !isBcrypt(encoded) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.cost
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+var (
+	defaultLock   sync.RWMutex
+	defaultHasher = NewArgon2id(nil)
+)
+
+// SetDefault 设置 Hash 及 NeedsRehash 使用的算法, 已有的其他算法的哈希仍可以通过 Verify 校验
+func SetDefault(h Hasher) {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	defaultHasher = h
+}
+
+// Default 获取 Hash 及 NeedsRehash 使用的算法, 默认为使用 DefaultArgon2idParams 的 argon2id
+func Default() Hasher {
+	defaultLock.RLock()
+	defer defaultLock.RUnlock()
+	return defaultHasher
+}
+
+// Hash 使用默认的算法计算密码的哈希
+func Hash(password string) (string, error) {
+	return Default().Hash(password)
+}
+
+// Verify 根据哈希中记录的算法及参数校验密码, 不匹配时返回 ErrMismatch, 无法识别算法时返回 ErrUnsupported
+func Verify(password, encoded string) error {
+	switch {
+	case strings.HasPrefix(encoded, "$"+Argon2id+"$"):
+		return (&argon2idHasher{}).Verify(password, encoded)
+	case isBcrypt(encoded):
+		return (&bcryptHasher{}).Verify(password, encoded)
+	default:
+		return ErrUnsupported
+	}
+}
+
+// NeedsRehash 哈希的算法或参数是否与默认的算法不同
+func NeedsRehash(encoded string) bool {
+	return Default().NeedsRehash(encoded)
+}
+
+// VerifyAndRehash 校验密码, 校验通过且哈希需要更新时返回使用默认算法重新计算的哈希, 调用方应将其保存以替换原有的哈希;
+// 无需更新时返回空字符串
+func VerifyAndRehash(password, encoded string) (string, error) {
+	if err := Verify(password, encoded); err != nil {
+		return "", err
+	}
+	if !NeedsRehash(encoded) {
+		return "", nil
+	}
+	return Hash(password)
+}
@@ -0,0 +1,161 @@
+package password
+
+import (
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	commonErrors "github.com/teamManagement/common/errors"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testArgon2idParams 降低计算成本以加快测试
+var testArgon2idParams = &Argon2idParams{Memory: 1024, Iterations: 1, Parallelism: 1}
+
+func TestArgon2id(t *testing.T) {
+	a := assert.New(t)
+
+	h := NewArgon2id(testArgon2idParams)
+	encoded, err := h.Hash("correct horse")
+	a.NoError(err)
+	a.True(strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$"), encoded)
+
+	again, err := h.Hash("correct horse")
+	a.NoError(err)
+	a.NotEqual(encoded, again)
+
+	a.NoError(h.Verify("correct horse", encoded))
+	a.Equal(ErrMismatch, h.Verify("battery staple", encoded))
+	a.False(h.NeedsRehash(encoded))
+
+	a.True(NewArgon2id(&Argon2idParams{Memory: 2048, Iterations: 1, Parallelism: 1}).NeedsRehash(encoded))
+	a.True(NewArgon2id(&Argon2idParams{Memory: 1024, Iterations: 1, Parallelism: 1, KeyLength: 16}).NeedsRehash(encoded))
+	// 参数变更之后已有的哈希仍可以校验
+	a.NoError(NewArgon2id(nil).Verify("correct horse", encoded))
+
+	a.Equal(ErrUnsupported, h.Verify("correct horse", "plain"))
+	a.Error(h.Verify("correct horse", "$argon2id$v=18$m=1024,t=1,p=1$c2FsdA$a2V5"))
+	a.Error(h.Verify("correct horse", "$argon2id$v=19$m=0,t=1,p=1$c2FsdA$a2V5"))
+	a.Error(h.Verify("correct horse", "$argon2id$v=19$m=1024,t=1,p=1$!!$a2V5"))
+	// 超出上限的参数在计算哈希之前即被拒绝
+	a.Error(Verify("correct horse", "$argon2id$v=19$m=4194304,t=1,p=1$c2FsdA$a2V5"))
+	a.Error(Verify("correct horse", "$argon2id$v=19$m=1024,t=1000,p=1$c2FsdA$a2V5"))
+	a.Error(Verify("correct horse", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$"+base64.RawStdEncoding.EncodeToString(make([]byte, 129))))
+	a.Error(Verify("correct horse", "$argon2id$v=19$m=1024,t=1,p=17$c2FsdA$a2V5"))
+	// 计算之后只会返回 ErrMismatch, 返回参数超出上限的异常说明哈希未被计算
+	start := time.Now()
+	err = Verify("correct horse", "$argon2id$v=19$m=262145,t=10,p=16$c2FsdA$a2V5")
+	if a.Error(err) {
+		a.NotEqual(ErrMismatch, err)
+		a.Contains(err.Error(), "超出上限")
+	}
+	a.Less(time.Since(start), 100*time.Millisecond)
+	a.True(h.NeedsRehash("plain"))
+}
+
+func TestBcrypt(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := NewBcrypt(bcrypt.MaxCost + 1)
+	a.Error(err)
+
+	h, err := NewBcrypt(bcrypt.MinCost)
+	a.NoError(err)
+	encoded, err := h.Hash("correct horse")
+	a.NoError(err)
+
+	a.NoError(h.Verify("correct horse", encoded))
+	a.Equal(ErrMismatch, h.Verify("battery staple", encoded))
+	a.False(h.NeedsRehash(encoded))
+
+	stronger, err := NewBcrypt(bcrypt.MinCost + 1)
+	a.NoError(err)
+	a.True(stronger.NeedsRehash(encoded))
+
+	_, err = h.Hash(strings.Repeat("a", 73))
+	a.Error(err)
+	a.Equal(ErrUnsupported, h.Verify("correct horse", "$argon2id$"))
+}
+
+func TestVerifyAndRehash(t *testing.T) {
+	a := assert.New(t)
+
+	defer SetDefault(Default())
+	SetDefault(NewArgon2id(testArgon2idParams))
+
+	legacy, err := NewBcrypt(bcrypt.MinCost)
+	a.NoError(err)
+	encoded, err := legacy.Hash("correct horse")
+	a.NoError(err)
+
+	a.NoError(Verify("correct horse", encoded))
+	a.True(NeedsRehash(encoded))
+
+	_, err = VerifyAndRehash("battery staple", encoded)
+	a.Equal(ErrMismatch, err)
+
+	upgraded, err := VerifyAndRehash("correct horse", encoded)
+	a.NoError(err)
+	a.True(strings.HasPrefix(upgraded, "$argon2id$"), upgraded)
+	a.False(NeedsRehash(upgraded))
+
+	rehashed, err := VerifyAndRehash("correct horse", upgraded)
+	a.NoError(err)
+	a.Empty(rehashed)
+
+	encoded, err = Hash("correct horse")
+	a.NoError(err)
+	a.NoError(Verify("correct horse", encoded))
+	a.Equal(ErrUnsupported, Verify("correct horse", "md5:abc"))
+}
+
+func TestPolicy(t *testing.T) {
+	a := assert.New(t)
+
+	a.Nil(Validate("river7stone"))
+
+	errInfo := Validate("Alice1")
+	if a.NotNil(errInfo) {
+		a.Equal(commonErrors.ErrCodeValidation, errInfo.Code)
+		violations, ok := Violations(errInfo)
+		if a.True(ok) && a.Len(violations, 1) {
+			a.Equal(RuleMinLength, violations[0].Rule)
+		}
+	}
+
+	errInfo = Validate("Password1")
+	if a.NotNil(errInfo) {
+		violations, _ := Violations(errInfo)
+		a.Equal([]Violation{{Rule: RuleCommon, Message: "不能使用常见的弱密码"}}, violations)
+	}
+
+	errInfo = Validate("alice2024!", "Alice", "a")
+	if a.NotNil(errInfo) {
+		violations, _ := Violations(errInfo)
+		if a.Len(violations, 1) {
+			a.Equal(RuleUserInput, violations[0].Rule)
+		}
+	}
+
+	strict := &Policy{
+		MinLength:     10,
+		MaxLength:     12,
+		RequireUpper:  true,
+		RequireLower:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+		Blocklist:     []string{"TeamManagement"},
+	}
+	var rules []string
+	for _, v := range strict.Check("teammanagement") {
+		rules = append(rules, v.Rule)
+	}
+	a.Equal([]string{RuleMaxLength, RuleUpper, RuleDigit, RuleSymbol, RuleCommon}, rules)
+	a.Empty(strict.Check("Räuber-42x!"))
+	// 长度按字符计算
+	a.Empty((&Policy{MaxLength: 4}).Check("密码强度"))
+
+	_, ok := Violations(commonErrors.ErrCodeValidation.New("参数错误"))
+	a.False(ok)
+}
@@ -0,0 +1,186 @@
+package password
+
+import (
+	"fmt"
+	transportstream "github.com/go-base-lib/transport-stream"
+	commonErrors "github.com/teamManagement/common/errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// RuleMinLength 长度不足
+	RuleMinLength = "minLength"
+	// RuleMaxLength 长度超出限制
+	RuleMaxLength = "maxLength"
+	// RuleUpper 缺少大写字母
+	RuleUpper = "upper"
+	// RuleLower 缺少小写字母
+	RuleLower = "lower"
+	// RuleDigit 缺少数字
+	RuleDigit = "digit"
+	// RuleSymbol 缺少特殊字符
+	RuleSymbol = "symbol"
+	// RuleCommon 属于常见的弱密码
+	RuleCommon = "common"
+	// RuleUserInput 包含用户名等用户信息
+	RuleUserInput = "userInput"
+)
+
+// Violation 密码不满足的一条规则
+type Violation struct {
+	// Rule 规则名称, 例如 RuleMinLength
+	Rule string `json:"rule"`
+	// Message 规则的描述
+	Message string `json:"message"`
+}
+
+// Policy 密码强度策略, 长度按字符计算
+type Policy struct {
+	// MinLength 最小长度, 为0时不限制
+	MinLength int
+	// MaxLength 最大长度, 为0时不限制
+	MaxLength int
+	// RequireUpper 是否必须包含大写字母
+	RequireUpper bool
+	// RequireLower 是否必须包含小写字母
+	RequireLower bool
+	// RequireDigit 是否必须包含数字
+	RequireDigit bool
+	// RequireSymbol 是否必须包含字母及数字之外的字符
+	RequireSymbol bool
+	// RejectCommon 是否拒绝常见的弱密码
+	RejectCommon bool
+	// Blocklist 额外禁止使用的密码, 忽略大小写
+	Blocklist []string
+}
+
+// DefaultPolicy 默认的密码强度策略
+var DefaultPolicy = &Policy{
+	MinLength:    8,
+	MaxLength:    64,
+	RequireLower: true,
+	RequireDigit: true,
+	RejectCommon: true,
+}
+
+// minUserInputLength 用户信息短于该长度时不检查密码中是否包含
+const minUserInputLength = 3
+
+// commonPasswords 常见的弱密码, 均为小写
+var commonPasswords = map[string]struct{}{
+	"password": {}, "password1": {}, "password123": {}, "passw0rd": {}, "p@ssw0rd": {},
+	"12345678": {}, "123456789": {}, "1234567890": {}, "11111111": {}, "00000000": {},
+	"qwerty123": {}, "qwertyuiop": {}, "1q2w3e4r": {}, "1qaz2wsx": {}, "abc12345": {},
+	"abcd1234": {}, "admin123": {}, "iloveyou": {}, "welcome1": {}, "letmein1": {},
+	"asdf1234": {}, "zxcvbnm1": {}, "a1b2c3d4": {}, "qwe12345": {}, "aa123456": {},
+}
+
+// Validate 检查密码是否满足策略, userInputs 为用户名、邮箱等不应出现在密码中的用户信息;
+// 不满足时返回 errors.ErrCodeValidation 异常, 异常数据中携带所有不满足的规则, 可通过 Violations 获取
+func (p *Policy) Validate(password string, userInputs ...string) *transportstream.ErrInfo {
+	violations := p.Check(password, userInputs...)
+	if len(violations) == 0 {
+		return nil
+	}
+
+	messages := make([]string, 0, len(violations))
+	for _, v := range violations {
+		messages = append(messages, v.Message)
+	}
+	msg := "密码强度不足: " + strings.Join(messages, "; ")
+	errInfo, err := commonErrors.ErrCodeValidation.NewWithData(msg, violations)
+	if err != nil {
+		return commonErrors.ErrCodeValidation.New(msg)
+	}
+	return errInfo
+}
+
+// Check 获取密码不满足的所有规则, 满足策略时返回空
+func (p *Policy) Check(password string, userInputs ...string) []Violation {
+	var violations []Violation
+	add := func(rule, message string) {
+		violations = append(violations, Violation{Rule: rule, Message: message})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if p.MinLength > 0 && length < p.MinLength {
+		add(RuleMinLength, fmt.Sprintf("长度不能少于 %d 个字符", p.MinLength))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		add(RuleMaxLength, fmt.Sprintf("长度不能超出 %d 个字符", p.MaxLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r) && !unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		add(RuleUpper, "必须包含大写字母")
+	}
+	if p.RequireLower && !lower {
+		add(RuleLower, "必须包含小写字母")
+	}
+	if p.RequireDigit && !digit {
+		add(RuleDigit, "必须包含数字")
+	}
+	if p.RequireSymbol && !symbol {
+		add(RuleSymbol, "必须包含特殊字符")
+	}
+
+	lowered := strings.ToLower(password)
+	if p.isBlocked(lowered) {
+		add(RuleCommon, "不能使用常见的弱密码")
+	}
+	for _, input := range userInputs {
+		input = strings.ToLower(strings.TrimSpace(input))
+		if utf8.RuneCountInString(input) >= minUserInputLength && strings.Contains(lowered, input) {
+			add(RuleUserInput, "不能包含用户名等个人信息")
+			break
+		}
+	}
+	return violations
+}
+
+func (p *Policy) isBlocked(lowered string) bool {
+	if p.RejectCommon {
+		if _, ok := commonPasswords[lowered]; ok {
+			return true
+		}
+	}
+	for _, blocked := range p.Blocklist {
+		if strings.ToLower(blocked) == lowered {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate 使用 DefaultPolicy 检查密码
+func Validate(password string, userInputs ...string) *transportstream.ErrInfo {
+	return DefaultPolicy.Validate(password, userInputs...)
+}
+
+// Violations 从 Validate 返回的异常中获取不满足的规则, 异常中未携带规则时返回false
+func Violations(err error) ([]Violation, bool) {
+	errInfo, ok := transportstream.ErrConvert(err)
+	if !ok || errInfo.Code != commonErrors.ErrCodeValidation || errInfo.RawData == nil {
+		return nil, false
+	}
+
+	var violations []Violation
+	if e := errInfo.UnmarshalData(&violations); e != nil || len(violations) == 0 {
+		return nil, false
+	}
+	return violations, true
+}
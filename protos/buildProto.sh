@@ -1,2 +1,3 @@
 #/bin/bash
-protoc --gogo_out=. *.proto
\ No newline at end of file
+# service 定义的命令代码需要安装 protoc-gen-teamcmd: go install github.com/teamManagement/common/cmd/protoc-gen-teamcmd
+protoc --gogo_out=. --teamcmd_out=. *.proto
\ No newline at end of file
@@ -0,0 +1,304 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	transportstream "github.com/go-base-lib/transport-stream"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Side 记录者在会话中的角色
+type Side string
+
+const (
+	// SideClient 记录者为客户端
+	SideClient Side = "client"
+	// SideServer 记录者为服务端
+	SideServer Side = "server"
+)
+
+// Direction 消息相对于记录者的方向
+type Direction string
+
+const (
+	// DirectionSend 记录者发送的消息
+	DirectionSend Direction = "send"
+	// DirectionReceive 记录者接收的消息
+	DirectionReceive Direction = "receive"
+)
+
+// FlagRaw 不属于任何消息帧的单字节数据, 例如 conn.Wrapper 的 WriteByte 及 ReadByte
+const FlagRaw byte = 0xFF
+
+// Entry 一条记录的消息
+type Entry struct {
+	// Stream 消息所属的流, 同一个记录文件中唯一
+	Stream uint64 `json:"stream"`
+	// Side 记录者的角色
+	Side Side `json:"side"`
+	// Direction 消息的方向
+	Direction Direction `json:"direction"`
+	// Time 记录时间
+	Time time.Time `json:"time"`
+	// Flag 消息标识, transportstream 消息为 MsgFlag, conn.Wrapper 消息为 MessageType
+	Flag byte `json:"flag"`
+	// Payload 消息内容
+	Payload []byte `json:"payload,omitempty"`
+}
+
+// FromClient 消息是否由客户端发送
+func (e *Entry) FromClient() bool {
+	return (e.Side == SideClient) == (e.Direction == DirectionSend)
+}
+
+// Redactor 在消息写入记录之前对其脱敏, 每个流使用独立的 Redactor, 按消息的解析顺序调用
+type Redactor interface {
+	Redact(entry *Entry)
+}
+
+// Recorder 将消息以 JSON Lines 格式写入文件, 可被多个流并发使用
+type Recorder struct {
+	streamSeq uint64
+
+	lock        sync.Mutex
+	w           *bufio.Writer
+	closer      io.Closer
+	err         error
+	newRedactor func() Redactor
+}
+
+// NewRecorder 创建写入 w 的记录器
+func NewRecorder(w io.Writer) *Recorder {
+	rec := &Recorder{w: bufio.NewWriter(w)}
+	if closer, ok := w.(io.Closer); ok {
+		rec.closer = closer
+	}
+	return rec
+}
+
+// Create 创建写入文件的记录器, 文件已存在时追加写入, 记录中包含完整的消息内容, 文件仅当前用户可读写
+func Create(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("打开记录文件失败: %s", err.Error())
+	}
+	return NewRecorder(f), nil
+}
+
+// NewStream 分配新的流编号
+func (r *Recorder) NewStream() uint64 {
+	return atomic.AddUint64(&r.streamSeq, 1)
+}
+
+// Record 写入一条记录, 写入失败之后的记录将被忽略, 异常通过 Err 获取
+func (r *Recorder) Record(entry *Entry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.err != nil {
+		return
+	}
+
+	marshal, err := json.Marshal(entry)
+	if err != nil {
+		r.err = err
+		return
+	}
+	marshal = append(marshal, '\n')
+	if _, err = r.w.Write(marshal); err != nil {
+		r.err = err
+		return
+	}
+	r.err = r.w.Flush()
+}
+
+// Err 写入记录时发生的第一个异常
+func (r *Recorder) Err() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.err
+}
+
+// Close 关闭记录器, 底层的 io.Writer 实现了 io.Closer 时一并关闭
+func (r *Recorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	err := r.w.Flush()
+	if r.closer != nil {
+		if e := r.closer.Close(); err == nil {
+			err = e
+		}
+	}
+	if r.err == nil {
+		r.err = fmt.Errorf("记录器已关闭")
+	}
+	return err
+}
+
+// SetRedactor 设置为每个流创建 Redactor 的函数, 之后包装的流中的消息均经过脱敏再写入记录,
+// 脱敏之后的记录回放时发送的数据与原始数据不同
+func (r *Recorder) SetRedactor(newRedactor func() Redactor) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.newRedactor = newRedactor
+}
+
+// Wrap 包装传输 transportstream 消息的 rw, 读写时解析出每条消息并记录为同一个流
+func (r *Recorder) Wrap(rw io.ReadWriter, side Side) io.ReadWriter {
+	s := &streamRecorder{
+		rw:     rw,
+		rec:    r,
+		stream: r.NewStream(),
+		side:   side,
+	}
+
+	s.redactor = r.NewRedactor()
+	return s
+}
+
+// NewRedactor 使用 SetRedactor 设置的函数为一个新的流创建 Redactor, 未设置时返回nil,
+// 用于不经过 Wrap 自行调用 Record 的场景
+func (r *Recorder) NewRedactor() Redactor {
+	r.lock.Lock()
+	newRedactor := r.newRedactor
+	r.lock.Unlock()
+	if newRedactor == nil {
+		return nil
+	}
+	return newRedactor()
+}
+
+// NewTransportStream 创建记录所有消息的 transportstream.Stream
+func (r *Recorder) NewTransportStream(rw io.ReadWriter, side Side) *transportstream.Stream {
+	rw = r.Wrap(rw, side)
+	return transportstream.NewStream(bufio.NewReadWriter(bufio.NewReader(rw), bufio.NewWriter(rw)))
+}
+
+type streamRecorder struct {
+	rw     io.ReadWriter
+	rec    *Recorder
+	stream uint64
+	side   Side
+
+	readLock  sync.Mutex
+	read      frameParser
+	writeLock sync.Mutex
+	write     frameParser
+
+	redactLock sync.Mutex
+	redactor   Redactor
+}
+
+func (s *streamRecorder) Read(p []byte) (int, error) {
+	n, err := s.rw.Read(p)
+	if n > 0 {
+		s.readLock.Lock()
+		s.read.feed(p[:n], s.emit(DirectionReceive))
+		s.readLock.Unlock()
+	}
+	return n, err
+}
+
+func (s *streamRecorder) Write(p []byte) (int, error) {
+	n, err := s.rw.Write(p)
+	if n > 0 {
+		s.writeLock.Lock()
+		s.write.feed(p[:n], s.emit(DirectionSend))
+		s.writeLock.Unlock()
+	}
+	return n, err
+}
+
+func (s *streamRecorder) emit(direction Direction) func(flag byte, payload []byte) {
+	return func(flag byte, payload []byte) {
+		entry := &Entry{
+			Stream:    s.stream,
+			Side:      s.side,
+			Direction: direction,
+			Flag:      flag,
+			Payload:   payload,
+		}
+		if s.redactor != nil {
+			s.redactLock.Lock()
+			s.redactor.Redact(entry)
+			s.redactLock.Unlock()
+		}
+		s.rec.Record(entry)
+	}
+}
+
+// frameParser 从字节流中解析 transportstream 消息, 格式为 长度(8字节) + 标识(1字节) + 内容
+type frameParser struct {
+	buf bytes.Buffer
+}
+
+func (f *frameParser) feed(p []byte, emit func(flag byte, payload []byte)) {
+	f.buf.Write(p)
+	for f.buf.Len() >= 8 {
+		frameLen := binary.BigEndian.Uint64(f.buf.Bytes()[:8])
+		// 消息至少包含标识, 长度为0的帧格式错误, 跳过其长度继续解析之后的数据
+		if frameLen == 0 {
+			f.buf.Next(8)
+			continue
+		}
+		if uint64(f.buf.Len()-8) < frameLen {
+			return
+		}
+
+		f.buf.Next(8)
+		frame := append([]byte(nil), f.buf.Next(int(frameLen))...)
+		emit(frame[0], frame[1:])
+	}
+}
+
+// Decode 读取 JSON Lines 格式的记录
+func Decode(r io.Reader) ([]*Entry, error) {
+	var entries []*Entry
+	decoder := json.NewDecoder(r)
+	for {
+		var entry *Entry
+		if err := decoder.Decode(&entry); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("解析第%d条记录失败: %s", len(entries)+1, err.Error())
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// Load 读取记录文件
+func Load(path string) ([]*Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("打开记录文件失败: %s", err.Error())
+	}
+	defer f.Close()
+	return Decode(f)
+}
+
+// GroupByStream 按流分组, 分组按流首次出现的顺序排列, 组内保持记录顺序
+func GroupByStream(entries []*Entry) [][]*Entry {
+	var groups [][]*Entry
+	index := map[uint64]int{}
+	for _, entry := range entries {
+		i, ok := index[entry.Stream]
+		if !ok {
+			i = len(groups)
+			index[entry.Stream] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], entry)
+	}
+	return groups
+}
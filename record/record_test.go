@@ -0,0 +1,118 @@
+package record
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func TestWrap(t *testing.T) {
+	a := assert.New(t)
+
+	buf := &bytes.Buffer{}
+	rec := NewRecorder(buf)
+
+	peer := &bytes.Buffer{}
+	rw := rec.Wrap(struct {
+		io.Reader
+		io.Writer
+	}{peer, peer}, SideClient)
+
+	// 一条消息被拆分为多次写入, 完整之后才记录
+	frame := []byte{0, 0, 0, 0, 0, 0, 0, 3, 1, 'o', 'k'}
+	_, err := rw.Write(frame[:5])
+	a.NoError(err)
+	_, err = rw.Write(frame[5:])
+	a.NoError(err)
+
+	read := make([]byte, len(frame))
+	_, err = io.ReadFull(rw, read)
+	a.NoError(err)
+
+	a.NoError(rec.Err())
+	entries, err := Decode(buf)
+	if !a.NoError(err) || !a.Len(entries, 2) {
+		return
+	}
+
+	a.Equal(DirectionSend, entries[0].Direction)
+	a.True(entries[0].FromClient())
+	a.Equal(byte(1), entries[0].Flag)
+	a.Equal([]byte("ok"), entries[0].Payload)
+
+	a.Equal(DirectionReceive, entries[1].Direction)
+	a.False(entries[1].FromClient())
+	a.Equal(entries[0].Stream, entries[1].Stream)
+}
+
+func TestFrameParserEmptyFrame(t *testing.T) {
+	a := assert.New(t)
+
+	var frames [][]byte
+	parser := &frameParser{}
+	// 长度为0的帧被跳过, 不影响之后的消息
+	parser.feed([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 1, 'a'}, func(flag byte, payload []byte) {
+		frames = append(frames, append([]byte{flag}, payload...))
+	})
+	a.Equal([][]byte{{1, 'a'}}, frames)
+	a.Zero(parser.buf.Len())
+}
+
+func TestGroupByStream(t *testing.T) {
+	a := assert.New(t)
+
+	groups := GroupByStream([]*Entry{
+		{Stream: 2, Flag: 1},
+		{Stream: 1, Flag: 1},
+		{Stream: 2, Flag: 2},
+	})
+	if a.Len(groups, 2) {
+		a.Len(groups[0], 2)
+		a.Equal(uint64(2), groups[0][0].Stream)
+		a.Equal(byte(2), groups[0][1].Flag)
+		a.Len(groups[1], 1)
+	}
+}
+
+type maskRedactor struct {
+	count int
+}
+
+func (m *maskRedactor) Redact(entry *Entry) {
+	m.count++
+	entry.Payload = []byte("masked")
+}
+
+func TestSetRedactor(t *testing.T) {
+	a := assert.New(t)
+
+	buf := &bytes.Buffer{}
+	rec := NewRecorder(buf)
+	var redactors []*maskRedactor
+	rec.SetRedactor(func() Redactor {
+		redactor := &maskRedactor{}
+		redactors = append(redactors, redactor)
+		return redactor
+	})
+
+	peer := &bytes.Buffer{}
+	rw := rec.Wrap(struct {
+		io.Reader
+		io.Writer
+	}{peer, peer}, SideClient)
+	frame := []byte{0, 0, 0, 0, 0, 0, 0, 7, 1, 's', 'e', 'c', 'r', 'e', 't'}
+	_, err := rw.Write(frame)
+	a.NoError(err)
+	// 写入对端的数据不受脱敏影响
+	a.Equal(frame, peer.Bytes())
+
+	a.NoError(rec.Err())
+	entries, err := Decode(buf)
+	if a.NoError(err) && a.Len(entries, 1) {
+		a.Equal([]byte("masked"), entries[0].Payload)
+	}
+	if a.Len(redactors, 1) {
+		a.Equal(1, redactors[0].count)
+	}
+}